@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"os"
+
 	"github.com/italia/developers-italia-backend/crawler/crawler"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func init() {
@@ -39,4 +42,17 @@ var crawlCmd = &cobra.Command{
 		if err != nil {
 			log.Errorf("Error while exporting data for Jekyll: %v", err)
 		}
+
+		exitOnInvalid(c)
 	}}
+
+// exitOnInvalid exits with code 1 if FAIL_ON_INVALID is set and any of the crawled files was invalid.
+func exitOnInvalid(c *crawler.Crawler) {
+	if !viper.GetBool("FAIL_ON_INVALID") {
+		return
+	}
+	if n := c.InvalidFiles(); n > 0 {
+		log.Errorf("%d invalid files in the crawl", n)
+		os.Exit(1)
+	}
+}
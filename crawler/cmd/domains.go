@@ -15,9 +15,12 @@ func init() {
 }
 
 var domainsCmd = &cobra.Command{
-	Use:   "domains",
-	Short: "List all the Domains.",
-	Long:  `List all the Domains from domains.yml`,
+	Use:     "domains",
+	Aliases: []string{"list-domains"},
+	Short:   "List all the Domains.",
+	Long: `List all the Domains from domains.yml, with their client API and whether
+they have credentials. The Domains without a registered client API are flagged,
+their organizations would not be crawled.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Read and parse the whitelist.
 		domains, err := crawler.ReadAndParseDomains("domains.yml")
@@ -28,20 +31,32 @@ var domainsCmd = &cobra.Command{
 		var data [][]string
 
 		// Process every item in domains.
+		missing := 0
 		for _, domain := range domains {
-			// Check if basicAuth is set.
-			basicAuth := "no"
-			if len(domain.BasicAuth) > 0 {
-				basicAuth = "yes"
+			// Check if basicAuth (or the headers of the files) is set, without showing them.
+			auth := "no"
+			if len(domain.BasicAuth) > 0 || len(domain.RawHeaders) > 0 {
+				auth = "yes"
 			}
-			data = append(data, []string{domain.Host, basicAuth})
+			// Check if the client API is registered.
+			clientAPI := domain.API()
+			if _, err := crawler.GetClientAPICrawler(clientAPI); err != nil {
+				clientAPI += " (MISSING)"
+				missing++
+			}
+			// The ID is the folder of the files of the Domain, its host if unset.
+			id := domain.ID
+			if id == "" {
+				id = domain.Host
+			}
+			data = append(data, []string{id, domain.Host, clientAPI, auth})
 
 		}
 
 		// Write data and render as table in os.Stdout.
 		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{"ID", "BasicAuth?"})
-		table.SetFooter([]string{"Total Domains: " + strconv.Itoa(len(domains)), ""})
+		table.SetHeader([]string{"ID", "Host", "Client API", "Auth?"})
+		table.SetFooter([]string{"Total Domains: " + strconv.Itoa(len(domains)), "", "Missing: " + strconv.Itoa(missing), ""})
 		table.SetRowLine(true)
 		table.AppendBulk(data)
 		table.Render()
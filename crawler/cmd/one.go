@@ -11,14 +11,20 @@ func init() {
 }
 
 var oneCmd = &cobra.Command{
-	Use:   "one [repo url]",
+	Use:   "one [repo url] | [source] [full name]",
 	Short: "Crawl publiccode.yml from one single [repo url].",
-	Long: `Crawl publiccode.yml from a single repository defined with [repo url].
+	Long: `Crawl publiccode.yml from a single repository defined with [repo url],
+or with its [source] host and [full name] (e.g. github.com italia/developers-italia-backend).
 No organizations! Only single repositories!`,
-	Args: cobra.ExactArgs(1),
+	Args: cobra.RangeArgs(1, 2),
 	Run: func(cmd *cobra.Command, args []string) {
 		c := crawler.NewCrawler()
-		err := c.CrawlRepo(args[0])
+		var err error
+		if len(args) == 2 {
+			err = c.ProcessSingleRepo(args[0], args[1])
+		} else {
+			err = c.CrawlRepo(args[0])
+		}
 		if err != nil {
 			log.Error(err)
 		}
@@ -28,5 +34,7 @@ No organizations! Only single repositories!`,
 		if err != nil {
 			log.Errorf("Error while exporting data for Jekyll: %v", err)
 		}
+
+		exitOnInvalid(c)
 	},
 }
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/italia/developers-italia-backend/crawler/crawler"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate [file or url]",
+	Short: "Validate a single publiccode.yml.",
+	Long: `Validate a single publiccode.yml, at a local path or an http(s) url,
+with the same validator of the crawler. The exit code is 1 if the file is invalid.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		validationErrs, warnings, err := crawler.ValidateOne(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		result := struct {
+			File     string                   `json:"file"`
+			Valid    bool                     `json:"valid"`
+			Errors   crawler.ValidationErrors `json:"errors,omitempty"`
+			Warnings crawler.ValidationErrors `json:"warnings,omitempty"`
+		}{args[0], validationErrs == nil, validationErrs, warnings}
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+
+		if !result.Valid {
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/italia/developers-italia-backend/crawler/crawler"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(verifyManifestCmd)
+}
+
+var verifyManifestCmd = &cobra.Command{
+	Use:   "verify-manifest",
+	Short: "Verify the saved files against the manifest.",
+	Long: `Verify the files saved in the data directory against the checksums and sizes
+in its manifest.json. The exit code is 1 if any file is missing or doesn't match.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		mismatches, err := crawler.VerifyManifest(viper.GetString("CRAWLER_DATADIR"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		out, err := json.MarshalIndent(mismatches, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(out))
+
+		if len(mismatches) > 0 {
+			os.Exit(1)
+		}
+	},
+}
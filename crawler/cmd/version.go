@@ -16,6 +16,6 @@ var versionCmd = &cobra.Command{
 	Short: "Print the version number of the crawler.",
 	Long:  `All software has versions. This too.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Version " + version.VERSION)
+		fmt.Println("Version " + version.VERSION + " (commit " + version.COMMIT + ", built " + version.DATE + ")")
 	},
 }
@@ -0,0 +1,254 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	azureAPIVersion        = "6.0"
+	azureContinuationToken = "x-ms-continuationtoken"
+)
+
+// AzureRepos is the complete result from the Azure DevOps API respose for /_apis/git/repositories.
+type AzureRepos struct {
+	Value []AzureRepo `json:"value"`
+	Count int         `json:"count"`
+}
+
+// AzureRepo is a complete result from the Azure DevOps API respose for a single repository.
+type AzureRepo struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Project struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		URL        string `json:"url"`
+		State      string `json:"state"`
+		Visibility string `json:"visibility"`
+	} `json:"project"`
+	DefaultBranch string `json:"defaultBranch"`
+	Size          int    `json:"size"`
+	RemoteURL     string `json:"remoteUrl"`
+	SSHURL        string `json:"sshUrl"`
+	WebURL        string `json:"webUrl"`
+	IsDisabled    bool   `json:"isDisabled"`
+}
+
+// RegisterAzureAPI register the crawler function for Azure DevOps API.
+// It get the list of repositories on "link" url.
+// If a continuation token is returned, return the url of the next page.
+// Otherwise returns an empty ("") string.
+func RegisterAzureAPI() OrganizationHandler {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) (string, error) {
+		// Set Authorization header.
+		headers := make(map[string]string)
+		auth, err := domain.authHeader()
+		if err != nil {
+			return link, err
+		}
+		if auth != "" {
+			headers["Authorization"] = auth
+		}
+
+		// Parse url.
+		u, err := url.Parse(link)
+		if err != nil {
+			return link, err
+		}
+		// Set domain host to new host.
+		domain.Host = u.Hostname()
+
+		// Get List of repositories.
+		resp, err := getURL(ctx, link, headers)
+		if err != nil {
+			return link, err
+		}
+		if resp.Status.Code != http.StatusOK {
+			log.Warnf("Request returned: %s", string(resp.Body))
+			return "", errors.New("request returned an incorrect http.Status: " + resp.Status.Text)
+		}
+
+		// Fill response as list of values (repositories data).
+		var results AzureRepos
+		err = json.Unmarshal(resp.Body, &results)
+		if err != nil {
+			return link, err
+		}
+
+		// Add repositories to the channel that will perform the check on everyone.
+		for _, v := range results.Value {
+			err = addAzureProjectToRepositories(v, domain, pa, headers, repositories)
+			if err != nil {
+				log.Infof("addAzureProjectToRepositories %v", err)
+			}
+		}
+
+		// if last page for this project, the continuation token is empty.
+		token := resp.Headers.Get(azureContinuationToken)
+		if token == "" {
+			return "", nil
+		}
+
+		// Return next url.
+		q := u.Query()
+		q.Set("continuationToken", token)
+		u.RawQuery = q.Encode()
+
+		return u.String(), nil
+	}
+}
+
+// RegisterSingleAzureAPI register the crawler function for single repository Azure DevOps API.
+// Return nil if the repository was successfully added to repositories channel.
+// Otherwise return the generated error.
+func RegisterSingleAzureAPI() SingleRepoHandler {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) error {
+		// Set Authorization header.
+		headers := make(map[string]string)
+		auth, err := domain.authHeader()
+		if err != nil {
+			return err
+		}
+		if auth != "" {
+			headers["Authorization"] = auth
+		}
+
+		// Parse url.
+		u, err := url.Parse(link)
+		if err != nil {
+			return err
+		}
+
+		// Set domain host to new host.
+		domain.Host = u.Hostname()
+
+		// IN: https://dev.azure.com/<org>/<project>/_git/<repo>
+		// OUT: https://dev.azure.com/<org>/<project>/_apis/git/repositories/<repo>
+		u.Path = strings.Replace(strings.Trim(u.Path, "/"), "/_git/", "/_apis/git/repositories/", 1)
+		u.RawQuery = url.Values{"api-version": []string{azureAPIVersion}}.Encode()
+
+		// Get single Repo.
+		resp, err := getURL(ctx, u.String(), headers)
+		if err != nil {
+			return err
+		}
+		if resp.Status.Code != http.StatusOK {
+			log.Warnf("Request returned: %s", string(resp.Body))
+			return errors.New("request returned an incorrect http.Status: " + resp.Status.Text)
+		}
+
+		var v AzureRepo
+		err = json.Unmarshal(resp.Body, &v)
+		if err != nil {
+			return err
+		}
+
+		if v.DefaultBranch == "" {
+			return errors.New("repository is empty: " + v.WebURL)
+		}
+
+		return addAzureProjectToRepositories(v, domain, pa, headers, repositories)
+	}
+}
+
+// generateAzureRawURL returns the file Azure DevOps specific file raw url.
+func generateAzureRawURL(repoURL, defaultBranch string) (string, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, "items")
+	u.RawQuery = url.Values{
+		"path":                      []string{"/" + viper.GetString("CRAWLED_FILENAME")},
+		"versionDescriptor.version": []string{strings.TrimPrefix(defaultBranch, "refs/heads/")},
+		"$format":                   []string{"octetStream"},
+		"api-version":               []string{azureAPIVersion},
+	}.Encode()
+
+	return u.String(), err
+}
+
+// addAzureProjectToRepositories adds the project from api response to repository channel.
+func addAzureProjectToRepositories(v AzureRepo, domain Domain, pa PA, headers map[string]string, repositories chan Repository) error {
+	// If the repository was never used, there is no default branch to look into.
+	if v.IsDisabled || v.DefaultBranch == "" {
+		return nil
+	}
+
+	// Join file raw URL string.
+	rawURL, err := generateAzureRawURL(v.URL, v.DefaultBranch)
+	if err != nil {
+		return err
+	}
+
+	// Marshal all the repository metadata.
+	metadata, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("azure metadata: %v", err)
+		return err
+	}
+
+	repositories <- Repository{
+		Name:        v.Project.Name + "/" + v.Name,
+		Hostname:    domain.Host,
+		FileRawURL:  rawURL,
+		GitCloneURL: v.RemoteURL,
+		GitBranch:   strings.TrimPrefix(v.DefaultBranch, "refs/heads/"),
+		Domain:      domain,
+		Pa:          pa,
+		Headers:     headers,
+		RawHeaders:  domain.RawHeaders,
+		Metadata:    metadata,
+	}
+
+	return nil
+}
+
+// GenerateAzureAPIURL returns the api url of given Azure DevOps project link.
+// IN: https://dev.azure.com/italia/developers
+// OUT:https://dev.azure.com/italia/developers/_apis/git/repositories?api-version=6.0
+func GenerateAzureAPIURL() GeneratorAPIURL {
+	return func(in string) (out []string, err error) {
+		u, err := url.Parse(in)
+		if err != nil {
+			return []string{in}, err
+		}
+		u.Path = path.Join(u.Path, "_apis/git/repositories")
+		u.RawQuery = url.Values{"api-version": []string{azureAPIVersion}}.Encode()
+
+		out = append(out, u.String())
+		return
+	}
+}
+
+// IsAzure returns "true" if the url can use Azure DevOps API.
+func IsAzure(link string) bool {
+	if len(link) == 0 {
+		log.Errorf("IsAzure: empty link %s.", link)
+		return false
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		log.Errorf("IsAzure: impossible to parse %s.", link)
+		return false
+	}
+
+	if u.Hostname() == "dev.azure.com" || strings.HasSuffix(u.Hostname(), ".visualstudio.com") {
+		log.Debugf("can %s use Azure DevOps API? Yes.", link)
+		return true
+	}
+
+	log.Debugf("can %s use Azure DevOps API? No.", link)
+	return false
+}
@@ -0,0 +1,33 @@
+package crawler
+
+import (
+	"io/ioutil"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GenerateAzureAPIURL returns the api url of given Azure DevOps project link.
+// IN: https://dev.azure.com/italia/developers
+// OUT:https://dev.azure.com/italia/developers/_apis/git/repositories?api-version=6.0
+func TestGenerateAzureAPIURL(t *testing.T) {
+	// Disablle log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	links := []struct {
+		in  string
+		out string
+	}{
+		{"https://dev.azure.com/italia/developers", "https://dev.azure.com/italia/developers/_apis/git/repositories?api-version=6.0"},
+		{":unparsable", ":unparsable"},
+	}
+
+	for _, l := range links {
+		genURL := GenerateAzureAPIURL()
+		if out, err := genURL(l.in); out[0] != l.out {
+			t.Logf("Expected %s == %s: %v ", out[0], l.out, err)
+			t.Fail()
+		}
+	}
+
+}
@@ -1,14 +1,15 @@
 package crawler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
 	"time"
 
-	"github.com/italia/developers-italia-backend/crawler/httpclient"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -181,7 +182,7 @@ type Links struct {
 
 // RegisterBitbucketAPI register the crawler function for Bitbucket API.
 func RegisterBitbucketAPI() OrganizationHandler {
-	return func(domain Domain, link string, repositories chan Repository, pa PA) (string, error) {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) (string, error) {
 		// Set BasicAuth header.
 		headers := make(map[string]string)
 		if domain.BasicAuth != nil {
@@ -201,7 +202,7 @@ func RegisterBitbucketAPI() OrganizationHandler {
 		domain.Host = u.Hostname()
 
 		// Get List of repositories.
-		resp, err := httpclient.GetURL(link, headers)
+		resp, err := getURL(ctx, link, headers)
 		if err != nil {
 			return link, err
 		}
@@ -219,33 +220,15 @@ func RegisterBitbucketAPI() OrganizationHandler {
 
 		// Add repositories to the channel that will perform the check on everyone.
 		for _, v := range result.Values {
-
-			// Join file raw URL.
-			u, err := url.Parse(v.Links.HTML.Href)
-			if err != nil {
-				return link, err
-			}
-			u.Path = path.Join(u.Path, "raw", v.Mainbranch.Name, viper.GetString("CRAWLED_FILENAME"))
-
 			// Marshal all the repository metadata.
 			metadata, err := json.Marshal(v)
 			if err != nil {
 				log.Errorf("bitbucket metadata: %v", err)
 			}
 
-			// If the repository was never used, the Mainbranch is empty ("").
-			if v.Mainbranch.Name != "" {
-				repositories <- Repository{
-					Name:        v.FullName,
-					Hostname:    u.Hostname(),
-					FileRawURL:  u.String(),
-					GitCloneURL: v.Links.Clone[0].Href,
-					GitBranch:   v.Mainbranch.Name,
-					Domain:      domain,
-					Pa:          pa,
-					Headers:     headers,
-					Metadata:    metadata,
-				}
+			err = addBitbucketProjectToRepositories(v.FullName, v.Mainbranch.Name, v.Links, metadata, domain, pa, headers, repositories)
+			if err != nil {
+				log.Infof("addBitbucketProjectToRepositories %v", err)
 			}
 		}
 
@@ -261,7 +244,7 @@ func RegisterBitbucketAPI() OrganizationHandler {
 
 // RegisterSingleBitbucketAPI register the crawler function for single Bitbucket repository.
 func RegisterSingleBitbucketAPI() SingleRepoHandler {
-	return func(domain Domain, link string, repositories chan Repository, pa PA) error {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) error {
 		// Set BasicAuth header
 		headers := make(map[string]string)
 		if domain.BasicAuth != nil {
@@ -287,7 +270,7 @@ func RegisterSingleBitbucketAPI() SingleRepoHandler {
 		linkRepo := u.String()
 
 		// Get single Repo
-		resp, err := httpclient.GetURL(linkRepo, headers)
+		resp, err := getURL(ctx, linkRepo, headers)
 		if err != nil {
 			return err
 		}
@@ -303,36 +286,84 @@ func RegisterSingleBitbucketAPI() SingleRepoHandler {
 			return err
 		}
 
-		// Join file raw URL.
-		u, err = url.Parse(link)
-		if err != nil {
-			return err
-		}
-		fullURL := path.Join(u.Hostname(), result.FullName, "raw", result.Mainbranch.Name, viper.GetString("CRAWLED_FILENAME"))
-
 		// Marshal all the repository metadata.
 		metadata, err := json.Marshal(result)
 		if err != nil {
 			log.Errorf("bitbucket metadata: %v", err)
 		}
+
 		// If the repository was never used, the Mainbranch is empty ("").
-		if result.Mainbranch.Name != "" {
-			repositories <- Repository{
-				Name:       result.FullName,
-				Hostname:   u.Hostname(),
-				FileRawURL: "https://" + fullURL,
-				GitBranch:  result.Mainbranch.Name,
-				Domain:     domain,
-				Pa:         pa,
-				Headers:    headers,
-				Metadata:   metadata,
-			}
-		} else {
+		if result.Mainbranch.Name == "" {
 			return errors.New("repository is: empty")
 		}
+		if result.Links.Self.Href == "" {
+			result.Links.Self.Href = linkRepo
+		}
 
+		return addBitbucketProjectToRepositories(result.FullName, result.Mainbranch.Name, result.Links, metadata, domain, pa, headers, repositories)
+	}
+}
+
+// generateBitbucketRawURL returns the file Bitbucket specific file raw url,
+// served by the /src endpoint of the repository API.
+// IN: https://api.bitbucket.org/2.0/repositories/Soft/repo
+// OUT: https://api.bitbucket.org/2.0/repositories/Soft/repo/src/master/publiccode.yml
+func generateBitbucketRawURL(repoAPIURL, mainbranch string) (string, error) {
+	u, err := url.Parse(repoAPIURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, "src", mainbranch, viper.GetString("CRAWLED_FILENAME"))
+
+	return u.String(), err
+}
+
+// bitbucketCloneURL returns the https clone url of the repository links, if any.
+func bitbucketCloneURL(links Links) string {
+	for _, clone := range links.Clone {
+		if clone.Name == "https" {
+			return clone.Href
+		}
+	}
+	return ""
+}
+
+// addBitbucketProjectToRepositories adds the repository (named workspace/repo_slug) to repositories channel.
+func addBitbucketProjectToRepositories(fullName, mainbranch string, links Links, metadata []byte, domain Domain, pa PA, headers map[string]string, repositories chan Repository) error {
+	// If the repository was never used, the Mainbranch is empty ("").
+	if mainbranch == "" {
 		return nil
 	}
+
+	rawURL, err := generateBitbucketRawURL(links.Self.Href, mainbranch)
+	if err != nil {
+		return err
+	}
+
+	// The files are saved under the web hostname (bitbucket.org), not the API one.
+	u, err := url.Parse(links.HTML.Href)
+	if err != nil {
+		return err
+	}
+	hostname := u.Hostname()
+	if hostname == "" {
+		hostname = strings.TrimPrefix(domain.Host, "api.")
+	}
+
+	repositories <- Repository{
+		Name:        fullName,
+		Hostname:    hostname,
+		FileRawURL:  rawURL,
+		GitCloneURL: bitbucketCloneURL(links),
+		GitBranch:   mainbranch,
+		Domain:      domain,
+		Pa:          pa,
+		Headers:     headers,
+		RawHeaders:  domain.RawHeaders,
+		Metadata:    metadata,
+	}
+
+	return nil
 }
 
 // GenerateBitbucketAPIURL returns the api url of given Bitbucket  organization link.
@@ -367,7 +398,7 @@ func IsBitbucket(link string) bool {
 	u.Path = "2.0/hook_events"
 	u.Host = "api." + u.Host
 
-	resp, err := httpclient.GetURL(u.String(), nil)
+	resp, err := getURL(context.Background(), u.String(), nil)
 	if err != nil {
 		log.Debugf("can %s use Bitbucket API? No.", link)
 		return false
@@ -1,6 +1,7 @@
 package crawler
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"testing"
 
@@ -58,3 +59,22 @@ func TestGenerateBitbucketAPIURL(t *testing.T) {
 	}
 
 }
+
+// TestBitbucketCloneURL checks that the https clone url is picked among the repository links.
+func TestBitbucketCloneURL(t *testing.T) {
+	var links Links
+	err := json.Unmarshal([]byte(`{"clone": [
+		{"href": "git@bitbucket.org:Soft/repo.git", "name": "ssh"},
+		{"href": "https://bitbucket.org/Soft/repo.git", "name": "https"}
+	]}`), &links)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out := bitbucketCloneURL(links); out != "https://bitbucket.org/Soft/repo.git" {
+		t.Errorf("Expected the https clone url, got %s", out)
+	}
+	if out := bitbucketCloneURL(Links{}); out != "" {
+		t.Errorf("Expected no clone url, got %s", out)
+	}
+}
@@ -0,0 +1,122 @@
+package crawler
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// errCircuitOpen is returned instead of fetching from a domain whose circuit breaker is open.
+var errCircuitOpen = errors.New("circuit breaker open")
+
+// circuitBreaker stops the requests to a domain after too many consecutive failures.
+// Once the cooldown is expired a single request is let through (half-open): if it succeeds the
+// circuit is closed again, otherwise it's open for another cooldown.
+type circuitBreaker struct {
+	sync.Mutex
+	maxFailures int
+	window      time.Duration
+	cooldown    time.Duration
+
+	failures     int
+	firstFailure time.Time
+	openUntil    time.Time
+	probing      bool
+}
+
+// domainBreakers holds the circuit breakers of the domains, by host.
+var domainBreakers sync.Map
+
+// domainBreaker returns the circuit breaker of domain, or nil if CIRCUIT_BREAKER_FAILURES is not set.
+func domainBreaker(domain Domain) *circuitBreaker {
+	maxFailures := viper.GetInt("CIRCUIT_BREAKER_FAILURES")
+	if maxFailures <= 0 {
+		return nil
+	}
+
+	breaker, ok := domainBreakers.Load(domain.Host)
+	if !ok {
+		breaker, _ = domainBreakers.LoadOrStore(domain.Host, newCircuitBreaker(maxFailures,
+			viper.GetDuration("CIRCUIT_BREAKER_WINDOW"), viper.GetDuration("CIRCUIT_BREAKER_COOLDOWN")))
+	}
+	return breaker.(*circuitBreaker)
+}
+
+// newCircuitBreaker returns a circuit breaker that opens after maxFailures consecutive failures within
+// window (1 minute if unset), for cooldown (5 minutes if unset).
+func newCircuitBreaker(maxFailures int, window, cooldown time.Duration) *circuitBreaker {
+	if window <= 0 {
+		window = time.Minute
+	}
+	if cooldown <= 0 {
+		cooldown = 5 * time.Minute
+	}
+	return &circuitBreaker{maxFailures: maxFailures, window: window, cooldown: cooldown}
+}
+
+// allow returns true if a request can be sent at now, and probe true if it's the single request
+// of the half-open circuit, that must be followed by success, failure or release.
+func (b *circuitBreaker) allow(now time.Time) (allowed, probe bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true, false
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false, false
+	}
+
+	// Half-open: let a single request test the recovery.
+	b.probing = true
+	return true, true
+}
+
+// release lets another request probe the half-open circuit, when the probe ended without a response
+// that tells if the domain recovered (e.g. the context was done before sending it).
+func (b *circuitBreaker) release() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.probing = false
+}
+
+// success records a successful request, closing the circuit.
+func (b *circuitBreaker) success() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+}
+
+// failure records a failed request at now, and returns true if it opened the circuit.
+func (b *circuitBreaker) failure(now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.probing {
+		b.probing = false
+		b.openUntil = now.Add(b.cooldown)
+		return true
+	}
+	if !b.openUntil.IsZero() {
+		// Already open, e.g. a request started before the circuit opened.
+		return false
+	}
+
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+	b.failures++
+	if b.failures < b.maxFailures {
+		return false
+	}
+
+	b.openUntil = now.Add(b.cooldown)
+	return true
+}
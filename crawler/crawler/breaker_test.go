@@ -0,0 +1,114 @@
+package crawler
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	b := newCircuitBreaker(3, time.Minute, 5*time.Minute)
+
+	// The failures outside the window are not consecutive.
+	b.failure(now)
+	b.failure(now.Add(10 * time.Second))
+	if b.failure(now.Add(2 * time.Minute)) {
+		t.Errorf("Expected the circuit to stay closed after failures outside the window")
+	}
+
+	now = now.Add(2 * time.Minute)
+	b.failure(now)
+	if !b.failure(now) {
+		t.Errorf("Expected the circuit to open after 3 consecutive failures")
+	}
+	if allowed, _ := b.allow(now.Add(time.Minute)); allowed {
+		t.Errorf("Expected the requests to be short-circuited during the cooldown")
+	}
+
+	// Half-open after the cooldown: a single request is let through.
+	now = now.Add(6 * time.Minute)
+	first, probe := b.allow(now)
+	second, _ := b.allow(now)
+	if !first || !probe || second {
+		t.Errorf("Expected a single request after the cooldown")
+	}
+	if !b.failure(now) {
+		t.Errorf("Expected the circuit to open again after a failed probe")
+	}
+
+	// A released probe lets another request through.
+	now = now.Add(6 * time.Minute)
+	b.allow(now)
+	b.release()
+	if allowed, probe := b.allow(now); !allowed || !probe {
+		t.Errorf("Expected another probe after the release")
+	}
+	b.success()
+	first, probe = b.allow(now)
+	second, _ = b.allow(now)
+	if !first || probe || !second {
+		t.Errorf("Expected the circuit to be closed after a successful probe")
+	}
+}
+
+// TestFetchURLReleasesProbe checks that the probe of a half-open circuit is released when no request is sent,
+// e.g. when the context is done waiting for the rate limit.
+func TestFetchURLReleasesProbe(t *testing.T) {
+	viper.Set("CIRCUIT_BREAKER_FAILURES", 1)
+	defer viper.Set("CIRCUIT_BREAKER_FAILURES", nil)
+
+	domain := Domain{Host: "breaker.example.org", RateLimit: 1}
+	b := newCircuitBreaker(1, time.Minute, time.Nanosecond)
+	b.failure(time.Now())
+	domainBreakers.Store(domain.Host, b)
+	defer domainBreakers.Delete(domain.Host)
+	defer domainLimiters.Delete(domain.Host)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c := Crawler{fetcher: newFakeFetcher(nil)}
+	if _, err := c.fetchURL(ctx, Repository{FileRawURL: "https://breaker.example.org/publiccode.yml", Domain: domain}); err == nil {
+		t.Fatalf("Expected the context error")
+	}
+	if allowed, probe := b.allow(time.Now()); !allowed || !probe {
+		t.Errorf("Expected the probe released")
+	}
+}
+
+// TestFetchURLCancelledRetry checks that a fetch interrupted while retrying is not a failure of the domain,
+// and that it releases the probe of a half-open circuit.
+func TestFetchURLCancelledRetry(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("CIRCUIT_BREAKER_FAILURES", 1)
+	viper.Set("HTTP_MAX_RETRIES", 3)
+	viper.Set("HTTP_BASE_DELAY", time.Hour)
+	defer viper.Set("CIRCUIT_BREAKER_FAILURES", nil)
+	defer viper.Set("HTTP_MAX_RETRIES", nil)
+	defer viper.Set("HTTP_BASE_DELAY", nil)
+
+	domain := Domain{Host: "breaker.example.org"}
+	b := newCircuitBreaker(1, time.Minute, time.Hour)
+	b.failure(time.Now())
+	b.openUntil = time.Now()
+	domainBreakers.Store(domain.Host, b)
+	defer domainBreakers.Delete(domain.Host)
+
+	rawURL := "https://breaker.example.org/publiccode.yml"
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c := Crawler{fetcher: newFakeFetcher(map[string][]int{rawURL: {http.StatusServiceUnavailable}})}
+	if resp, _ := c.fetchURL(ctx, Repository{FileRawURL: rawURL, Domain: domain}); resp.Status.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected the 503 returned, got %d", resp.Status.Code)
+	}
+	if allowed, probe := b.allow(time.Now()); !allowed || !probe {
+		t.Errorf("Expected the circuit still half-open, with the probe released")
+	}
+}
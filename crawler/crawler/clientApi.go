@@ -1,6 +1,7 @@
 package crawler
 
 import (
+	"context"
 	"fmt"
 )
 
@@ -13,10 +14,10 @@ type ClientAPI struct {
 }
 
 // OrganizationHandler returns the client handler for an organization/team/group page (every domain has a different handler implementation).
-type OrganizationHandler func(domain Domain, url string, repositories chan Repository, pa PA) (string, error)
+type OrganizationHandler func(ctx context.Context, domain Domain, url string, repositories chan Repository, pa PA) (string, error)
 
 // SingleRepoHandler returns the client handler for an a single repository (every domain has a different handler implementation).
-type SingleRepoHandler func(domain Domain, url string, repositories chan Repository, pa PA) error
+type SingleRepoHandler func(ctx context.Context, domain Domain, url string, repositories chan Repository, pa PA) error
 
 // GeneratorAPIURL returns the url in the api correct ecosystem.
 type GeneratorAPIURL func(url string) ([]string, error)
@@ -46,6 +47,35 @@ func RegisterClientAPIs() {
 		APIURL:       GenerateGitlabAPIURL(),
 	}
 
+	clientAPIs["gitea"] = ClientAPI{
+		Organization: RegisterGiteaAPI(),
+		Single:       RegisterSingleGiteaAPI(),
+		APIURL:       GenerateGiteaAPIURL(),
+	}
+
+	clientAPIs["azure"] = ClientAPI{
+		Organization: RegisterAzureAPI(),
+		Single:       RegisterSingleAzureAPI(),
+		APIURL:       GenerateAzureAPIURL(),
+	}
+
+	clientAPIs["sourcehut"] = ClientAPI{
+		Organization: RegisterSourcehutAPI(),
+		Single:       RegisterSingleSourcehutAPI(),
+		APIURL:       GenerateSourcehutAPIURL(),
+	}
+
+	clientAPIs["codecommit"] = ClientAPI{
+		Organization: RegisterCodeCommitAPI(),
+		Single:       RegisterSingleCodeCommitAPI(),
+		APIURL:       GenerateCodeCommitAPIURL(),
+	}
+
+	clientAPIs["file"] = ClientAPI{
+		Organization: RegisterFileAPI(),
+		APIURL:       GenerateFileAPIURL(),
+	}
+
 }
 
 // GetClientAPICrawler checks if the API client for the requested organization clientAPI exists and return its handler.
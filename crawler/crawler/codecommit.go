@@ -0,0 +1,270 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/codecommit"
+	"github.com/aws/aws-sdk-go/service/codecommit/codecommitiface"
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// codeCommitBatchSize is the maximum number of repositories of a BatchGetRepositories request.
+const codeCommitBatchSize = 25
+
+// codeCommitClients are the CodeCommit API clients, by region. The credentials are taken from the
+// default AWS credential chain (environment, shared credentials file, instance or task role).
+var codeCommitClients sync.Map
+
+// codeCommitClient returns the CodeCommit API client of the region of host,
+// e.g. git-codecommit.eu-west-1.amazonaws.com.
+func codeCommitClient(host string) (codecommitiface.CodeCommitAPI, error) {
+	region, err := codeCommitRegion(host)
+	if err != nil {
+		return nil, err
+	}
+	if client, ok := codeCommitClients.Load(region); ok {
+		return client.(codecommitiface.CodeCommitAPI), nil
+	}
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, err
+	}
+	client, _ := codeCommitClients.LoadOrStore(region, codecommitiface.CodeCommitAPI(codecommit.New(sess)))
+	return client.(codecommitiface.CodeCommitAPI), nil
+}
+
+// codeCommitRegion returns the region of the CodeCommit git host.
+// IN: git-codecommit.eu-west-1.amazonaws.com
+// OUT: eu-west-1
+func codeCommitRegion(host string) (string, error) {
+	parts := strings.Split(host, ".")
+	if len(parts) < 4 || !strings.HasPrefix(parts[0], "git-codecommit") || parts[2] != "amazonaws" {
+		return "", errors.New("invalid codecommit host: " + host)
+	}
+	return parts[1], nil
+}
+
+// RegisterCodeCommitAPI register the crawler function for AWS CodeCommit API.
+// It get the list of repositories of the region of the "link" url (e.g. https://git-codecommit.eu-west-1.amazonaws.com).
+// If a next page is available return its url, with the "next" token of the page.
+// Otherwise returns an empty ("") string.
+func RegisterCodeCommitAPI() OrganizationHandler {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) (string, error) {
+		// Parse url.
+		u, err := url.Parse(link)
+		if err != nil {
+			return link, err
+		}
+		// Set domain host to new host.
+		domain.Host = u.Hostname()
+
+		client, err := codeCommitClient(domain.Host)
+		if err != nil {
+			return link, err
+		}
+
+		// Get List of repositories.
+		q := u.Query()
+		input := &codecommit.ListRepositoriesInput{}
+		if next := q.Get("next"); next != "" {
+			input.NextToken = aws.String(next)
+		}
+		list, err := client.ListRepositoriesWithContext(ctx, input)
+		if err != nil {
+			return link, err
+		}
+
+		// The list has only the names, the default branches are read in batches.
+		var names []*string
+		for _, v := range list.Repositories {
+			names = append(names, v.RepositoryName)
+		}
+		for len(names) > 0 {
+			n := len(names)
+			if n > codeCommitBatchSize {
+				n = codeCommitBatchSize
+			}
+			batch, err := client.BatchGetRepositoriesWithContext(ctx, &codecommit.BatchGetRepositoriesInput{RepositoryNames: names[:n]})
+			if err != nil {
+				return link, err
+			}
+			names = names[n:]
+
+			// Add repositories to the channel that will perform the check on everyone.
+			for _, v := range batch.Repositories {
+				err = addCodeCommitProjectToRepositories(v, domain, pa, repositories)
+				if err != nil {
+					log.Infof("addCodeCommitProjectToRepositories %v", err)
+				}
+			}
+		}
+
+		// if last page for this region, the next token is empty.
+		if aws.StringValue(list.NextToken) == "" {
+			return "", nil
+		}
+
+		// Return next url.
+		q.Set("next", aws.StringValue(list.NextToken))
+		u.RawQuery = q.Encode()
+
+		return u.String(), nil
+	}
+}
+
+// RegisterSingleCodeCommitAPI register the crawler function for single repository AWS CodeCommit API.
+// Return nil if the repository was successfully added to repositories channel.
+// Otherwise return the generated error.
+func RegisterSingleCodeCommitAPI() SingleRepoHandler {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) error {
+		// Parse url.
+		u, err := url.Parse(link)
+		if err != nil {
+			return err
+		}
+
+		// Set domain host to new host.
+		domain.Host = u.Hostname()
+
+		// IN: https://git-codecommit.eu-west-1.amazonaws.com/v1/repos/repo
+		name := strings.TrimPrefix(strings.Trim(u.Path, "/"), "v1/repos/")
+		if name == "" || strings.Contains(name, "/") {
+			return errors.New("invalid codecommit repository url: " + link)
+		}
+
+		client, err := codeCommitClient(domain.Host)
+		if err != nil {
+			return err
+		}
+
+		// Get single Repo.
+		resp, err := client.GetRepositoryWithContext(ctx, &codecommit.GetRepositoryInput{RepositoryName: aws.String(name)})
+		if err != nil {
+			return err
+		}
+		if resp.RepositoryMetadata == nil || resp.RepositoryMetadata.DefaultBranch == nil {
+			return errors.New("repository is empty: " + link)
+		}
+
+		return addCodeCommitProjectToRepositories(resp.RepositoryMetadata, domain, pa, repositories)
+	}
+}
+
+// generateCodeCommitRawURL returns the url of the file in the CodeCommit console. The file has no raw url,
+// it's read with the API (see getCodeCommitFile), but the url identifies it in the reports and the logs.
+// IN: eu-west-1, repo, master
+// OUT: https://eu-west-1.console.aws.amazon.com/codesuite/codecommit/repositories/repo/browse/refs/heads/master/--/publiccode.yml?region=eu-west-1
+func generateCodeCommitRawURL(region, name, defaultBranch string) string {
+	u := url.URL{
+		Scheme:   "https",
+		Host:     region + ".console.aws.amazon.com",
+		Path:     "/codesuite/codecommit/repositories/" + name + "/browse/refs/heads/" + defaultBranch + "/--/" + viper.GetString("CRAWLED_FILENAME"),
+		RawQuery: url.Values{"region": []string{region}}.Encode(),
+	}
+	return u.String()
+}
+
+// addCodeCommitProjectToRepositories adds the project from api response to repository channel.
+// The repositories are named <account id>/<repository name>.
+func addCodeCommitProjectToRepositories(v *codecommit.RepositoryMetadata, domain Domain, pa PA, repositories chan Repository) error {
+	// If the repository was never used, there is no default branch to look into.
+	if v == nil || aws.StringValue(v.DefaultBranch) == "" {
+		return nil
+	}
+
+	region, err := codeCommitRegion(domain.Host)
+	if err != nil {
+		return err
+	}
+
+	// Marshal all the repository metadata.
+	metadata, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("codecommit metadata: %v", err)
+		return err
+	}
+
+	name := aws.StringValue(v.RepositoryName)
+	repositories <- Repository{
+		Name:        aws.StringValue(v.AccountId) + "/" + name,
+		Hostname:    domain.Host,
+		FileRawURL:  generateCodeCommitRawURL(region, name, aws.StringValue(v.DefaultBranch)),
+		GitCloneURL: aws.StringValue(v.CloneUrlHttp),
+		GitBranch:   aws.StringValue(v.DefaultBranch),
+		Domain:      domain,
+		Pa:          pa,
+		Metadata:    metadata,
+	}
+
+	return nil
+}
+
+// getCodeCommitFile reads the file of the repository, on its branch, with the CodeCommit API.
+// It returns the file as a response: 200 with the content, 404 if the file, the branch or the
+// repository don't exist, or the status of the other API errors (-1 without a response).
+func getCodeCommitFile(ctx context.Context, repository Repository) (httpclient.HTTPResponse, error) {
+	resp := httpclient.HTTPResponse{Headers: http.Header{}, URL: repository.FileRawURL}
+	resp.Status.Code = -1
+
+	client, err := codeCommitClient(repository.Hostname)
+	if err != nil {
+		return resp, err
+	}
+
+	_, name := splitFullName(repository.Name)
+	file, err := client.GetFileWithContext(ctx, &codecommit.GetFileInput{
+		RepositoryName:  aws.String(name),
+		CommitSpecifier: aws.String(repository.GitBranch),
+		FilePath:        aws.String(repository.filename()),
+	})
+	if err != nil {
+		var requestErr awserr.RequestFailure
+		if errors.As(err, &requestErr) {
+			resp.Status.Code = requestErr.StatusCode()
+			switch requestErr.Code() {
+			case codecommit.ErrCodeFileDoesNotExistException, codecommit.ErrCodeCommitDoesNotExistException,
+				codecommit.ErrCodeRepositoryDoesNotExistException:
+				resp.Status.Code = http.StatusNotFound
+			}
+		}
+		if resp.Status.Code > 0 {
+			resp.Status.Text = fmt.Sprintf("%d %s", resp.Status.Code, http.StatusText(resp.Status.Code))
+		}
+		return resp, err
+	}
+
+	resp.Status = httpclient.ResponseStatus{Text: "200 OK", Code: http.StatusOK}
+	if int64(len(file.FileContent)) > maxFileSize() {
+		return resp, httpclient.ErrBodyTooLarge
+	}
+	resp.Body = file.FileContent
+
+	return resp, nil
+}
+
+// GenerateCodeCommitAPIURL returns the api url of given CodeCommit region link, the link itself.
+// IN: https://git-codecommit.eu-west-1.amazonaws.com
+// OUT:https://git-codecommit.eu-west-1.amazonaws.com
+func GenerateCodeCommitAPIURL() GeneratorAPIURL {
+	return func(in string) (out []string, err error) {
+		if _, err := url.Parse(in); err != nil {
+			return []string{in}, err
+		}
+
+		out = append(out, in)
+		return
+	}
+}
@@ -0,0 +1,125 @@
+package crawler
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/codecommit"
+	"github.com/aws/aws-sdk-go/service/codecommit/codecommitiface"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// fakeCodeCommit lists the repositories of pages, by next token, and has files, by repository and path.
+type fakeCodeCommit struct {
+	codecommitiface.CodeCommitAPI
+	pages map[string][]string
+	files map[string]string
+}
+
+func (f fakeCodeCommit) ListRepositoriesWithContext(ctx aws.Context, in *codecommit.ListRepositoriesInput, _ ...request.Option) (*codecommit.ListRepositoriesOutput, error) {
+	out := &codecommit.ListRepositoriesOutput{}
+	token := aws.StringValue(in.NextToken)
+	for _, name := range f.pages[token] {
+		out.Repositories = append(out.Repositories, &codecommit.RepositoryNameIdPair{RepositoryName: aws.String(name)})
+	}
+	if token == "" {
+		out.NextToken = aws.String("page2")
+	}
+	return out, nil
+}
+
+func (f fakeCodeCommit) BatchGetRepositoriesWithContext(ctx aws.Context, in *codecommit.BatchGetRepositoriesInput, _ ...request.Option) (*codecommit.BatchGetRepositoriesOutput, error) {
+	out := &codecommit.BatchGetRepositoriesOutput{}
+	for _, name := range in.RepositoryNames {
+		out.Repositories = append(out.Repositories, &codecommit.RepositoryMetadata{
+			AccountId:      aws.String("123456789012"),
+			RepositoryName: name,
+			DefaultBranch:  aws.String("main"),
+		})
+	}
+	return out, nil
+}
+
+func (f fakeCodeCommit) GetFileWithContext(ctx aws.Context, in *codecommit.GetFileInput, _ ...request.Option) (*codecommit.GetFileOutput, error) {
+	content, ok := f.files[aws.StringValue(in.RepositoryName)+"/"+aws.StringValue(in.FilePath)]
+	if !ok {
+		return nil, awserr.NewRequestFailure(awserr.New(codecommit.ErrCodeFileDoesNotExistException, "file not found", nil), http.StatusBadRequest, "")
+	}
+	return &codecommit.GetFileOutput{FileContent: []byte(content)}, nil
+}
+
+func TestCodeCommitRegion(t *testing.T) {
+	hosts := []struct {
+		host   string
+		region string
+	}{
+		{"git-codecommit.eu-west-1.amazonaws.com", "eu-west-1"},
+		{"git-codecommit-fips.us-east-1.amazonaws.com", "us-east-1"},
+		{"codecommit.example.org", ""},
+	}
+
+	for _, h := range hosts {
+		if region, _ := codeCommitRegion(h.host); region != h.region {
+			t.Errorf("Expected the region of %s %q, got %q", h.host, h.region, region)
+		}
+	}
+}
+
+// TestCodeCommitAPI checks that the repositories of the region are listed, following the pages,
+// and that their files are read with the API.
+func TestCodeCommitAPI(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("CRAWLED_FILENAME", "publiccode.yml")
+	defer viper.Set("CRAWLED_FILENAME", nil)
+
+	const host = "git-codecommit.eu-test-1.amazonaws.com"
+	codeCommitClients.Store("eu-test-1", fakeCodeCommit{
+		pages: map[string][]string{"": {"repo1"}, "page2": {"repo2"}},
+		files: map[string]string{"repo1/publiccode.yml": "name: repo1"},
+	})
+	defer codeCommitClients.Delete("eu-test-1")
+
+	links, _ := GenerateCodeCommitAPIURL()("https://" + host)
+	handler := RegisterCodeCommitAPI()
+	repositories := make(chan Repository, 2)
+	link := links[0]
+	for link != "" {
+		next, err := handler(context.Background(), Domain{Host: host, Type: "codecommit"}, link, repositories, PA{})
+		if err != nil {
+			t.Fatalf("Unexpected error on %s: %v", link, err)
+		}
+		link = next
+	}
+	close(repositories)
+
+	var found []Repository
+	for repository := range repositories {
+		found = append(found, repository)
+	}
+	if len(found) != 2 || found[0].Name != "123456789012/repo1" || found[1].Name != "123456789012/repo2" {
+		t.Fatalf("Unexpected repositories %v", found)
+	}
+	rawURL := "https://eu-test-1.console.aws.amazon.com/codesuite/codecommit/repositories/repo1/browse/refs/heads/main/--/publiccode.yml?region=eu-test-1"
+	if found[0].FileRawURL != rawURL {
+		t.Errorf("Unexpected file url %s", found[0].FileRawURL)
+	}
+
+	c := Crawler{fetcher: newFakeFetcher(nil)}
+	for i, code := range []int{http.StatusOK, http.StatusNotFound} {
+		repository, resp, _ := c.fetchFile(context.Background(), found[i])
+		if resp.Status.Code != code || repository.Filename != "publiccode.yml" {
+			t.Errorf("Expected %s %d, got %d", repository.Name, code, resp.Status.Code)
+		}
+		if code == http.StatusOK && string(resp.Body) != "name: repo1" {
+			t.Errorf("Unexpected file %q", resp.Body)
+		}
+	}
+}
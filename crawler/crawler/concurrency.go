@@ -0,0 +1,94 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// domainConcurrencies holds the adaptive concurrency limits of the domains, by host.
+var domainConcurrencies sync.Map
+
+// domainConcurrency returns the adaptive concurrency limit of the file requests to domain,
+// or nil if ADAPTIVE_CONCURRENCY is not set.
+func domainConcurrency(domain Domain) *adaptiveConcurrency {
+	max := viper.GetInt("ADAPTIVE_CONCURRENCY")
+	if max <= 0 {
+		return nil
+	}
+
+	limit, ok := domainConcurrencies.Load(domain.Host)
+	if !ok {
+		limit, _ = domainConcurrencies.LoadOrStore(domain.Host, newAdaptiveConcurrency(max))
+	}
+	return limit.(*adaptiveConcurrency)
+}
+
+// adaptiveConcurrency limits the requests in flight, adjusting the limit AIMD-style: it's halved on a rate
+// limited request, and increased by one after as many responses not rate limited as the limit, up to max.
+type adaptiveConcurrency struct {
+	sync.Mutex
+	limit  int
+	max    int
+	active int
+	// Responses not rate limited since the last change of the limit.
+	successes int
+	// Closed, and replaced, when a request can be let through.
+	wake chan struct{}
+}
+
+// newAdaptiveConcurrency returns an adaptive concurrency limit starting from max.
+func newAdaptiveConcurrency(max int) *adaptiveConcurrency {
+	return &adaptiveConcurrency{limit: max, max: max, wake: make(chan struct{})}
+}
+
+// acquire waits until a request is allowed by the limit, or ctx is done (returning the context error).
+func (a *adaptiveConcurrency) acquire(ctx context.Context) error {
+	for {
+		a.Lock()
+		if a.active < a.limit {
+			a.active++
+			a.Unlock()
+			return nil
+		}
+		wake := a.wake
+		a.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// release ends a request allowed by acquire, adjusting the limit with its response status code and
+// rateLimited, true if the request was rate limited (e.g. a 403 for the rate limit, or a 429 retried
+// by httpclient), and returns the limit.
+func (a *adaptiveConcurrency) release(status int, rateLimited bool) int {
+	a.Lock()
+	defer a.Unlock()
+
+	a.active--
+	switch {
+	case rateLimited || status == http.StatusTooManyRequests:
+		a.limit /= 2
+		if a.limit < 1 {
+			a.limit = 1
+		}
+		a.successes = 0
+	// The failures without a response don't tell anything about the rate limit.
+	case status > 0:
+		a.successes++
+		if a.successes >= a.limit && a.limit < a.max {
+			a.limit++
+			a.successes = 0
+		}
+	}
+
+	close(a.wake)
+	a.wake = make(chan struct{})
+	return a.limit
+}
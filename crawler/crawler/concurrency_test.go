@@ -0,0 +1,77 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	a := newAdaptiveConcurrency(4)
+
+	// The limit is halved on a 429, down to 1.
+	for _, expected := range []int{2, 1, 1} {
+		if err := a.acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if limit := a.release(http.StatusTooManyRequests, false); limit != expected {
+			t.Errorf("Expected the limit %d after a 429, got %d", expected, limit)
+		}
+	}
+
+	// It grows back by one after as many responses without a 429 as the limit, up to the maximum.
+	var limits []int
+	for i := 0; i < 12; i++ {
+		if err := a.acquire(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		limits = append(limits, a.release(http.StatusOK, false))
+	}
+	expected := []int{2, 2, 3, 3, 3, 4, 4, 4, 4, 4, 4, 4}
+	for i := range expected {
+		if limits[i] != expected[i] {
+			t.Fatalf("Expected the limits %v, got %v", expected, limits)
+		}
+	}
+	if err := a.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if limit := a.release(-1, false); limit != 4 {
+		t.Errorf("Expected the limit unchanged by a failure without a response, got %d", limit)
+	}
+
+	// The rate limited requests halve it too, whatever the status returned (e.g. a 403 for the rate limit).
+	if err := a.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if limit := a.release(http.StatusForbidden, true); limit != 2 {
+		t.Errorf("Expected the limit halved after a rate limited request, got %d", limit)
+	}
+}
+
+// TestAdaptiveConcurrencyAcquire checks that the requests over the limit wait for a release, or for the context.
+func TestAdaptiveConcurrencyAcquire(t *testing.T) {
+	a := newAdaptiveConcurrency(1)
+	if err := a.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := a.acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the request over the limit to wait until the deadline, got %v", err)
+	}
+
+	acquired := make(chan error)
+	go func() { acquired <- a.acquire(context.Background()) }()
+	a.release(http.StatusOK, false)
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the request let through after the release")
+	}
+}
@@ -0,0 +1,101 @@
+package crawler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+)
+
+// cacheValidators are the HTTP validators of the last fetched file, used for conditional requests.
+type cacheValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// URL the file was fetched from after the redirects, if redirected.
+	URL string `json:"url,omitempty"`
+
+	// Result of the validation of the file, replayed when it's not modified.
+	Validated   bool             `json:"validated,omitempty"`
+	Errors      ValidationErrors `json:"errors,omitempty"`
+	Warnings    ValidationErrors `json:"warnings,omitempty"`
+	ContentHash string           `json:"content_hash,omitempty"`
+}
+
+// cacheValidatorsPath returns the path of the sidecar file with the validators of the saved file.
+func cacheValidatorsPath(repository Repository, index string) string {
+	return savedFilePath(repository.folder(), repository.Name, repository.filename(), index) + ".http.json"
+}
+
+// loadCacheValidators returns the validators stored by the previous crawl, false if there are none.
+func loadCacheValidators(repository Repository, index string) (cacheValidators, bool) {
+	var validators cacheValidators
+	data, err := ioutil.ReadFile(cacheValidatorsPath(repository, index))
+	if err != nil {
+		return validators, false
+	}
+	if err := json.Unmarshal(data, &validators); err != nil {
+		return validators, false
+	}
+	return validators, true
+}
+
+// conditionalHeaders returns a copy of the repository file headers with If-None-Match and
+// If-Modified-Since set from the validators stored by the previous crawl, if any.
+// The validators stored without the validation result are ignored, so that the file is validated again.
+func conditionalHeaders(repository Repository, index string) map[string]string {
+	rawHeaders := repository.rawHeaders()
+	headers := make(map[string]string, len(rawHeaders)+2)
+	for k, v := range rawHeaders {
+		headers[k] = v
+	}
+
+	validators, ok := loadCacheValidators(repository, index)
+	if !ok || !validators.Validated {
+		return headers
+	}
+
+	if validators.ETag != "" {
+		headers["If-None-Match"] = validators.ETag
+	}
+	if validators.LastModified != "" {
+		headers["If-Modified-Since"] = validators.LastModified
+	}
+
+	return headers
+}
+
+// saveCacheValidators stores the ETag and Last-Modified headers of the response, the url it was
+// redirected to and the result of the validation of the file, next to the saved file.
+func saveCacheValidators(repository Repository, index string, resp httpclient.HTTPResponse, errs, warnings ValidationErrors) error {
+	validators := cacheValidators{
+		ETag:         resp.Headers.Get("ETag"),
+		LastModified: resp.Headers.Get("Last-Modified"),
+	}
+	// Without validators there is no conditional request, nor a result to replay.
+	if validators.ETag == "" && validators.LastModified == "" && resp.URL == repository.FileRawURL {
+		return nil
+	}
+	if resp.URL != repository.FileRawURL {
+		validators.URL = resp.URL
+	}
+	validators.Validated = true
+	validators.Errors = errs
+	validators.Warnings = warnings
+	validators.ContentHash = contentKey(resp.Body)
+
+	data, err := json.Marshal(validators)
+	if err != nil {
+		return err
+	}
+
+	// The validators are kept in DATADIR whatever the configured storage is.
+	filePath := cacheValidatorsPath(repository, index)
+	err = os.MkdirAll(filepath.Dir(filePath), dirMode())
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filePath, data, fileMode())
+}
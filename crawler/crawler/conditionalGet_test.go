@@ -0,0 +1,83 @@
+package crawler
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// TestConditionalHeaders checks that the file requests use the RawHeaders of the repository, if set.
+func TestConditionalHeaders(t *testing.T) {
+	repository := Repository{
+		Hostname: "gitlab.example.org",
+		Name:     "group/project",
+		Headers:  map[string]string{"PRIVATE-TOKEN": "api"},
+	}
+	if headers := conditionalHeaders(repository, "test"); headers["PRIVATE-TOKEN"] != "api" {
+		t.Errorf("Expected the API headers, got %v", headers)
+	}
+
+	repository.RawHeaders = map[string]string{"JOB-TOKEN": "raw"}
+	headers := conditionalHeaders(repository, "test")
+	if headers["JOB-TOKEN"] != "raw" || headers["PRIVATE-TOKEN"] != "" {
+		t.Errorf("Expected the raw headers, got %v", headers)
+	}
+}
+
+// etagFetcher returns the file with an ETag, and 304 to the requests with the same If-None-Match.
+type etagFetcher struct {
+	body        []byte
+	notModified int
+}
+
+func (f *etagFetcher) GetURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error) {
+	if headers["If-None-Match"] == `"v1"` {
+		f.notModified++
+		return httpclient.HTTPResponse{Status: httpclient.ResponseStatus{Text: "304 Not Modified", Code: http.StatusNotModified},
+			Headers: http.Header{}, URL: url}, nil
+	}
+	return httpclient.HTTPResponse{Body: f.body, Status: httpclient.ResponseStatus{Text: "200 OK", Code: http.StatusOK},
+		Headers: http.Header{"Etag": []string{`"v1"`}}, URL: url}, nil
+}
+
+func (f *etagFetcher) HeadURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error) {
+	return f.GetURL(ctx, url, headers)
+}
+
+// TestNotModifiedReplay checks that the result of the validation of a file is recorded again when the file
+// is not modified, so that an unchanged invalid file is counted by FAIL_ON_INVALID in the next crawls.
+func TestNotModifiedReplay(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("CRAWLER_DATADIR", dir)
+	defer viper.Set("CRAWLER_DATADIR", nil)
+
+	fetcher := &etagFetcher{body: []byte("name: invalid")}
+	repository := Repository{Name: "italia/repo", Hostname: "github.com", FileRawURL: "https://raw.example.org/italia/repo/publiccode.yml",
+		Domain: Domain{Host: "github.com"}}
+	for run := 1; run <= 2; run++ {
+		c := Crawler{index: "test", ctx: context.Background(), fetcher: fetcher, validator: bodyValidator{valid: "name: valid"}}
+		c.processRepo(context.Background(), repository)
+		if n := c.InvalidFiles(); n != 1 {
+			t.Errorf("Expected 1 invalid file in run %d, got %d", run, n)
+		}
+		if len(c.report.Results) != 1 || c.report.Results[0].Valid {
+			t.Errorf("Expected the invalid file in the report of run %d, got %v", run, c.report.Results)
+		}
+	}
+	if fetcher.notModified != 1 {
+		t.Errorf("Expected the file not modified in the second run, got %d 304 responses", fetcher.notModified)
+	}
+}
@@ -1,35 +1,63 @@
 package crawler
 
 import (
+	"context"
 	"crypto/rand"
 	"errors"
 	"fmt"
 	"math/big"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/italia/developers-italia-backend/crawler/elastic"
 	"github.com/italia/developers-italia-backend/crawler/httpclient"
 	"github.com/italia/developers-italia-backend/crawler/ipa"
 	"github.com/italia/developers-italia-backend/crawler/jekyll"
 	"github.com/italia/developers-italia-backend/crawler/metrics"
-	publiccode "github.com/italia/publiccode-parser-go"
+	"github.com/italia/developers-italia-backend/crawler/version"
 	es "github.com/olivere/elastic"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Crawler is a helper class representing a crawler.
 type Crawler struct {
+	// Context of the crawl, used to interrupt waits.
+	ctx context.Context
 	// Sync mutex guard.
 	es             *es.Client
+	esBulk         *es.BulkProcessor
 	index          string
 	domains        []Domain
+	domainsMu      sync.RWMutex
 	repositories   chan Repository
+	report         validationReport
+	summary        crawlSummary
+	seen           seenRepositories
+	limits         domainLimits
+	duplicates     duplicates
+	notFound       notFoundCache
+	filter         repoFilter
+	validator      Validator
+	fetcher        Fetcher
+	sink           Sink
 	publishersWg   sync.WaitGroup
 	repositoriesWg sync.WaitGroup
+	// Processing of the repositories with REPO_TIMEOUT, that go on after they are abandoned.
+	timeoutWg sync.WaitGroup
+	// Config file reloaded on SIGHUP, nil if never reloaded, see reloadConfig. Guarded by configMu, like filter.
+	reloaded *viper.Viper
+	configMu sync.RWMutex
+
+	// Flushes the spans, if tracing.
+	shutdownTracing func(context.Context) error
 }
 
 // Repository is a single code repository. FileRawURL contains the direct url to the raw file.
@@ -43,6 +71,54 @@ type Repository struct {
 	Pa          PA
 	Headers     map[string]string
 	Metadata    []byte
+	// Headers of the file requests, if different from the API ones.
+	RawHeaders map[string]string
+	// Name of the file found in the repository, set when the file is fetched.
+	Filename string
+	// Archived and Fork are set from the API metadata, if available.
+	Archived bool
+	Fork     bool
+	// Topics are set from the API metadata of GitHub and GitLab.
+	Topics []string
+	// Url of the file in the API of the domain, fetched with the API headers instead of FileRawURL if set.
+	FileAPIURL string
+
+	// Span of the page where the repository was listed, set when tracing.
+	spanContext trace.SpanContext
+}
+
+// filename returns the name of the file found in the repository, CRAWLED_FILENAME if unknown.
+func (repository Repository) filename() string {
+	if repository.Filename != "" {
+		return repository.Filename
+	}
+	return viper.GetString("CRAWLED_FILENAME")
+}
+
+// folder returns the top folder of the files of the repository in the data directory:
+// the id of its domain if set, otherwise its hostname.
+func (repository Repository) folder() string {
+	if repository.Domain.ID != "" {
+		return repository.Domain.ID
+	}
+	return repository.Hostname
+}
+
+// rawHeaders returns the headers of the requests for the file of the repository.
+func (repository Repository) rawHeaders() map[string]string {
+	if repository.RawHeaders != nil && repository.FileAPIURL == "" {
+		return repository.RawHeaders
+	}
+	return repository.Headers
+}
+
+// logger returns a log entry with the repository fields.
+func (repository Repository) logger() *log.Entry {
+	return log.WithFields(log.Fields{
+		"domain":    repository.Domain.Host,
+		"repo_name": repository.Name,
+		"raw_url":   repository.FileRawURL,
+	})
 }
 
 // NewCrawler initializes a new Crawler object, updates the IPA list and connects to Elasticsearch.
@@ -50,17 +126,58 @@ func NewCrawler() *Crawler {
 	var c Crawler
 	var err error
 
+	// Cancel the crawl context on SIGINT/SIGTERM.
+	var cancel context.CancelFunc
+	c.ctx, cancel = context.WithCancel(context.Background())
+	handleShutdown(cancel)
+	handleDeadline(cancel, viper.GetDuration("CRAWL_DEADLINE"))
+
 	// Make sure the data directory exists or spit an error
 	if stat, err := os.Stat(viper.GetString("CRAWLER_DATADIR")); err != nil || !stat.IsDir() {
 		log.Fatalf("The configured data directory (%v) does not exist: %v", viper.GetString("CRAWLER_DATADIR"), err)
 	}
 
-	// Read and parse list of domains.
-	c.domains, err = ReadAndParseDomains("domains.yml")
+	// Check the permissions of the saved files.
+	for _, key := range []string{"FILE_MODE", "DIR_MODE"} {
+		if _, err := configuredMode(key, 0); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Configure the storage of the crawled files.
+	fileStorage, err = NewStorage()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Compile the patterns of the repositories to process.
+	c.filter, err = newRepoFilter(viper.GetStringSlice("REPO_INCLUDE"), viper.GetStringSlice("REPO_EXCLUDE"))
+	if err != nil {
+		log.Fatalf("Invalid REPO_INCLUDE/REPO_EXCLUDE pattern: %v", err)
+	}
+
+	// Export the spans of the crawl, if configured.
+	c.shutdownTracing, err = setupTracing()
+	if err != nil {
+		log.Fatalf("Error configuring the tracing: %v", err)
+	}
+
+	// Fetch the files over HTTP.
+	c.fetcher = httpFetcher{}
+
+	// Configure the validation of the crawled files.
+	c.validator, err = NewValidator(viper.GetString("VALIDATOR"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Read and parse list of domains, reloaded on SIGHUP.
+	c.domains, err = loadDomains("domains.yml")
+	if err != nil {
+		log.Fatal(err)
+	}
+	c.handleReload("domains.yml")
+
 	log.Debug("Connecting to ElasticSearch...")
 	c.es, err = elastic.ClientFactory(
 		viper.GetString("ELASTIC_URL"),
@@ -90,15 +207,66 @@ func NewCrawler() *Crawler {
 		log.Fatal(err)
 	}
 
+	// Elasticsearch is reachable and the indexes exist.
+	metrics.SetReady(true)
+
+	// Start the ES bulk indexer, unless the indexing is disabled or in dry run mode.
+	if !viper.GetBool("ELASTIC_INDEXING_DISABLED") && !dryRun() {
+		c.esBulk, err = c.startBulkProcessor()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Send the repositories metadata to Postgres, if configured and not in dry run mode.
+	if dsn := viper.GetString("POSTGRES_DSN"); dsn != "" && !dryRun() {
+		c.sink, err = NewPostgresSink(dsn)
+		if err != nil {
+			log.Fatalf("Error connecting to Postgres: %v", err)
+		}
+	}
+
 	// Initiate a channel of repositories.
 	c.repositories = make(chan Repository, 1000)
 
 	// Register Prometheus metrics.
 	metrics.RegisterPrometheusCounter("repository_processed", "Number of repository processed.", c.index)
 	metrics.RegisterPrometheusCounter("repository_file_saved", "Number of file saved.", c.index)
+	metrics.RegisterPrometheusCounter("repository_file_save_failed", "Number of file that could not be saved.", c.index)
+	metrics.RegisterPrometheusCounter("repository_file_unchanged", "Number of file not saved because unchanged.", c.index)
+	metrics.RegisterPrometheusCounter("repository_not_modified", "Number of file not modified since the last crawl.", c.index)
+	metrics.RegisterPrometheusCounter("repository_redirect_loop", "Number of files not fetched because of a redirect loop or too many redirects.", c.index)
+	metrics.RegisterPrometheusCounter("repository_file_saved_warnings", "Number of valid file saved with warnings.", c.index)
+	metrics.RegisterPrometheusCounter("repository_file_version_mismatch", "Number of file declaring a version different from PUBLICCODE_TARGET_VERSION.", c.index)
 	metrics.RegisterPrometheusCounter("repository_file_indexed", "Number of file indexed.", c.index)
 	metrics.RegisterPrometheusCounter("repository_cloned", "Number of repository cloned", c.index)
-	//metrics.RegisterPrometheusCounter("repository_file_saved_valid", "Number of valid file saved.", c.index)
+	metrics.RegisterPrometheusCounter("repository_skipped", "Number of repository skipped by REPO_INCLUDE/REPO_EXCLUDE/REQUIRE_TOPICS.", c.index)
+	metrics.RegisterPrometheusCounter("repository_skipped_archived_fork", "Number of archived or fork repository skipped by SKIP_ARCHIVED/SKIP_FORKS.", c.index)
+	metrics.RegisterPrometheusCounter("repository_duplicate", "Number of repository skipped because already processed from another domain.", c.index)
+	metrics.RegisterPrometheusCounter("repository_file_too_large", "Number of file not read because larger than MAX_FILE_SIZE.", c.index)
+	metrics.RegisterPrometheusCounter("repository_non_yaml_response", "Number of file not saved because the response is not YAML.", c.index)
+	metrics.RegisterPrometheusCounter("repository_head_not_found", "Number of file not requested because missing according to the HEAD_PREFLIGHT request.", c.index)
+	metrics.RegisterPrometheusCounter("repository_quarantined", "Number of repository skipped because its file was invalid for QUARANTINE_THRESHOLD crawls.", c.index)
+	metrics.RegisterPrometheusCounter("repository_multiple_files", "Number of repository with more than one of the candidate file names, with PREFER_VALID_FILENAME.", c.index)
+	metrics.RegisterPrometheusCounter("repository_rate_limited", "Number of file fetch retried after the Retry-After of a 429 response.", c.index)
+	metrics.RegisterPrometheusCounter("repository_http_rate_limited", "Number of rate limit responses (429 or 403) of the file fetches waited for and retried by the HTTP client.", c.index)
+	metrics.RegisterPrometheusCounter("repository_fetch_failed", "Number of repository whose file could not be fetched after retries.", c.index)
+	metrics.RegisterPrometheusCounter("repository_file_pruned", "Number of stale file removed by PRUNE_STALE.", c.index)
+	metrics.RegisterPrometheusCounter("repository_timeout", "Number of repository abandoned after REPO_TIMEOUT.", c.index)
+	metrics.RegisterPrometheusCounter("domain_circuit_open", "Number of times the circuit breaker of a domain opened.", c.index)
+	metrics.RegisterPrometheusHistogramVec("repository_fetch_duration_seconds", "Duration of the file fetch requests.", c.index,
+		[]float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}, "domain")
+	metrics.RegisterPrometheusCounterVec("repository_fetch_status_total", "Number of file fetch responses by HTTP status.", c.index, "domain", "status")
+	metrics.RegisterPrometheusGaugeVec("crawler_build_info", "Build of the running crawler, always 1.", c.index, "version", "commit", "date")
+	metrics.GetGaugeVec("crawler_build_info", c.index, "version", "commit", "date").
+		WithLabelValues(version.VERSION, version.COMMIT, version.DATE).Set(1)
+	metrics.RegisterPrometheusGauge("vendors_valid", "Number of distinct vendors with at least a valid file in the crawl.", c.index)
+	metrics.RegisterPrometheusGauge("repository_channel_depth", "Number of repositories queued to be processed.", c.index)
+	metrics.RegisterPrometheusGaugeVec("github_token_remaining", "Number of GitHub API requests remaining for each token.", c.index, "token")
+	metrics.RegisterPrometheusGaugeVec("domain_concurrency", "Number of file requests allowed at the same time to each domain, with ADAPTIVE_CONCURRENCY.", c.index, "domain")
+	metrics.RegisterPrometheusGaugeVec("ratelimit_remaining", "Number of API requests remaining before the rate limit.", c.index, "domain")
+	metrics.RegisterPrometheusGaugeVec("domain_last_success_timestamp", "Unix time of the last organization listed to the end without errors.", c.index, "domain")
+	metrics.RegisterPrometheusCounterVec("repository_file_saved_valid", "Number of file saved, by validation result (valid or invalid).", c.index, "domain", "result")
 
 	return &c
 }
@@ -114,7 +282,7 @@ func (c *Crawler) CrawlRepo(repoURL string) error {
 	}
 
 	// Process repository.
-	err = domain.processSingleRepo(repoURL, c.repositories, PA{})
+	err = domain.processSingleRepo(c.ctx, repoURL, c.repositories, PA{})
 	if err != nil {
 		return err
 	}
@@ -122,6 +290,19 @@ func (c *Crawler) CrawlRepo(repoURL string) error {
 	return c.crawl()
 }
 
+// ProcessSingleRepo crawls right away the repository fullName (e.g. "italia/developers-italia-backend")
+// hosted by source (e.g. "github.com", or the host of a domain), without listing the organization.
+// The file is fetched, saved, validated and indexed like in a full crawl.
+func (c *Crawler) ProcessSingleRepo(source, fullName string) error {
+	source = strings.Trim(source, "/")
+	fullName = strings.Trim(fullName, "/")
+	if source == "" || fullName == "" {
+		return errors.New("source and full name of the repository are required")
+	}
+
+	return c.CrawlRepo("https://" + source + "/" + fullName)
+}
+
 // CrawlPublishers processes a list of publishers.
 func (c *Crawler) CrawlPublishers(publishers []PA) error {
 	// Count configured orgs
@@ -131,11 +312,21 @@ func (c *Crawler) CrawlPublishers(publishers []PA) error {
 	}
 	log.Infof("%v organizations belonging to %v publishers are going to be scanned",
 		orgCount, len(publishers))
+	start := time.Now()
 
-	// Process every item in publishers.
+	// Process every item in publishers, at most MAX_CONCURRENT_DOMAINS at the same time.
+	// The slot is acquired by the goroutine, so that the repositories are processed meanwhile.
+	sem := make(chan struct{}, maxConcurrentDomains())
+	jitter := viper.GetDuration("DOMAIN_START_JITTER")
 	for _, pa := range publishers {
 		c.publishersWg.Add(1)
-		go c.CrawlPublisher(pa)
+		go func(pa PA) {
+			// Spread the starts over DOMAIN_START_JITTER, CrawlPublisher returns right away if interrupted.
+			_ = sleepContext(c.ctx, startJitter(jitter))
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			c.CrawlPublisher(pa)
+		}(pa)
 	}
 
 	// Close the repositories channel when all the publisher goroutines are done
@@ -144,7 +335,49 @@ func (c *Crawler) CrawlPublishers(publishers []PA) error {
 		close(c.repositories)
 	}()
 
-	return c.crawl()
+	err := c.crawl()
+	if err != nil {
+		return err
+	}
+
+	// Record the completed crawl, the next incremental one will start from here.
+	if !dryRun() && c.ctx.Err() == nil {
+		err = saveLastCrawls(c.completedDomains(), start)
+		if err != nil {
+			log.Errorf("Error saving the last crawl times: %v", err)
+		}
+
+		// Compare the repositories seen with the previous crawl, and record them for the next one.
+		err = c.saveSeen(start)
+		if err != nil {
+			log.Errorf("Error saving the repositories seen: %v", err)
+		}
+
+		// Remove the files of the repositories not seen anymore.
+		if viper.GetBool("PRUNE_STALE") {
+			err = c.pruneStale()
+			if err != nil {
+				log.Errorf("Error pruning the stale files: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// maxConcurrentDomains returns the maximum number of publishers crawled at the same time
+// (MAX_CONCURRENT_DOMAINS), by default 4.
+func maxConcurrentDomains() int {
+	n := viper.GetInt("MAX_CONCURRENT_DOMAINS")
+	if n <= 0 {
+		n = 4
+	}
+	return n
+}
+
+// dryRun returns true if nothing is saved or indexed: in dry run (DRY_RUN) or discovery mode.
+func dryRun() bool {
+	return viper.GetBool("DRY_RUN") || discoveryFile() != ""
 }
 
 func (c *Crawler) crawl() error {
@@ -152,12 +385,67 @@ func (c *Crawler) crawl() error {
 	go metrics.StartPrometheusMetricsServer()
 
 	defer c.publishersWg.Wait()
+	defer func() {
+		if c.shutdownTracing == nil {
+			return
+		}
+		if err := c.shutdownTracing(context.Background()); err != nil {
+			log.Errorf("Error flushing the spans: %v", err)
+		}
+	}()
+
+	// Only write the repositories found in discovery mode.
+	if file := discoveryFile(); file != "" {
+		return c.discoverRepositories(file)
+	}
 
 	// Process the repositories in order to retrieve the files.
+	c.summary.begin()
+	stopSampling := c.sampleChannelDepth(time.Second)
 	c.ProcessRepositories()
+	stopSampling()
+	// The abandoned repositories stop at the end of their step, before the outputs are written and closed.
+	if !c.waitAbandoned(abandonedWait) {
+		log.Warnf("Some abandoned repositories are still processed after %v", abandonedWait)
+	}
+	summary := c.summary.log()
+
+	// Nothing was saved or indexed in dry run mode.
+	if viper.GetBool("DRY_RUN") {
+		return nil
+	}
+
+	if viper.GetBool("SUMMARY_ENABLED") {
+		err := summary.save()
+		if err != nil {
+			log.Errorf("Error saving the crawl summary: %v", err)
+		}
+	}
+
+	// Write the validation outcomes for the publishers.
+	err := c.report.save()
+	if err != nil {
+		log.Errorf("Error saving the validation report: %v", err)
+	}
+
+	// Write the queued repositories metadata.
+	if c.sink != nil {
+		err := c.sink.Close()
+		if err != nil {
+			log.Errorf("Error writing the repositories metadata: %v", err)
+		}
+	}
+
+	// Send the queued documents to ES.
+	if c.esBulk != nil {
+		err := c.esBulk.Close()
+		if err != nil {
+			log.Errorf("Error in ES bulk indexing: %v", err)
+		}
+	}
 
 	// ElasticFlush to flush all the operations on ES.
-	err := elastic.Flush(c.index, c.es)
+	err = elastic.Flush(c.index, c.es)
 	if err != nil {
 		log.Errorf("Error flushing ElasticSearch: %v", err)
 	}
@@ -175,8 +463,18 @@ func (c *Crawler) crawl() error {
 	return nil
 }
 
-// ExportForJekyll exports YAML data files for the Jekyll website.
+// InvalidFiles returns the number of repositories with an invalid file in the crawl, for FAIL_ON_INVALID.
+func (c *Crawler) InvalidFiles() int {
+	return c.summary.snapshot().Total.Invalid
+}
+
+// ExportForJekyll exports YAML data files for the Jekyll website. Nothing is exported in dry run mode,
+// see dryRun, since nothing was indexed.
 func (c *Crawler) ExportForJekyll() error {
+	if dryRun() {
+		log.Info("Dry run, the data files for Jekyll are not exported")
+		return nil
+	}
 	return jekyll.GenerateJekyllYML(c.es)
 }
 
@@ -186,6 +484,11 @@ func (c *Crawler) CrawlPublisher(pa PA) {
 	defer c.publishersWg.Done()
 
 	for _, orgURL := range pa.Organizations {
+		// Stop feeding new repositories when shutting down.
+		if c.ctx.Err() != nil {
+			return
+		}
+
 		// Check if host is in list of known code hosting domains
 		domain, err := c.KnownHost(orgURL)
 		if err != nil {
@@ -197,43 +500,148 @@ func (c *Crawler) CrawlPublisher(pa PA) {
 	}
 
 	for _, repoURL := range pa.Repositories {
+		if c.ctx.Err() != nil {
+			return
+		}
+
 		// Check if host is in list of known code hosting domains
 		domain, err := c.KnownHost(repoURL)
 		if err != nil {
 			log.Error(err)
 		}
 
-		domain.processSingleRepo(repoURL, c.repositories, pa)
+		err = domain.processSingleRepo(c.ctx, repoURL, c.repositories, pa)
+		if err != nil {
+			log.WithField("url", repoURL).WithError(err).Error("error reading repository")
+			c.seen.listError(domain.Host)
+		}
 	}
 }
 
+// sampleChannelDepth sets repository_channel_depth to the number of queued repositories every interval,
+// until the returned function is called.
+func (c *Crawler) sampleChannelDepth(interval time.Duration) func() {
+	gauge := metrics.GetGauge("repository_channel_depth", c.index)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			gauge.Set(float64(len(c.repositories)))
+			select {
+			case <-ticker.C:
+			case <-done:
+				gauge.Set(0)
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
 // CrawlOrg fetches all the repositories belonging to an org and crawls them.
 func (c *Crawler) CrawlOrg(orgURL string, domain *Domain, pa PA) {
 	orgURLs, err := domain.generateAPIURLs(orgURL)
 	if err != nil {
-		log.Errorf("generateAPIURLs error: %v", err)
+		log.WithFields(log.Fields{"domain": domain.Host, "url": orgURL}).WithError(err).Error("generateAPIURLs error")
+		c.seen.listError(domain.Host)
 	}
+	configured := c.isConfigured(domain.Host)
+
+	ctx, span := tracer.Start(c.ctx, "CrawlOrg", trace.WithAttributes(
+		attribute.String("domain", domain.Host),
+		attribute.String("org_url", orgURL),
+	))
+	defer span.End()
 
 ORG:
 	for _, orgURL := range orgURLs {
 		// Process the pages until the end is reached.
 		for {
-			nextURL, err := domain.processAndGetNextURL(orgURL, c.repositories, pa)
+			// Stop between pages when shutting down.
+			if ctx.Err() != nil {
+				log.WithFields(log.Fields{"domain": domain.Host, "url": orgURL}).Info("Shutting down, processing stopped")
+				return
+			}
+			// Stop between pages when the domain is removed by a reload.
+			if configured && !c.isConfigured(domain.Host) {
+				log.WithFields(log.Fields{"domain": domain.Host, "url": orgURL}).Info("Domain removed, processing stopped")
+				return
+			}
+
+			// Respect the request rate of the domain.
+			if err := waitDomainLimit(ctx, *domain); err != nil {
+				return
+			}
+
+			pageCtx, pageSpan := tracer.Start(ctx, "processAndGetNextURL", trace.WithAttributes(attribute.String("url", orgURL)))
+			repositories, sent := c.pageRepositories(pageCtx, *domain)
+			nextURL, err := domain.processAndGetNextURL(pageCtx, orgURL, repositories, pa)
+			sent()
+			endSpan(pageSpan, err)
 			if err != nil {
-				log.Errorf("error reading %s repository list: %v; nextURL: %v", orgURL, err, nextURL)
+				log.WithFields(log.Fields{"domain": domain.Host, "url": orgURL, "next_url": nextURL}).WithError(err).Error("error reading repository list")
+				c.seen.listError(domain.Host)
 				continue ORG
 			}
 
+			// Stop the pagination once MAX_REPOS_PER_DOMAIN repositories are emitted.
+			if max := maxReposPerDomain(); max > 0 && c.limits.reached(domain.Host, max) {
+				log.WithFields(log.Fields{"domain": domain.Host, "url": orgURL}).Infof("MAX_REPOS_PER_DOMAIN (%d) reached, processing stopped", max)
+				if nextURL != "" {
+					c.seen.notProcessed(domain.Host)
+				}
+				return
+			}
+
 			// If end is reached or fails, nextURL is empty.
 			if nextURL == "" {
+				metrics.GetGaugeVec("domain_last_success_timestamp", c.index, "domain").WithLabelValues(domain.Host).SetToCurrentTime()
 				return
 			}
+			// Wait PAGE_DELAY before asking the next page.
+			if delay := c.pageDelay(); delay > 0 {
+				if err := sleepContext(ctx, delay); err != nil {
+					log.WithFields(log.Fields{"domain": domain.Host, "url": orgURL}).Info("Shutting down, processing stopped")
+					return
+				}
+			}
 			// Update url to nextURL.
 			orgURL = nextURL
 		}
 	}
 }
 
+// handleShutdown calls cancel on SIGINT/SIGTERM, so that the crawl stops starting new work
+// while the in-flight repositories are completed. A second signal terminates the process.
+func handleShutdown(cancel context.CancelFunc) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigs
+		log.Warnf("Received %v, shutting down after the in-flight repositories", sig)
+		// Restore the default behavior for the next signals.
+		signal.Stop(sigs)
+		cancel()
+	}()
+}
+
+// handleDeadline calls cancel once the crawl lasted deadline (CRAWL_DEADLINE), if set, stopping it like SIGINT/SIGTERM.
+// The crawl is cancelled rather than given a context deadline, so that the in-flight repositories
+// are completed instead of being abandoned like the ones exceeding REPO_TIMEOUT.
+func handleDeadline(cancel context.CancelFunc, deadline time.Duration) {
+	if deadline <= 0 {
+		return
+	}
+
+	time.AfterFunc(deadline, func() {
+		log.Warnf("CRAWL_DEADLINE (%v) reached, shutting down after the in-flight repositories", deadline)
+		cancel()
+	})
+}
+
 // generateRandomInt returns an integer between 0 and max parameter.
 // "Max" must be less than math.MaxInt32
 func generateRandomInt(max int) (int, error) {
@@ -241,78 +649,360 @@ func generateRandomInt(max int) (int, error) {
 	return int(result.Int64()), err
 }
 
+// countValidVendor records the vendor of the repository with a valid file in the crawl summary,
+// updating the number of distinct vendors in vendors_valid.
+func (c *Crawler) countValidVendor(repository Repository) {
+	vendors := c.summary.validVendor(repository.Domain.Host, repository.Name)
+	metrics.GetGauge("vendors_valid", c.index).Set(float64(vendors))
+}
+
 // ProcessRepositories process the repositories channel and check the availability of the file.
+// If MAX_CONCURRENT_REQUESTS is set, a fixed pool of that many workers drains the channel,
+// otherwise every repository is processed in its own goroutine, or by a single worker in DETERMINISTIC mode.
 func (c *Crawler) ProcessRepositories() {
-	for repository := range c.repositories {
-		c.repositoriesWg.Add(1)
-		go c.ProcessRepo(repository)
+	workers := viper.GetInt("MAX_CONCURRENT_REQUESTS")
+	if workers <= 0 && deterministicEnabled() {
+		workers = 1
 	}
+	if workers <= 0 {
+		for repository := range c.repositories {
+			if c.skipRepository(repository) {
+				continue
+			}
+			c.repositoriesWg.Add(1)
+			go c.ProcessRepo(repository)
+		}
+		c.repositoriesWg.Wait()
+		return
+	}
+
+	var workersWg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		workersWg.Add(1)
+		go func() {
+			defer workersWg.Done()
+			for repository := range c.repositories {
+				if c.skipRepository(repository) {
+					continue
+				}
+				c.repositoriesWg.Add(1)
+				c.ProcessRepo(repository)
+			}
+		}()
+	}
+	workersWg.Wait()
 	c.repositoriesWg.Wait()
 }
 
 // ProcessRepo looks for a publiccode.yml file in a repository, and if found it processes it.
+// If REPO_TIMEOUT is set, the repository is abandoned once it expires: the fetch is cancelled and
+// the following steps are not started, so that a single repository can't hold a worker indefinitely.
 func (c *Crawler) ProcessRepo(repository Repository) {
 	// Defer waiting group close.
 	defer c.repositoriesWg.Done()
 
+	// Drain the queued repositories without processing them when shutting down.
+	if c.ctx.Err() != nil {
+		return
+	}
+
+	timeout := viper.GetDuration("REPO_TIMEOUT")
+	if timeout <= 0 {
+		c.processRepo(c.ctx, repository)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	c.timeoutWg.Add(1)
+	go func() {
+		defer c.timeoutWg.Done()
+		defer close(done)
+		c.processRepo(ctx, repository)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		if ctx.Err() != context.DeadlineExceeded {
+			// Shutting down: complete the in-flight repository.
+			<-done
+			return
+		}
+		repository.logger().WithField("repo_timeout", timeout).Warn("repository timed out, abandoned")
+		metrics.GetCounter("repository_timeout", c.index).Inc()
+		c.seen.notProcessed(repository.Domain.Host)
+	}
+}
+
+// recordResult records the result of the validation of the file of repository in the report, the quarantine
+// state, the summary and the sink.
+func (c *Crawler) recordResult(repository Repository, validationErrs, warnings ValidationErrors, contentHash string) {
+	c.report.add(repository, validationErrs, warnings)
+	if err := recordValidation(repository, validationErrs); err != nil {
+		repository.logger().WithError(err).Warn("error saving the quarantine state")
+	}
+	c.summary.count(repository.Domain.Host, validationCount(validationErrs))
+	if c.sink != nil {
+		c.sink.Add(RepositoryRecord{
+			Source:      repository.Hostname,
+			Name:        repository.Name,
+			RawURL:      repository.FileRawURL,
+			Valid:       validationErrs == nil,
+			LastSeen:    time.Now(),
+			ContentHash: contentHash,
+		})
+	}
+}
+
+// abandonedWait is how long the crawl waits for the repositories abandoned after REPO_TIMEOUT to stop.
+const abandonedWait = time.Minute
+
+// waitAbandoned waits, at most for timeout, for the processing of the repositories abandoned after REPO_TIMEOUT
+// to stop. It returns false if some are still processed.
+func (c *Crawler) waitAbandoned(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		c.timeoutWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// processRepo processes the repository, stopping between the steps when ctx is done.
+func (c *Crawler) processRepo(ctx context.Context, repository Repository) {
+	ctx, span := startRepositorySpan(ctx, repository)
+	defer span.End()
+
 	// Increment counter for the number of repositories processed.
 	metrics.GetCounter("repository_processed", c.index).Inc()
+	c.summary.count(repository.Domain.Host, func(s *summaryCounts) { s.Repositories++ })
 
-	resp, err := httpclient.GetURL(repository.FileRawURL, repository.Headers)
+	// Don't revalidate at every crawl the files that stay invalid.
+	skip, err := skipQuarantined(repository)
+	if err != nil {
+		repository.logger().WithError(err).Warn("error reading the quarantine state")
+	}
+	if skip {
+		repository.logger().Debug("repository quarantined, skipped")
+		metrics.GetCounter("repository_quarantined", c.index).Inc()
+		// Keep its saved files.
+		c.seen.add(repository)
+		return
+	}
+
+	fetchCtx, fetchSpan := tracer.Start(ctx, "fetchFile")
+	repository, resp, err := c.fetchFile(fetchCtx, repository)
+	endSpan(fetchSpan, err)
+	logger := repository.logger()
+	metrics.GetCounterVec("repository_fetch_status_total", c.index, "domain", "status").
+		WithLabelValues(repository.Domain.Host, fetchStatusLabel(resp)).Inc()
+
+	// The file is too large to be a publiccode.yml, it was not read.
+	if errors.Is(err, httpclient.ErrBodyTooLarge) {
+		logger.WithField("max_file_size", maxFileSize()).Warn("file too large, skipped")
+		metrics.GetCounter("repository_file_too_large", c.index).Inc()
+		c.summary.count(repository.Domain.Host, func(s *summaryCounts) { s.Failed++ })
+		return
+	}
+
+	// The file can't be reached following the redirects, but it may still exist.
+	if httpclient.IsRedirectFailure(err) {
+		logger.WithField("error", err.Error()).Warn("redirect failed")
+		metrics.GetCounter("repository_redirect_loop", c.index).Inc()
+		c.seen.add(repository)
+		c.summary.count(repository.Domain.Host, func(s *summaryCounts) { s.Failed++ })
+		return
+	}
+
+	// The file is unchanged since the last crawl, no need to save and validate it again:
+	// the result of the last validation is recorded as is.
+	if resp.Status.Code == http.StatusNotModified && err == nil {
+		c.seen.add(repository)
+		logger.Debug("publiccode.yml not modified")
+		metrics.GetCounter("repository_not_modified", c.index).Inc()
+		if validators, ok := loadCacheValidators(repository, c.index); ok && validators.Validated {
+			c.summary.count(repository.Domain.Host, func(s *summaryCounts) { s.Found++ })
+			c.recordResult(repository, validators.Errors, validators.Warnings, validators.ContentHash)
+		}
+		return
+	}
 
 	if resp.Status.Code != http.StatusOK || err != nil {
+		if errors.Is(err, ErrNotFound) {
+			logger.Debug("publiccode.yml not found")
+			return
+		}
+
 		// Failed to retrieve publiccode.yml
+		if shouldRetry(resp.Status.Code, err) || errors.Is(err, errCircuitOpen) {
+			// The file may still exist, keep it. The domain is crawled again from here in incremental mode.
+			c.seen.add(repository)
+			c.seen.fail(repository.Domain.Host)
+			c.summary.count(repository.Domain.Host, func(s *summaryCounts) { s.Failed++ })
+		}
+		return
+	}
+
+	// Don't save the error pages returned with status 200 by misconfigured endpoints.
+	if err := checkYAMLResponse(resp); err != nil {
+		logger.WithError(err).Warn("not a YAML file, skipped")
+		metrics.GetCounter("repository_non_yaml_response", c.index).Inc()
+		c.seen.add(repository)
+		c.summary.count(repository.Domain.Host, func(s *summaryCounts) { s.Failed++ })
+		return
+	}
+
+	logger.Info("publiccode.yml found")
+	c.summary.count(repository.Domain.Host, func(s *summaryCounts) { s.Found++ })
+
+	// Skip the repositories already processed in this crawl (e.g. mirrored on another domain).
+	if dedupEnabled() {
+		if first, ok := c.duplicates.check(repository, cloneURLKey(repository.GitCloneURL), contentKey(resp.Body)); !ok {
+			logger.WithFields(log.Fields{"winner_domain": first.Domain.Host, "winner_repo_name": first.Name}).Info("duplicate repository skipped")
+			metrics.GetCounter("repository_duplicate", c.index).Inc()
+			return
+		}
+	}
+
+	c.seen.add(repository)
+
+	// In dry run mode only validate the publiccode.yml, without writing anything.
+	if viper.GetBool("DRY_RUN") {
+		validationErrs, warnings := c.validateRemoteFile(ctx, resp.Body, repository.FileRawURL, repository.filename(), repository.Pa)
+		c.summary.count(repository.Domain.Host, validationCount(validationErrs))
+		if validationErrs == nil {
+			c.countValidVendor(repository)
+		}
+		if validationErrs != nil {
+			logger.WithField("validation_error", validationErrs.Error()).Warn("dry run: invalid publiccode.yml")
+		} else if warnings != nil {
+			logger.WithField("validation_warning", warnings.Error()).Info("dry run: valid publiccode.yml with warnings")
+		} else {
+			logger.Info("dry run: valid publiccode.yml")
+		}
 		return
 	}
 
-	log.Infof("[%s] publiccode.yml found at %s", repository.Name, repository.FileRawURL)
+	// Save the publiccode.yml, skipping the validation if it can't be saved.
+	if ctx.Err() != nil {
+		return
+	}
+	_, saveSpan := tracer.Start(ctx, "SaveToFile")
+	err = SaveToFile(repository.Domain, repository.folder(), repository.Name, repository.filename(), resp.Body, c.index)
+	endSpan(saveSpan, err)
+	if err != nil {
+		logger.WithError(err).Error("error saving to file")
+		metrics.GetCounter("repository_file_save_failed", c.index).Inc()
+		c.summary.count(repository.Domain.Host, func(s *summaryCounts) { s.Failed++ })
+		return
+	}
+	c.summary.count(repository.Domain.Host, func(s *summaryCounts) { s.Saved++ })
+	if viper.GetBool("WRITE_METADATA") {
+		err = saveFileMetadata(repository, c.index, resp, time.Now())
+		if err != nil {
+			logger.WithError(err).Warn("error saving the file metadata")
+		}
+	}
 
 	// Validate the publiccode.yml
-	err = validateRemoteFile(resp.Body, repository.FileRawURL, repository.Pa)
+	_, validateSpan := tracer.Start(ctx, "validateRemoteFile")
+	validationErrs, warnings := c.validateRemoteFile(ctx, resp.Body, repository.FileRawURL, repository.filename(), repository.Pa)
+	validateSpan.SetAttributes(attribute.Bool("valid", validationErrs == nil))
+	validateSpan.End()
+	if ctx.Err() != nil {
+		return
+	}
+	// The validators are saved with the validation result, replayed when the file is not modified.
+	err = saveCacheValidators(repository, c.index, resp, validationErrs, warnings)
 	if err != nil {
-		log.Errorf("[%s] invalid publiccode.yml: %+v", repository.Name, err)
+		logger.WithError(err).Warn("error saving the cache validators")
+	}
+	c.recordResult(repository, validationErrs, warnings, contentKey(resp.Body))
+	if warnings.has(specVersionKey) {
+		metrics.GetCounter("repository_file_version_mismatch", c.index).Inc()
+	}
+	metrics.GetCounterVec("repository_file_saved_valid", c.index, "domain", "result").
+		WithLabelValues(repository.Domain.Host, validationLabel(validationErrs)).Inc()
+	if validationErrs != nil {
+		logger.WithField("validation_error", validationErrs.Error()).Error("invalid publiccode.yml")
 		logBadYamlToFile(repository.FileRawURL)
 		return
 	}
+	c.countValidVendor(repository)
+	// The warnings don't prevent the file from being indexed.
+	if warnings != nil {
+		logger.WithField("validation_warning", warnings.Error()).Warn("publiccode.yml has warnings")
+		metrics.GetCounter("repository_file_saved_warnings", c.index).Inc()
+	}
+
+	// Notify the valid repositories found for the first time.
+	if notifyEnabled() {
+		err = c.notifyIfNewRepository(repository)
+		if err != nil {
+			logger.WithError(err).Warn("error notifying the new repository")
+		}
+	}
 
 	// Clone repository.
-	err = CloneRepository(repository.Domain, repository.Hostname, repository.Name, repository.GitCloneURL, repository.GitBranch, c.index)
+	if ctx.Err() != nil {
+		return
+	}
+	err = CloneRepository(repository.Domain, repository.folder(), repository.Name, repository.GitCloneURL, repository.GitBranch, c.index)
 	if err != nil {
-		log.Errorf("[%s] error while cloning: %v", repository.Name, err)
+		logger.WithError(err).Error("error while cloning")
 	}
 
 	// Calculate Repository activity index and vitality.
 	activityIndex, vitality, err := repository.CalculateRepoActivity(60)
 	if err != nil {
-		log.Errorf("[%s] error calculating activity index: %v", repository.Name, err)
+		logger.WithError(err).Error("error calculating activity index")
 	}
-	log.Infof("[%s] activity index: %f", repository.Name, activityIndex)
+	logger.WithField("activity_index", activityIndex).Info("activity index calculated")
 	var vitalitySlice []int
 	for i := 0; i < len(vitality); i++ {
 		vitalitySlice = append(vitalitySlice, int(vitality[i]))
 	}
 
 	// Save to ES.
+	if c.esBulk == nil || ctx.Err() != nil {
+		return
+	}
 	err = c.saveToES(repository, activityIndex, vitalitySlice, resp.Body)
 	if err != nil {
-		log.Errorf("[%s] error saving to ElastcSearch: %v", repository.Name, err)
+		logger.WithError(err).Error("error saving to ElastcSearch")
 	}
 }
 
-func validateRemoteFile(data []byte, fileRawURL string, pa PA) error {
-	parser := publiccode.NewParser()
-	parser.Strict = false
-	parser.RemoteBaseURL = strings.TrimRight(fileRawURL, viper.GetString("CRAWLED_FILENAME"))
-
-	err := parser.Parse(data)
+// validateRemoteFile validates the publiccode.yml with the configured Validator and returns the errors found,
+// or nil if it's valid, and the warnings of a valid file if the Validator reports them.
+// A version different from PUBLICCODE_TARGET_VERSION is a warning of both the valid and invalid files.
+func (c *Crawler) validateRemoteFile(ctx context.Context, data []byte, fileRawURL, filename string, pa PA) (ValidationErrors, ValidationErrors) {
+	baseURL := remoteBaseURL(fileRawURL, filename)
+	publicCode, err := c.validator.Validate(ctx, data, baseURL)
+	versionWarning := specVersionWarning(publicCode)
 	if err != nil {
-		log.Errorf("Error parsing publiccode.yml for %s.", fileRawURL)
-		return err
+		log.WithFields(log.Fields{"raw_url": fileRawURL, "validation_error": err.Error()}).Error("Error parsing publiccode.yml")
+		return newValidationErrors(err), versionWarning
 	}
 
-	if pa.CodiceIPA != "" && parser.PublicCode.It.Riuso.CodiceIPA != "" && !strings.EqualFold(pa.CodiceIPA, parser.PublicCode.It.Riuso.CodiceIPA) {
-		return errors.New("codiceIPA for: " + fileRawURL + " is " + parser.PublicCode.It.Riuso.CodiceIPA + ", which differs from the one assigned to the org in the whitelist: " + pa.CodiceIPA)
+	if pa.CodiceIPA != "" && publicCode.It.Riuso.CodiceIPA != "" && !strings.EqualFold(pa.CodiceIPA, publicCode.It.Riuso.CodiceIPA) {
+		return ValidationErrors{{
+			Key:    "it/riuso/codiceIPA",
+			Reason: publicCode.It.Riuso.CodiceIPA + " differs from the one assigned to the org in the whitelist: " + pa.CodiceIPA,
+		}}, versionWarning
 	}
 
-	return err
+	if v, ok := c.validator.(warningValidator); ok {
+		return nil, append(versionWarning, v.Warnings(data, baseURL)...)
+	}
+	return nil, versionWarning
 }
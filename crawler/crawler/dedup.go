@@ -0,0 +1,65 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// duplicates keeps track of the repositories processed in the crawl run, so that the
+// same repository mirrored on more domains is processed only once.
+type duplicates struct {
+	seen sync.Map
+}
+
+// check records repository under each of keys and, if one of them was already recorded
+// by another repository, returns false and the repository that was recorded first.
+func (d *duplicates) check(repository Repository, keys ...string) (Repository, bool) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if first, loaded := d.seen.LoadOrStore(key, repository); loaded {
+			return first.(Repository), false
+		}
+	}
+
+	return Repository{}, true
+}
+
+// dedupEnabled returns true if the duplicates have to be skipped (DEDUPLICATE).
+func dedupEnabled() bool {
+	return viper.GetBool("DEDUPLICATE")
+}
+
+// cloneURLKey returns the deduplication key of a git clone url.
+func cloneURLKey(gitCloneURL string) string {
+	normalized := normalizeCloneURL(gitCloneURL)
+	if normalized == "" {
+		return ""
+	}
+	return "clone:" + normalized
+}
+
+// contentKey returns the deduplication key of the fetched file content.
+func contentKey(data []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+}
+
+// normalizeCloneURL returns host/path of a git clone url, lowercase, without the scheme,
+// the credentials and the .git suffix.
+// IN: https://user@GitHub.com/italia/developers-italia-backend.git
+// OUT: github.com/italia/developers-italia-backend
+func normalizeCloneURL(gitCloneURL string) string {
+	u, err := url.Parse(gitCloneURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	p := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+
+	return strings.ToLower(u.Hostname() + "/" + p)
+}
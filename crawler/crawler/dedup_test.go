@@ -0,0 +1,38 @@
+package crawler
+
+import (
+	"testing"
+)
+
+// TestNormalizeCloneURL checks that different urls of the same repository have the same key.
+func TestNormalizeCloneURL(t *testing.T) {
+	urls := []struct {
+		in  string
+		out string
+	}{
+		{"https://github.com/italia/developers-italia-backend.git", "github.com/italia/developers-italia-backend"},
+		{"https://user@GitHub.com/Italia/developers-italia-backend/", "github.com/italia/developers-italia-backend"},
+		{"http://github.com:80/italia/developers-italia-backend", "github.com/italia/developers-italia-backend"},
+		{"not an url", ""},
+	}
+
+	for _, u := range urls {
+		if out := normalizeCloneURL(u.in); out != u.out {
+			t.Errorf("Expected %s == %s, got %s", u.in, u.out, out)
+		}
+	}
+}
+
+// TestDuplicatesCheck checks that only the first repository with a key is accepted.
+func TestDuplicatesCheck(t *testing.T) {
+	var d duplicates
+	first := Repository{Name: "italia/a", Domain: Domain{Host: "github.com"}}
+	mirror := Repository{Name: "italia/a", Domain: Domain{Host: "gitlab.com"}}
+
+	if _, ok := d.check(first, "clone:github.com/italia/a", "sha256:1"); !ok {
+		t.Errorf("Expected %s to be accepted", first.Domain.Host)
+	}
+	if winner, ok := d.check(mirror, "clone:gitlab.com/italia/a", "sha256:1"); ok || winner.Domain.Host != first.Domain.Host {
+		t.Errorf("Expected %s to be a duplicate of %s, got %v (winner %s)", mirror.Domain.Host, first.Domain.Host, ok, winner.Domain.Host)
+	}
+}
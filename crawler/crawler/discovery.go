@@ -0,0 +1,54 @@
+package crawler
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// discoveredRepository is a line of the DISCOVERY_FILE: a repository and the url of its file, not fetched.
+type discoveredRepository struct {
+	Name   string `json:"name"`
+	RawURL string `json:"raw_url"`
+	Domain string `json:"domain"`
+}
+
+// discoveryFile returns the file where the discovered repositories are written in discovery mode
+// (DISCOVERY_FILE), or "" if not in discovery mode.
+func discoveryFile() string {
+	return viper.GetString("DISCOVERY_FILE")
+}
+
+// discoverRepositories writes the repositories of the channel to file as JSON lines, without
+// fetching their files. The channel is drained even if writing fails.
+func (c *Crawler) discoverRepositories(file string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		for range c.repositories {
+		}
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for repository := range c.repositories {
+		if err != nil || c.skipRepository(repository) {
+			continue
+		}
+		err = enc.Encode(discoveredRepository{
+			Name:   repository.Name,
+			RawURL: repository.FileRawURL,
+			Domain: repository.Domain.Host,
+		})
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
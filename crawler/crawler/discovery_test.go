@@ -0,0 +1,60 @@
+package crawler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TestDiscoverRepositories checks that the repositories are written as JSON lines, without the headers.
+func TestDiscoverRepositories(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := Crawler{repositories: make(chan Repository, 2)}
+	c.repositories <- Repository{
+		Name:       "italia/repo",
+		FileRawURL: "https://raw.githubusercontent.com/italia/repo/master/publiccode.yml",
+		Domain:     Domain{Host: "github.com"},
+		Headers:    map[string]string{"Authorization": "secret"},
+	}
+	c.repositories <- Repository{
+		Name:       "group/project",
+		FileRawURL: "https://gitlab.com/group/project/-/raw/master/publiccode.yml",
+		Domain:     Domain{Host: "gitlab.com"},
+	}
+	close(c.repositories)
+
+	file := filepath.Join(dir, "discovered.jsonl")
+	if err := c.discoverRepositories(file); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("Expected no headers in %s", data)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+	var discovered discoveredRepository
+	if err := json.Unmarshal([]byte(lines[1]), &discovered); err != nil ||
+		discovered != (discoveredRepository{"group/project", "https://gitlab.com/group/project/-/raw/master/publiccode.yml", "gitlab.com"}) {
+		t.Errorf("Unexpected line %s (%v)", lines[1], err)
+	}
+}
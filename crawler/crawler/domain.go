@@ -1,13 +1,19 @@
 package crawler
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/url"
+	"os"
+	"regexp"
 	"strings"
+	"time"
 
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 	"gopkg.in/yaml.v2"
 )
 
@@ -15,11 +21,63 @@ import (
 type Domain struct {
 	// Domains.yml data
 	Host      string   `yaml:"host"`
+	Type      string   `yaml:"type"`
 	BasicAuth []string `yaml:"basic-auth"`
+	// Maximum requests per second, RATELIMIT_DEFAULT if unset.
+	RateLimit float64 `yaml:"rate-limit"`
+	// Candidate names of the crawled file, in order of preference. CRAWLED_FILENAME if unset.
+	Filenames []string `yaml:"filenames"`
+	// Headers of the file requests, instead of the API ones (e.g. a different token), if set.
+	RawHeaders map[string]string `yaml:"raw-headers"`
+
+	// User-Agent of the requests to the domain, instead of USER_AGENT, if set.
+	UserAgent string `yaml:"user-agent"`
+	// Stable name of the folder of the domain in the data directory, instead of the host of the repositories, if set.
+	ID string `yaml:"id"`
+	// Don't send the HEAD requests of HEAD_PREFLIGHT, e.g. if the domain answers 404 or 405 to HEAD for existing files.
+	NoHeadPreflight bool `yaml:"no-head-preflight"`
+	// Read the files with the contents API of GitHub, with the API token, instead of their raw urls.
+	ContentsAPI bool `yaml:"contents-api"`
+	// Organization whose repositories are listed on Gitea for the whitelist urls without one, instead of searching
+	// all the repositories of the instance.
+	Org string `yaml:"org"`
+
+	// Start time of the previous crawl in incremental mode: the repositories not updated since are skipped.
+	since time.Time
+	// Lookup of the Domains of the sources of a "file" domain, set by KnownHost.
+	resolve func(link string) (*Domain, error)
+}
+
+// crawledFilenames returns the names of the file to look for in the repositories of the Domain.
+func (domain Domain) crawledFilenames() []string {
+	if len(domain.Filenames) > 0 {
+		return domain.Filenames
+	}
+	return []string{viper.GetString("CRAWLED_FILENAME")}
 }
 
-// API returns a Domain without tld.
+// authHeader returns the Authorization header for the API of the Domain, one of its basic-auth values
+// picked at random to spread the requests over the tokens, or an empty string if there is none.
+// The values are already in the form expected by the API (e.g. "token <token>" for Gitea,
+// "Basic <base64(:PAT)>" for Azure DevOps, "Bearer <token>" for git.sr.ht).
+func (domain Domain) authHeader() (string, error) {
+	if len(domain.BasicAuth) == 0 {
+		return "", nil
+	}
+	n, err := generateRandomInt(len(domain.BasicAuth))
+	if err != nil {
+		return "", err
+	}
+	return domain.BasicAuth[n], nil
+}
+
+// API returns the client API of the Domain: the configured type if set,
+// otherwise the Domain without tld.
 func (domain Domain) API() string {
+	if domain.Type != "" {
+		return domain.Type
+	}
+
 	truncateIndex := strings.LastIndexAny(domain.Host, ".")
 	// It is already an API without tld.
 	if truncateIndex == -1 {
@@ -58,20 +116,147 @@ func parseDomainsFile(data []byte) ([]Domain, error) {
 	return domains, err
 }
 
-func (domain Domain) processAndGetNextURL(url string, repositories chan Repository, pa PA) (string, error) {
+// Validate checks that the Domain has a valid host, a registered client API and valid options.
+func (domain Domain) Validate() error {
+	var errs []string
+
+	if domain.Host == "" {
+		errs = append(errs, "missing host")
+	} else if u, err := url.Parse("//" + domain.Host); err != nil || u.Host != domain.Host || u.Port() != "" {
+		errs = append(errs, "host must be a hostname, without scheme, port or path")
+	}
+	if _, ok := clientAPIs[domain.API()]; !ok {
+		if domain.Type != "" {
+			errs = append(errs, "unknown type "+domain.Type)
+		} else {
+			errs = append(errs, "unknown client API "+domain.API()+", set the type")
+		}
+	}
+	for _, auth := range domain.BasicAuth {
+		if strings.TrimSpace(auth) == "" {
+			errs = append(errs, "empty basic-auth entry")
+			break
+		}
+	}
+	if domain.ID == "." || domain.ID == ".." || strings.ContainsAny(domain.ID, `/\`) {
+		errs = append(errs, "invalid id "+domain.ID)
+	}
+	if domain.RateLimit < 0 {
+		errs = append(errs, "negative rate-limit")
+	}
+	for _, filename := range domain.Filenames {
+		if filename == "" || strings.Contains(filename, "/") {
+			errs = append(errs, "invalid filename "+filename)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// envReference matches the ${ENV_VAR} references in the options of the domains.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces the ${ENV_VAR} references in s with the values of the environment variables,
+// appending the names of the unset ones to missing.
+func expandEnv(s string, missing *[]string) string {
+	return envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			*missing = append(*missing, name)
+		}
+		return value
+	})
+}
+
+// expandEnv replaces the ${ENV_VAR} references in the basic-auth, raw-headers and user-agent of the Domain
+// with the values of the environment variables (e.g. "token ${GITHUB_TOKEN}"), so that the secrets
+// can be kept out of domains.yml. It returns an error listing the unset variables.
+func (domain *Domain) expandEnv() error {
+	var missing []string
+
+	for i, auth := range domain.BasicAuth {
+		domain.BasicAuth[i] = expandEnv(auth, &missing)
+	}
+	for k, v := range domain.RawHeaders {
+		domain.RawHeaders[k] = expandEnv(v, &missing)
+	}
+	domain.UserAgent = expandEnv(domain.UserAgent, &missing)
+
+	if len(missing) > 0 {
+		return errors.New("missing environment variables " + strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// expandDomainsEnv expands the environment variables in all the domains, returning an error listing
+// every domain referencing unset ones.
+func expandDomainsEnv(domains []Domain) error {
+	var errs []string
+
+	for i := range domains {
+		if err := domains[i].expandEnv(); err != nil {
+			errs = append(errs, fmt.Sprintf("domain #%d (%s): %v", i+1, domains[i].Host, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid domains:\n" + strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// validateDomains validates all the domains, returning an error listing every invalid one.
+func validateDomains(domains []Domain) error {
+	var errs []string
+	hosts := make(map[string]bool)
+	ids := make(map[string]bool)
+
+	for i, domain := range domains {
+		if err := domain.Validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("domain #%d (%s): %v", i+1, domain.Host, err))
+		}
+		if hosts[domain.Host] {
+			errs = append(errs, fmt.Sprintf("domain #%d (%s): duplicate host", i+1, domain.Host))
+		}
+		hosts[domain.Host] = true
+		if domain.ID != "" && ids[domain.ID] {
+			errs = append(errs, fmt.Sprintf("domain #%d (%s): duplicate id %s", i+1, domain.Host, domain.ID))
+		}
+		ids[domain.ID] = true
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid domains:\n" + strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+func (domain Domain) processAndGetNextURL(ctx context.Context, url string, repositories chan Repository, pa PA) (string, error) {
 	crawler, err := GetClientAPICrawler(domain.API())
 	if err != nil {
 		return "", err
 	}
-	return crawler(domain, url, repositories, pa)
+	return crawler(domain.context(ctx), domain, url, repositories, pa)
 }
 
-func (domain Domain) processSingleRepo(url string, repositories chan Repository, pa PA) error {
+func (domain Domain) processSingleRepo(ctx context.Context, url string, repositories chan Repository, pa PA) error {
 	crawler, err := GetSingleClientAPICrawler(domain.API())
 	if err != nil {
 		return err
 	}
-	return crawler(domain, url, repositories, pa)
+	return crawler(domain.context(ctx), domain, url, repositories, pa)
+}
+
+// context returns a copy of ctx for the requests to the domain, with its User-Agent if set.
+func (domain Domain) context(ctx context.Context) context.Context {
+	if domain.UserAgent != "" {
+		return httpclient.WithUserAgent(ctx, domain.UserAgent)
+	}
+	return ctx
 }
 
 func (domain Domain) generateAPIURLs(u string) ([]string, error) {
@@ -90,9 +275,12 @@ func (c *Crawler) KnownHost(link string) (*Domain, error) {
 		return nil, fmt.Errorf("Invalid URL: %v", err)
 	}
 
-	for _, domain := range c.domains {
+	for _, domain := range c.currentDomains() {
 		if u.Hostname() == domain.Host {
 			// Host is found in the host list.
+			if domain.API() == "file" {
+				domain.resolve = c.KnownHost
+			}
 			return &domain, nil
 		}
 	}
@@ -107,6 +295,15 @@ func (c *Crawler) KnownHost(link string) (*Domain, error) {
 	} else if IsGitlab(link) {
 		log.Infof("%s - API inferred: %s", link, "gitlab")
 		return &Domain{Host: "gitlab"}, nil
+	} else if IsAzure(link) {
+		log.Infof("%s - API inferred: %s", link, "azure")
+		return &Domain{Host: "azure"}, nil
+	} else if IsSourcehut(link) {
+		log.Infof("%s - API inferred: %s", link, "sourcehut")
+		return &Domain{Host: "sourcehut"}, nil
+	} else if IsGitea(link) {
+		log.Infof("%s - API inferred: %s", link, "gitea")
+		return &Domain{Host: "gitea"}, nil
 	}
 
 	return &Domain{}, errors.New("unable to detect code hosting platform: " + u.Hostname())
@@ -2,6 +2,8 @@ package crawler
 
 import (
 	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 
 	log "github.com/sirupsen/logrus"
@@ -41,3 +43,82 @@ func TestParseDomainsFile(t *testing.T) {
 
 	}
 }
+
+// TestDomainValidate checks the validation of the domains in domains.yml.
+func TestDomainValidate(t *testing.T) {
+	RegisterClientAPIs()
+
+	domains := []struct {
+		in    Domain
+		valid bool
+	}{
+		{Domain{Host: "github.com"}, true},
+		{Domain{Host: "git.example.org", Type: "gitea", RateLimit: 5, Filenames: []string{"publiccode.yaml"}}, true},
+		{Domain{}, false},
+		{Domain{Host: "https://github.com"}, false},
+		{Domain{Host: "github.com/italia"}, false},
+		{Domain{Host: "git.example.org"}, false},
+		{Domain{Host: "git.example.org", Type: "svn"}, false},
+		{Domain{Host: "github.com", BasicAuth: []string{""}}, false},
+		{Domain{Host: "github.com", RateLimit: -1}, false},
+		{Domain{Host: "github.com", Filenames: []string{"dir/publiccode.yml"}}, false},
+		{Domain{Host: "github.com", ID: "github"}, true},
+		{Domain{Host: "github.com", ID: "../github"}, false},
+	}
+
+	for _, d := range domains {
+		if err := d.in.Validate(); (err == nil) != d.valid {
+			t.Errorf("Expected %+v valid == %t, got %v", d.in, d.valid, err)
+		}
+	}
+
+	if err := validateDomains([]Domain{{Host: "github.com"}, {Host: "github.com"}}); err == nil {
+		t.Error("Expected an error for duplicate hosts")
+	}
+	if err := validateDomains([]Domain{{Host: "github.com", ID: "git"}, {Host: "gitlab.com", ID: "git"}}); err == nil {
+		t.Error("Expected an error for duplicate ids")
+	}
+
+	repository := Repository{Hostname: "git.example.org", Domain: Domain{Host: "git.example.org", ID: "example"}}
+	if folder := repository.folder(); folder != "example" {
+		t.Errorf("Expected the files in the folder of the domain id, got %s", folder)
+	}
+}
+
+// TestDomainExpandEnv checks that the ${ENV_VAR} references are replaced, and that the unset ones are reported.
+func TestDomainExpandEnv(t *testing.T) {
+	os.Setenv("CRAWLER_TEST_TOKEN", "secret")
+	defer os.Unsetenv("CRAWLER_TEST_TOKEN")
+
+	domain := Domain{
+		Host:       "github.com",
+		BasicAuth:  []string{"token ${CRAWLER_TEST_TOKEN}", "$CRAWLER_TEST_TOKEN"},
+		RawHeaders: map[string]string{"JOB-TOKEN": "${CRAWLER_TEST_TOKEN}"},
+	}
+	if err := domain.expandEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if domain.BasicAuth[0] != "token secret" || domain.BasicAuth[1] != "$CRAWLER_TEST_TOKEN" || domain.RawHeaders["JOB-TOKEN"] != "secret" {
+		t.Errorf("Unexpected expansion %+v", domain)
+	}
+
+	err := expandDomainsEnv([]Domain{{Host: "gitlab.com", BasicAuth: []string{"${CRAWLER_TEST_UNSET}"}}})
+	if err == nil || !strings.Contains(err.Error(), "CRAWLER_TEST_UNSET") {
+		t.Errorf("Expected an error for the unset variable, got %v", err)
+	}
+}
+
+// TestDomainAuthHeader checks that the Authorization header is one of the basic-auth values, empty if there is none.
+func TestDomainAuthHeader(t *testing.T) {
+	if auth, err := (Domain{Host: "gitea.example.org"}).authHeader(); err != nil || auth != "" {
+		t.Errorf("Expected no Authorization header, got %q (%v)", auth, err)
+	}
+
+	domain := Domain{Host: "gitea.example.org", BasicAuth: []string{"token a", "token b"}}
+	for i := 0; i < 10; i++ {
+		auth, err := domain.authHeader()
+		if err != nil || (auth != "token a" && auth != "token b") {
+			t.Fatalf("Expected one of the basic-auth values, got %q (%v)", auth, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package crawler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+)
+
+// The failure modes of a crawl. The errors returned by the fetch and validate layers wrap their
+// cause with one of them, so that they can be told apart with errors.Is.
+var (
+	// ErrRateLimited is the error of a request rejected by the rate limit of the provider (429, or 403 with
+	// httpclient.ErrRateLimited).
+	ErrRateLimited = errors.New("rate limited")
+	// ErrNotFound is the error of a file that doesn't exist (404).
+	ErrNotFound = errors.New("not found")
+	// ErrForbidden is the error of a request denied by the provider (403), e.g. for a token without permissions.
+	ErrForbidden = errors.New("forbidden")
+	// ErrInvalidPublicCode is the error of a file that is not a valid publiccode.yml.
+	ErrInvalidPublicCode = errors.New("invalid publiccode.yml")
+	// ErrNetwork is the error of a request failed without a response (e.g. a timeout).
+	ErrNetwork = errors.New("network error")
+)
+
+// crawlError is one of the failure modes of a crawl with its cause.
+type crawlError struct {
+	kind  error
+	cause error
+}
+
+// wrapError returns cause wrapped with the failure mode kind.
+func wrapError(kind, cause error) error {
+	return &crawlError{kind: kind, cause: cause}
+}
+
+func (e *crawlError) Error() string {
+	return e.kind.Error() + ": " + e.cause.Error()
+}
+
+// Is makes errors.Is match the failure mode.
+func (e *crawlError) Is(target error) bool {
+	return target == e.kind
+}
+
+// Unwrap returns the cause.
+func (e *crawlError) Unwrap() error {
+	return e.cause
+}
+
+// fetchError returns the error of the fetch with resp and err wrapped with its failure mode, if any.
+// The other errors (e.g. a 5xx response or errCircuitOpen) are returned as they are.
+func fetchError(resp httpclient.HTTPResponse, err error) error {
+	if errors.Is(err, httpclient.ErrBodyTooLarge) || errors.Is(err, errCircuitOpen) || httpclient.IsRedirectFailure(err) {
+		return err
+	}
+
+	cause := err
+	if cause == nil {
+		cause = errors.New(resp.Status.Text)
+	}
+
+	switch resp.Status.Code {
+	case http.StatusOK, http.StatusNotModified:
+		return err
+	case -1:
+		return wrapError(ErrNetwork, cause)
+	case http.StatusTooManyRequests:
+		return wrapError(ErrRateLimited, cause)
+	case http.StatusNotFound:
+		return wrapError(ErrNotFound, cause)
+	case http.StatusForbidden:
+		if errors.Is(err, httpclient.ErrRateLimited) {
+			return wrapError(ErrRateLimited, cause)
+		}
+		return wrapError(ErrForbidden, cause)
+	default:
+		return err
+	}
+}
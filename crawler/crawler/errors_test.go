@@ -0,0 +1,53 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+)
+
+// TestFetchError checks that the fetch errors are wrapped with their failure mode, keeping the cause.
+func TestFetchError(t *testing.T) {
+	response := func(code int) httpclient.HTTPResponse {
+		return httpclient.HTTPResponse{Status: httpclient.ResponseStatus{Text: http.StatusText(code), Code: code}}
+	}
+
+	tests := []struct {
+		resp httpclient.HTTPResponse
+		err  error
+		kind error
+	}{
+		{response(http.StatusOK), nil, nil},
+		{response(http.StatusNotModified), nil, nil},
+		{response(http.StatusNotFound), nil, ErrNotFound},
+		{response(http.StatusForbidden), httpclient.ErrForbidden, ErrForbidden},
+		{response(http.StatusForbidden), httpclient.ErrRateLimited, ErrRateLimited},
+		{response(http.StatusTooManyRequests), errors.New("unexpected status"), ErrRateLimited},
+		{response(-1), context.DeadlineExceeded, ErrNetwork},
+		{response(http.StatusServiceUnavailable), errors.New("unexpected status"), nil},
+		{response(-1), errCircuitOpen, nil},
+	}
+
+	for _, test := range tests {
+		err := fetchError(test.resp, test.err)
+		if test.kind == nil {
+			if err != test.err {
+				t.Errorf("%d: expected %v, got %v", test.resp.Status.Code, test.err, err)
+			}
+			continue
+		}
+		if !errors.Is(err, test.kind) || (test.err != nil && !errors.Is(err, test.err)) {
+			t.Errorf("%d: expected %v wrapping %v, got %v", test.resp.Status.Code, test.kind, test.err, err)
+		}
+	}
+
+	if !errors.Is(ValidationErrors{{Key: "name", Reason: "missing"}}, ErrInvalidPublicCode) {
+		t.Errorf("Expected the validation errors to be an ErrInvalidPublicCode")
+	}
+	if err := checkYAMLResponse(httpclient.HTTPResponse{Body: []byte("<html></html>")}); !errors.Is(err, ErrInvalidPublicCode) {
+		t.Errorf("Expected an ErrInvalidPublicCode, got %v", err)
+	}
+}
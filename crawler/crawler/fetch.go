@@ -0,0 +1,511 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+	"github.com/italia/developers-italia-backend/crawler/metrics"
+	"github.com/spf13/viper"
+)
+
+// Fetcher retrieves the files of the repositories.
+type Fetcher interface {
+	GetURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error)
+	// HeadURL returns the status and headers of url, without body.
+	HeadURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error)
+}
+
+// httpFetcher is the Fetcher that retrieves the files with getURL.
+type httpFetcher struct{}
+
+// GetURL retrieves url with getURL.
+func (httpFetcher) GetURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error) {
+	return getURL(ctx, url, headers)
+}
+
+// HeadURL sends a HEAD request to url with httpclient.HeadURLWithContext, cancelling it after HTTP_TIMEOUT (if set).
+func (httpFetcher) HeadURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error) {
+	if timeout := viper.GetDuration("HTTP_TIMEOUT"); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return httpclient.HeadURLWithContext(ctx, url, headers)
+}
+
+// fetchFile retrieves the repository file from its branch and, if not found there and BRANCH_FALLBACK is set,
+// from the fallback branches in order. It returns the repository with the GitBranch where the file was found.
+func (c *Crawler) fetchFile(ctx context.Context, repository Repository) (Repository, httpclient.HTTPResponse, error) {
+	found, resp, err := c.fetchBranchFile(ctx, repository)
+	if resp.Status.Code != http.StatusNotFound || !branchFallbackEnabled() {
+		return found, resp, err
+	}
+
+	for _, branch := range branchFallbacks() {
+		if branch == repository.GitBranch {
+			continue
+		}
+		rawURL := rawURLForBranch(repository.FileRawURL, repository.GitBranch, branch)
+		if rawURL == "" {
+			break
+		}
+
+		candidate := repository
+		candidate.FileRawURL = rawURL
+		candidate.FileAPIURL = apiURLForBranch(repository.FileAPIURL, branch)
+		candidate.GitBranch = branch
+		candidate, candidateResp, candidateErr := c.fetchBranchFile(ctx, candidate)
+		if candidateResp.Status.Code != http.StatusNotFound {
+			if candidateResp.Status.Code == http.StatusOK {
+				candidate.logger().Infof("file found on the branch %s instead of %s", branch, repository.GitBranch)
+			}
+			return candidate, candidateResp, candidateErr
+		}
+	}
+
+	return found, resp, err
+}
+
+// fetchBranchFile retrieves the repository file trying the candidate file names of the domain, in order, until
+// one is found (or not modified since the last crawl). It returns the repository with the FileRawURL and
+// Filename of the file found, or of the last candidate.
+// With PREFER_VALID_FILENAME all the candidates are fetched, and the first valid one is chosen, see pickValidFile.
+func (c *Crawler) fetchBranchFile(ctx context.Context, repository Repository) (Repository, httpclient.HTTPResponse, error) {
+	// The file name is already known (e.g. listed by the API).
+	if repository.Filename != "" {
+		resp, err := c.fetchURL(ctx, repository)
+		return repository, resp, err
+	}
+
+	rawURL, apiURL := repository.FileRawURL, repository.FileAPIURL
+	var resp httpclient.HTTPResponse
+	var err error
+	var found []fileCandidate
+	for _, filename := range repository.Domain.crawledFilenames() {
+		repository.Filename = filename
+		repository.FileRawURL = rawURLForFilename(rawURL, filename)
+		if apiURL != "" {
+			repository.FileAPIURL = rawURLForFilename(apiURL, filename)
+		}
+
+		resp, err = c.fetchURL(ctx, repository)
+		if resp.Status.Code == http.StatusOK && err == nil && viper.GetBool("PREFER_VALID_FILENAME") {
+			found = append(found, fileCandidate{repository, resp})
+			continue
+		}
+		if resp.Status.Code == http.StatusOK || resp.Status.Code == http.StatusNotModified || errors.Is(err, errCircuitOpen) {
+			break
+		}
+	}
+	if len(found) > 0 {
+		candidate := c.pickValidFile(ctx, found)
+		return candidate.repository, candidate.resp, nil
+	}
+
+	return repository, resp, err
+}
+
+// fileCandidate is a file found in a repository with one of the candidate file names.
+type fileCandidate struct {
+	repository Repository
+	resp       httpclient.HTTPResponse
+}
+
+// pickValidFile returns the first of the candidates that is valid, or the first one if none is.
+// The repositories with more than one candidate are counted in repository_multiple_files.
+func (c *Crawler) pickValidFile(ctx context.Context, candidates []fileCandidate) fileCandidate {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	metrics.GetCounter("repository_multiple_files", c.index).Inc()
+	for _, candidate := range candidates {
+		repository := candidate.repository
+		if errs, _ := c.validateRemoteFile(ctx, candidate.resp.Body, repository.FileRawURL, repository.filename(), repository.Pa); errs == nil {
+			repository.logger().WithField("candidates", len(candidates)).Infof("%s chosen as the first valid file", repository.filename())
+			return candidate
+		}
+	}
+
+	repository := candidates[0].repository
+	repository.logger().WithField("candidates", len(candidates)).Infof("no valid file, %s chosen", repository.filename())
+	return candidates[0]
+}
+
+// fetchURL retrieves the repository file with a conditional request, retrying transient failures (network errors,
+// 5xx, 429 and the rate limit 403 responses, see shouldRetry) up to HTTP_MAX_RETRIES times with exponential backoff and jitter.
+// A 404 is never retried since it means that the file does not exist. A 429 with a Retry-After is retried after
+// the delay it asks, up to maxRateLimitedRetries times besides HTTP_MAX_RETRIES.
+// The errors are wrapped with their failure mode (ErrNotFound, ErrRateLimited, ErrNetwork), if any.
+// If the circuit breaker of the domain is open, errCircuitOpen is returned without sending any request.
+// The urls that returned 404 recently are not requested again, see notFoundCache.
+// With HEAD_PREFLIGHT the file is looked for with a HEAD request first, and not requested if missing.
+func (c *Crawler) fetchURL(ctx context.Context, repository Repository) (httpclient.HTTPResponse, error) {
+	maxRetries := viper.GetInt("HTTP_MAX_RETRIES")
+	baseDelay := viper.GetDuration("HTTP_BASE_DELAY")
+
+	if c.notFound.has(repository.FileRawURL, time.Now()) {
+		resp := notFoundResponse(repository.FileRawURL)
+		return resp, fetchError(resp, nil)
+	}
+
+	breaker := domainBreaker(repository.Domain)
+	if breaker != nil {
+		allowed, probe := breaker.allow(time.Now())
+		if !allowed {
+			return httpclient.HTTPResponse{}, errCircuitOpen
+		}
+		// The probe is released on the paths that record neither a success nor a failure, e.g. when
+		// ctx is done waiting for the rate limit, so that the circuit doesn't stay half-open forever.
+		if probe {
+			defer breaker.release()
+		}
+	}
+
+	headers := conditionalHeaders(repository, c.index)
+
+	if err := waitDomainLimit(ctx, repository.Domain); err != nil {
+		return httpclient.HTTPResponse{}, err
+	}
+	if headPreflightEnabled(repository.Domain) {
+		head, err := c.fetcher.HeadURL(repository.Domain.context(ctx), repository.fileURL(), headers)
+		// Any other outcome (e.g. 405 Method Not Allowed) is left to the GET.
+		if err == nil && head.Status.Code == http.StatusNotFound {
+			if breaker != nil {
+				breaker.success()
+			}
+			c.notFound.add(repository.FileRawURL, time.Now(), notFoundTTL())
+			metrics.GetCounter("repository_head_not_found", c.index).Inc()
+			return head, fetchError(head, nil)
+		}
+		if err := waitDomainLimit(ctx, repository.Domain); err != nil {
+			return httpclient.HTTPResponse{}, err
+		}
+	}
+	resp, err := c.timedGetURL(ctx, repository, headers)
+	for attempt, rateLimited := 0, 0; shouldRetry(resp.Status.Code, err); {
+		// Wait as long as asked by the 429 responses with a Retry-After, the other failures with backoff.
+		delay, ok := retryAfter(resp, time.Now())
+		if ok && rateLimited < maxRateLimitedRetries {
+			rateLimited++
+			metrics.GetCounter("repository_rate_limited", c.index).Inc()
+			repository.logger().Debugf("rate limited, retrying in %v as asked by Retry-After", delay)
+		} else if attempt < maxRetries {
+			delay = backoffDelay(baseDelay, attempt)
+			attempt++
+			repository.logger().WithField("status", resp.Status.Text).Debugf("fetch failed, retrying in %v", delay)
+		} else {
+			break
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			break
+		}
+
+		if err := waitDomainLimit(ctx, repository.Domain); err != nil {
+			break
+		}
+		resp, err = c.timedGetURL(ctx, repository, headers)
+	}
+
+	if breaker != nil {
+		switch {
+		case !shouldRetry(resp.Status.Code, err):
+			breaker.success()
+		// Interrupted while retrying (e.g. shutting down): the failure isn't counted, and the probe is released.
+		case ctx.Err() != nil:
+		case breaker.failure(time.Now()):
+			repository.logger().Warn("too many consecutive failures, circuit breaker open for the domain")
+			metrics.GetCounter("domain_circuit_open", c.index).Inc()
+		}
+	}
+
+	if resp.Status.Code == http.StatusNotFound {
+		c.notFound.add(repository.FileRawURL, time.Now(), notFoundTTL())
+	}
+	if shouldRetry(resp.Status.Code, err) {
+		metrics.GetCounter("repository_fetch_failed", c.index).Inc()
+	} else if waitErr := waitRateLimit(ctx, repository.Domain, resp.Headers); waitErr != nil {
+		return resp, waitErr
+	}
+
+	return resp, fetchError(resp, err)
+}
+
+// headPreflightEnabled returns true if the files of domain are looked for with a HEAD request before the GET
+// (HEAD_PREFLIGHT), unless disabled for the domain because its HEAD responses are not reliable.
+func headPreflightEnabled(domain Domain) bool {
+	return viper.GetBool("HEAD_PREFLIGHT") && !domain.NoHeadPreflight && domain.API() != "codecommit"
+}
+
+// maxRateLimitedRetries is the maximum number of retries of the 429 responses with a Retry-After,
+// like the rate limit retries of httpclient.
+const maxRateLimitedRetries = 8
+
+// retryAfter returns the delay asked by the Retry-After header of a 429 response, in seconds or as an HTTP-date
+// (relative to now), and false if resp is not a 429 response or it has no valid Retry-After.
+func retryAfter(resp httpclient.HTTPResponse, now time.Time) (time.Duration, bool) {
+	if resp.Status.Code != http.StatusTooManyRequests {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Headers.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := date.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// timedGetURL retrieves the repository file, observing the request duration in repository_fetch_duration_seconds.
+// The files read with the contents API of GitHub are returned decoded, like the raw ones.
+func (c *Crawler) timedGetURL(ctx context.Context, repository Repository, headers map[string]string) (httpclient.HTTPResponse, error) {
+	// Don't read the files larger than MAX_FILE_SIZE in memory, and handle the Retry-After in fetchURL.
+	ctx = httpclient.WithMaxBodySize(repository.Domain.context(ctx), maxFileSize())
+	ctx = httpclient.WithoutRetryAfterWait(ctx)
+
+	// Stay within the adaptive concurrency of the domain, if set.
+	concurrency := domainConcurrency(repository.Domain)
+	if concurrency != nil {
+		if err := concurrency.acquire(ctx); err != nil {
+			return httpclient.HTTPResponse{Status: httpclient.ResponseStatus{Text: err.Error(), Code: -1}}, err
+		}
+	}
+	// Count the rate limit responses waited for and retried by httpclient, they are not returned.
+	var rateLimited int32
+	ctx = httpclient.WithRateLimited(ctx, func() {
+		atomic.AddInt32(&rateLimited, 1)
+		metrics.GetCounter("repository_http_rate_limited", c.index).Inc()
+	})
+
+	start := time.Now()
+	var resp httpclient.HTTPResponse
+	var err error
+	// CodeCommit has no raw urls, the files are read with its API.
+	if repository.Domain.API() == "codecommit" {
+		resp, err = getCodeCommitFile(ctx, repository)
+	} else {
+		resp, err = c.fetcher.GetURL(ctx, repository.fileURL(), headers)
+	}
+	metrics.GetHistogramVec("repository_fetch_duration_seconds", c.index, "domain").
+		WithLabelValues(repository.Domain.Host).Observe(time.Since(start).Seconds())
+	if concurrency != nil {
+		limited := atomic.LoadInt32(&rateLimited) > 0 || errors.Is(err, httpclient.ErrRateLimited)
+		limit := concurrency.release(resp.Status.Code, limited)
+		metrics.GetGaugeVec("domain_concurrency", c.index, "domain").WithLabelValues(repository.Domain.Host).Set(float64(limit))
+	}
+
+	if err == nil && resp.Status.Code == http.StatusOK && repository.FileAPIURL != "" {
+		resp, err = githubContentsResponse(resp)
+		if err != nil {
+			repository.logger().WithError(err).Warn("error decoding the contents API response")
+		}
+	}
+
+	return resp, err
+}
+
+// fileURL returns the url the file of the repository is fetched from: FileAPIURL if set, otherwise FileRawURL.
+func (repository Repository) fileURL() string {
+	if repository.FileAPIURL != "" {
+		return repository.FileAPIURL
+	}
+	return repository.FileRawURL
+}
+
+// maxFileSize returns the maximum size in bytes of the fetched files (MAX_FILE_SIZE), by default 512KB.
+func maxFileSize() int64 {
+	size := viper.GetInt64("MAX_FILE_SIZE")
+	if size <= 0 {
+		size = 512 * 1024
+	}
+	return size
+}
+
+// rawURLForFilename returns the raw (or contents API) url of filename, given the url generated for CRAWLED_FILENAME.
+func rawURLForFilename(rawURL, filename string) string {
+	defaultFilename := viper.GetString("CRAWLED_FILENAME")
+	i := strings.LastIndex(rawURL, defaultFilename)
+	if i == -1 || filename == defaultFilename {
+		return rawURL
+	}
+	return rawURL[:i] + filename + rawURL[i+len(defaultFilename):]
+}
+
+// branchFallbackEnabled returns true if the file not found on the branch of the repository is looked for
+// on the fallback branches (BRANCH_FALLBACK).
+func branchFallbackEnabled() bool {
+	return viper.GetBool("BRANCH_FALLBACK")
+}
+
+// branchFallbacks returns the branches where the file is looked for, in order: main, master and the
+// ones in BRANCH_FALLBACKS.
+func branchFallbacks() []string {
+	return append([]string{"main", "master"}, viper.GetStringSlice("BRANCH_FALLBACKS")...)
+}
+
+// rawURLForBranch returns the raw url of the file on the fallback branch, given its raw url on branch,
+// or "" if the branch is not part of the url.
+func rawURLForBranch(rawURL, branch, fallback string) string {
+	if branch == "" {
+		return ""
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	// Azure DevOps has the branch in the query.
+	q := u.Query()
+	if strings.TrimPrefix(q.Get("versionDescriptor.version"), "refs/heads/") == branch {
+		q.Set("versionDescriptor.version", fallback)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	i := strings.LastIndex(u.Path, "/"+branch+"/")
+	if i == -1 {
+		return ""
+	}
+	u.Path = u.Path[:i] + "/" + fallback + "/" + u.Path[i+len(branch)+2:]
+	u.RawPath = ""
+	return u.String()
+}
+
+// apiURLForBranch returns the contents API url of the file on the fallback branch, given its contents API url
+// apiURL (e.g. https://api.github.com/repos/italia/repo/contents/publiccode.yml?ref=master), or "" if apiURL is.
+func apiURLForBranch(apiURL, fallback string) string {
+	if apiURL == "" {
+		return ""
+	}
+
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return ""
+	}
+	q := u.Query()
+	q.Set("ref", fallback)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// searchFilePathEnabled returns true if the file is searched in any directory of the repositories
+// (SEARCH_FILE_PATH) with the code search API of the providers that have one.
+func searchFilePathEnabled() bool {
+	return viper.GetBool("SEARCH_FILE_PATH")
+}
+
+// shallowestPath returns the path nearest to the root of the repository, or "" if paths is empty.
+func shallowestPath(paths []string) string {
+	var shallowest string
+	for _, p := range paths {
+		if shallowest == "" || strings.Count(p, "/") < strings.Count(shallowest, "/") {
+			shallowest = p
+		}
+	}
+	return shallowest
+}
+
+// remoteBaseURL returns the base url of the file at rawURL, used to resolve its relative urls.
+func remoteBaseURL(rawURL, filename string) string {
+	i := strings.LastIndex(rawURL, filename)
+	if i == -1 {
+		return rawURL
+	}
+	return rawURL[:i]
+}
+
+// getURL retrieves url with httpclient.GetURLWithContext, cancelling the request after HTTP_TIMEOUT
+// (if set) or when ctx is done. A timed out request has Code -1, like any other network error.
+func getURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error) {
+	if timeout := viper.GetDuration("HTTP_TIMEOUT"); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return httpclient.GetURLWithContext(ctx, url, headers)
+}
+
+// postJSON POSTs body to url with httpclient.PostJSONWithHeaders, cancelling the request after HTTP_TIMEOUT
+// (if set) or when ctx is done.
+func postJSON(ctx context.Context, url string, headers map[string]string, body []byte) (httpclient.HTTPResponse, error) {
+	if timeout := viper.GetDuration("HTTP_TIMEOUT"); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	return httpclient.PostJSONWithHeaders(ctx, url, headers, body)
+}
+
+// fetchStatusLabel returns the status label of the fetch response resp: its HTTP status code,
+// or "error" if no response was received (e.g. a network error).
+func fetchStatusLabel(resp httpclient.HTTPResponse) string {
+	if resp.Status.Code <= 0 {
+		return "error"
+	}
+	return strconv.Itoa(resp.Status.Code)
+}
+
+// shouldRetry returns true if a fetch that returned status and err is a failure that may succeed if retried:
+// a network error (Code -1, ErrNetwork), a 5xx, a 429 (ErrRateLimited), or a 403 beyond the rate limit
+// (httpclient.ErrRateLimited). The other 403 (ErrForbidden) are terminal, the permissions don't change
+// when retried, like a 404 (ErrNotFound), since the file doesn't exist, and the redirects, that would fail
+// again the same way.
+func shouldRetry(status int, err error) bool {
+	if httpclient.IsRedirectFailure(err) || errors.Is(err, ErrNotFound) {
+		return false
+	}
+	if errors.Is(err, ErrNetwork) || errors.Is(err, ErrRateLimited) || errors.Is(err, httpclient.ErrRateLimited) {
+		return true
+	}
+	if status == http.StatusForbidden {
+		return false
+	}
+
+	// Code is -1 for network errors.
+	return status == -1 ||
+		status == http.StatusTooManyRequests ||
+		status >= http.StatusInternalServerError
+}
+
+// backoffDelay returns the exponential backoff delay for the given attempt, with a random
+// jitter so that concurrent retries are spread over time: [base*2^attempt/2, base*2^attempt).
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	delay := base << uint(attempt)
+	if delay <= 1 {
+		return delay
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// startJitter returns a random delay in [0, window), 0 if window is not positive.
+func startJitter(window time.Duration) time.Duration {
+	if window <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(window)))
+}
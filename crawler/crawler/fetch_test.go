@@ -0,0 +1,574 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+	"github.com/italia/developers-italia-backend/crawler/metrics"
+	publiccode "github.com/italia/publiccode-parser-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// fakeFetcher returns, for each url, the codes of its responses in order (the last one is repeated).
+type fakeFetcher struct {
+	codes map[string][]int
+	calls map[string]int
+	// Headers of the responses that are not 200.
+	headers http.Header
+	// Number of HEAD requests for each url.
+	heads map[string]int
+	// Bodies of the 200 responses for each url, the status text if missing.
+	bodies map[string][]byte
+}
+
+func newFakeFetcher(codes map[string][]int) *fakeFetcher {
+	return &fakeFetcher{codes: codes, calls: make(map[string]int), heads: make(map[string]int)}
+}
+
+// HeadURL returns the code of the next GET response of url.
+func (f *fakeFetcher) HeadURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error) {
+	codes, ok := f.codes[url]
+	if !ok {
+		codes = []int{http.StatusNotFound}
+	}
+	code := codes[len(codes)-1]
+	if n := f.calls[url]; n < len(codes) {
+		code = codes[n]
+	}
+	f.heads[url]++
+
+	return httpclient.HTTPResponse{
+		Status:  httpclient.ResponseStatus{Text: http.StatusText(code), Code: code},
+		Headers: http.Header{},
+	}, nil
+}
+
+func (f *fakeFetcher) GetURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error) {
+	codes, ok := f.codes[url]
+	if !ok {
+		codes = []int{http.StatusNotFound}
+	}
+	code := codes[len(codes)-1]
+	if n := f.calls[url]; n < len(codes) {
+		code = codes[n]
+	}
+	f.calls[url]++
+
+	respHeaders := http.Header{}
+	if code != http.StatusOK && f.headers != nil {
+		respHeaders = f.headers
+	}
+	body := []byte(http.StatusText(code))
+	if b, ok := f.bodies[url]; ok && code == http.StatusOK {
+		body = b
+	}
+	return httpclient.HTTPResponse{
+		Body:    body,
+		Status:  httpclient.ResponseStatus{Text: http.StatusText(code), Code: code},
+		Headers: respHeaders,
+	}, nil
+}
+
+// TestFetchURLRetries checks that the transient failures are retried up to HTTP_MAX_RETRIES times.
+func TestFetchURLRetries(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("HTTP_BASE_DELAY", time.Nanosecond)
+	defer viper.Set("HTTP_BASE_DELAY", nil)
+	defer viper.Set("HTTP_MAX_RETRIES", nil)
+
+	const rawURL = "https://example.org/publiccode.yml"
+	tests := []struct {
+		codes      []int
+		maxRetries int
+		code       int
+		calls      int
+	}{
+		{[]int{http.StatusOK}, 3, http.StatusOK, 1},
+		{[]int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, 3, http.StatusOK, 3},
+		{[]int{http.StatusServiceUnavailable, http.StatusOK}, 0, http.StatusServiceUnavailable, 1},
+		{[]int{http.StatusBadGateway}, 2, http.StatusBadGateway, 3},
+		{[]int{http.StatusNotFound, http.StatusOK}, 3, http.StatusNotFound, 1},
+		{[]int{http.StatusForbidden, http.StatusOK}, 3, http.StatusForbidden, 1},
+	}
+
+	for _, test := range tests {
+		viper.Set("HTTP_MAX_RETRIES", test.maxRetries)
+		fetcher := newFakeFetcher(map[string][]int{rawURL: test.codes})
+		c := Crawler{fetcher: fetcher}
+
+		resp, _ := c.fetchURL(context.Background(), Repository{FileRawURL: rawURL})
+		if resp.Status.Code != test.code || fetcher.calls[rawURL] != test.calls {
+			t.Errorf("Expected %v to return %d after %d calls, got %d after %d calls",
+				test.codes, test.code, test.calls, resp.Status.Code, fetcher.calls[rawURL])
+		}
+	}
+}
+
+// TestFetchURLRetryAfter checks that the 429 responses with a Retry-After are retried even without HTTP_MAX_RETRIES.
+func TestFetchURLRetryAfter(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	const rawURL = "https://example.org/publiccode.yml"
+	fetcher := newFakeFetcher(map[string][]int{rawURL: {http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK}})
+	fetcher.headers = http.Header{"Retry-After": []string{"0"}}
+	c := Crawler{fetcher: fetcher}
+
+	resp, err := c.fetchURL(context.Background(), Repository{FileRawURL: rawURL})
+	if err != nil || resp.Status.Code != http.StatusOK || fetcher.calls[rawURL] != 3 {
+		t.Errorf("Expected 200 after 3 calls, got %d after %d calls (%v)", resp.Status.Code, fetcher.calls[rawURL], err)
+	}
+}
+
+// TestRetryAfter checks the parsing of the Retry-After header, in seconds and as an HTTP-date.
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2019, 10, 21, 7, 28, 0, 0, time.UTC)
+
+	tests := []struct {
+		code  int
+		value string
+		delay time.Duration
+		ok    bool
+	}{
+		{http.StatusTooManyRequests, "120", 2 * time.Minute, true},
+		{http.StatusTooManyRequests, "Mon, 21 Oct 2019 07:28:30 GMT", 30 * time.Second, true},
+		{http.StatusTooManyRequests, "Mon, 21 Oct 2019 07:27:00 GMT", 0, true},
+		{http.StatusTooManyRequests, "soon", 0, false},
+		{http.StatusTooManyRequests, "-1", 0, false},
+		{http.StatusTooManyRequests, "", 0, false},
+		{http.StatusServiceUnavailable, "120", 0, false},
+	}
+
+	for _, test := range tests {
+		resp := httpclient.HTTPResponse{
+			Status:  httpclient.ResponseStatus{Code: test.code},
+			Headers: http.Header{"Retry-After": []string{test.value}},
+		}
+		if delay, ok := retryAfter(resp, now); delay != test.delay || ok != test.ok {
+			t.Errorf("Expected %d %q to wait %v (%t), got %v (%t)", test.code, test.value, test.delay, test.ok, delay, ok)
+		}
+	}
+}
+
+// TestFetchFile checks that the candidate file names are tried in order.
+func TestFetchFile(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("CRAWLED_FILENAME", "publiccode.yml")
+	defer viper.Set("CRAWLED_FILENAME", nil)
+
+	fetcher := newFakeFetcher(map[string][]int{"https://example.org/raw/publiccode.yaml": {http.StatusOK}})
+	c := Crawler{fetcher: fetcher}
+
+	repository := Repository{
+		FileRawURL: "https://example.org/raw/publiccode.yml",
+		Domain:     Domain{Filenames: []string{"publiccode.yml", "publiccode.yaml"}},
+	}
+	repository, resp, err := c.fetchFile(context.Background(), repository)
+	if err != nil || resp.Status.Code != http.StatusOK {
+		t.Fatalf("Expected the file to be found, got %d (%v)", resp.Status.Code, err)
+	}
+	if repository.Filename != "publiccode.yaml" || repository.FileRawURL != "https://example.org/raw/publiccode.yaml" {
+		t.Errorf("Unexpected file %s at %s", repository.Filename, repository.FileRawURL)
+	}
+}
+
+// bodyValidator accepts only the files with the valid body.
+type bodyValidator struct {
+	valid string
+}
+
+func (v bodyValidator) Validate(ctx context.Context, data []byte, remoteBaseURL string) (*publiccode.PublicCode, error) {
+	if string(data) != v.valid {
+		return nil, errors.New("invalid file")
+	}
+	return &publiccode.PublicCode{}, nil
+}
+
+// TestFetchFilePreferValid checks that with PREFER_VALID_FILENAME the first valid of the candidate files is chosen,
+// or the first one found if none is valid.
+func TestFetchFilePreferValid(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("CRAWLED_FILENAME", "publiccode.yml")
+	defer viper.Set("CRAWLED_FILENAME", nil)
+	viper.Set("PREFER_VALID_FILENAME", true)
+	defer viper.Set("PREFER_VALID_FILENAME", nil)
+
+	const yml = "https://example.org/raw/publiccode.yml"
+	const yaml = "https://example.org/raw/publiccode.yaml"
+	tests := []struct {
+		valid    string
+		filename string
+	}{
+		{"second", "publiccode.yaml"},
+		{"first", "publiccode.yml"},
+		{"none", "publiccode.yml"},
+	}
+
+	for _, test := range tests {
+		fetcher := newFakeFetcher(map[string][]int{yml: {http.StatusOK}, yaml: {http.StatusOK}})
+		fetcher.bodies = map[string][]byte{yml: []byte("first"), yaml: []byte("second")}
+		c := Crawler{fetcher: fetcher, validator: bodyValidator{valid: test.valid}}
+
+		repository := Repository{
+			FileRawURL: yml,
+			Domain:     Domain{Filenames: []string{"publiccode.yml", "publiccode.yaml"}},
+		}
+		repository, resp, err := c.fetchFile(context.Background(), repository)
+		if err != nil || resp.Status.Code != http.StatusOK {
+			t.Fatalf("Expected the file to be found, got %d (%v)", resp.Status.Code, err)
+		}
+		if repository.Filename != test.filename || len(fetcher.calls) != 2 {
+			t.Errorf("Expected %s with %s valid, got %s after %d urls", test.filename, test.valid, repository.Filename, len(fetcher.calls))
+		}
+	}
+}
+
+// TestFetchFileBranchFallback checks that the fallback branches are tried, in order, if the file is not found
+// on the branch of the repository.
+func TestFetchFileBranchFallback(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("CRAWLED_FILENAME", "publiccode.yml")
+	defer viper.Set("CRAWLED_FILENAME", nil)
+	viper.Set("BRANCH_FALLBACKS", []string{"develop"})
+	defer viper.Set("BRANCH_FALLBACKS", nil)
+	defer viper.Set("BRANCH_FALLBACK", nil)
+
+	repository := Repository{
+		FileRawURL: "https://example.org/repo/-/raw/trunk/publiccode.yml",
+		GitBranch:  "trunk",
+		Domain:     Domain{Filenames: []string{"publiccode.yml"}},
+	}
+	codes := map[string][]int{"https://example.org/repo/-/raw/develop/publiccode.yml": {http.StatusOK}}
+
+	fetcher := newFakeFetcher(codes)
+	c := Crawler{fetcher: fetcher}
+	if _, resp, _ := c.fetchFile(context.Background(), repository); resp.Status.Code != http.StatusNotFound || len(fetcher.calls) != 1 {
+		t.Errorf("Expected no fallback if BRANCH_FALLBACK is not set, got %d after %d urls", resp.Status.Code, len(fetcher.calls))
+	}
+
+	viper.Set("BRANCH_FALLBACK", true)
+	fetcher = newFakeFetcher(codes)
+	c = Crawler{fetcher: fetcher}
+	found, resp, err := c.fetchFile(context.Background(), repository)
+	if err != nil || resp.Status.Code != http.StatusOK {
+		t.Fatalf("Expected the file to be found, got %d (%v)", resp.Status.Code, err)
+	}
+	if found.GitBranch != "develop" || found.FileRawURL != "https://example.org/repo/-/raw/develop/publiccode.yml" || len(fetcher.calls) != 4 {
+		t.Errorf("Unexpected file at %s on %s after %d urls", found.FileRawURL, found.GitBranch, len(fetcher.calls))
+	}
+}
+
+// TestFetchFileBranchFallbackContentsAPI checks that the files read with the contents API are looked for
+// with the contents API on the fallback branches too, with every candidate file name.
+func TestFetchFileBranchFallbackContentsAPI(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("CRAWLED_FILENAME", "publiccode.yml")
+	defer viper.Set("CRAWLED_FILENAME", nil)
+	viper.Set("BRANCH_FALLBACK", true)
+	defer viper.Set("BRANCH_FALLBACK", nil)
+
+	repository := Repository{
+		FileRawURL: "https://raw.example.org/italia/repo/trunk/publiccode.yml",
+		FileAPIURL: "https://api.example.org/repos/italia/repo/contents/publiccode.yml?ref=trunk",
+		GitBranch:  "trunk",
+		Domain:     Domain{Filenames: []string{"publiccode.yml", "publiccode.yaml"}},
+	}
+
+	fetcher := newFakeFetcher(nil)
+	c := Crawler{fetcher: fetcher}
+	c.fetchFile(context.Background(), repository)
+	for _, u := range []string{
+		"https://api.example.org/repos/italia/repo/contents/publiccode.yml?ref=trunk",
+		"https://api.example.org/repos/italia/repo/contents/publiccode.yaml?ref=trunk",
+		"https://api.example.org/repos/italia/repo/contents/publiccode.yml?ref=main",
+		"https://api.example.org/repos/italia/repo/contents/publiccode.yaml?ref=master",
+	} {
+		if fetcher.calls[u] != 1 {
+			t.Errorf("Expected %s to be fetched, got %v", u, fetcher.calls)
+		}
+	}
+	if len(fetcher.calls) != 6 {
+		t.Errorf("Expected only the contents API urls to be fetched, got %v", fetcher.calls)
+	}
+}
+
+// TestRawURLForBranch checks that the branch in the raw urls is replaced by the fallback one.
+func TestRawURLForBranch(t *testing.T) {
+	urls := []struct {
+		in     string
+		branch string
+		out    string
+	}{
+		{"https://gitlab.com/italia/repo/-/raw/master/publiccode.yml", "master", "https://gitlab.com/italia/repo/-/raw/main/publiccode.yml"},
+		{"https://raw.githubusercontent.com/italia/master/master/docs/publiccode.yml", "master", "https://raw.githubusercontent.com/italia/master/main/docs/publiccode.yml"},
+		{"https://dev.azure.com/o/p/_apis/git/repositories/r/items?path=%2Fpubliccode.yml&versionDescriptor.version=refs%2Fheads%2Fmaster",
+			"master", "https://dev.azure.com/o/p/_apis/git/repositories/r/items?path=%2Fpubliccode.yml&versionDescriptor.version=main"},
+		{"https://example.org/publiccode.yml", "master", ""},
+		{"https://gitlab.com/italia/repo/-/raw/master/publiccode.yml", "", ""},
+	}
+
+	for _, u := range urls {
+		if out := rawURLForBranch(u.in, u.branch, "main"); out != u.out {
+			t.Errorf("Expected %s == %s, got %s", u.in, u.out, out)
+		}
+	}
+}
+
+// TestBackoffDelay checks that the jittered delay stays in [base*2^attempt/2, base*2^attempt).
+func TestBackoffDelay(t *testing.T) {
+	base := 100 * time.Millisecond
+
+	for attempt := 0; attempt < 5; attempt++ {
+		max := base << uint(attempt)
+		for i := 0; i < 20; i++ {
+			if d := backoffDelay(base, attempt); d < max/2 || d >= max {
+				t.Errorf("attempt %d: delay %v out of [%v, %v)", attempt, d, max/2, max)
+			}
+		}
+	}
+
+	if d := backoffDelay(0, 3); d != 0 {
+		t.Errorf("Expected no delay without a base delay, got %v", d)
+	}
+}
+
+// TestStartJitter checks that the start delay stays in [0, window).
+func TestStartJitter(t *testing.T) {
+	window := 100 * time.Millisecond
+	for i := 0; i < 20; i++ {
+		if d := startJitter(window); d < 0 || d >= window {
+			t.Errorf("delay %v out of [0, %v)", d, window)
+		}
+	}
+
+	if d := startJitter(0); d != 0 {
+		t.Errorf("Expected no delay without a window, got %v", d)
+	}
+}
+
+// TestRawURLForFilename checks that the file name in the raw urls is replaced by the candidate one.
+func TestRawURLForFilename(t *testing.T) {
+	viper.Set("CRAWLED_FILENAME", "publiccode.yml")
+	defer viper.Set("CRAWLED_FILENAME", nil)
+
+	urls := []struct {
+		in       string
+		filename string
+		out      string
+	}{
+		{"https://gitlab.com/italia/repo/raw/master/publiccode.yml", "publiccode.yml", "https://gitlab.com/italia/repo/raw/master/publiccode.yml"},
+		{"https://gitlab.com/italia/repo/raw/master/publiccode.yml", "publiccode.yaml", "https://gitlab.com/italia/repo/raw/master/publiccode.yaml"},
+		{"https://dev.azure.com/o/p/_apis/git/repositories/r/items?path=%2Fpubliccode.yml&api-version=6.0", "publiccode.yaml",
+			"https://dev.azure.com/o/p/_apis/git/repositories/r/items?path=%2Fpubliccode.yaml&api-version=6.0"},
+	}
+
+	for _, u := range urls {
+		if out := rawURLForFilename(u.in, u.filename); out != u.out {
+			t.Errorf("Expected %s == %s, got %s", u.in, u.out, out)
+		}
+	}
+
+	if out := remoteBaseURL("https://gitlab.com/italia/repo/raw/master/publiccode.yaml", "publiccode.yaml"); out != "https://gitlab.com/italia/repo/raw/master/" {
+		t.Errorf("Unexpected remote base url %s", out)
+	}
+}
+
+func TestShallowestPath(t *testing.T) {
+	paths := []struct {
+		in  []string
+		out string
+	}{
+		{nil, ""},
+		{[]string{"docs/publiccode.yml"}, "docs/publiccode.yml"},
+		{[]string{"a/b/publiccode.yml", "docs/publiccode.yml", "c/publiccode.yml"}, "docs/publiccode.yml"},
+		{[]string{"docs/publiccode.yml", "publiccode.yml"}, "publiccode.yml"},
+	}
+
+	for _, p := range paths {
+		if out := shallowestPath(p.in); out != p.out {
+			t.Errorf("Expected %v == %s, got %s", p.in, p.out, out)
+		}
+	}
+}
+
+// TestFetchStatusLabel checks the status labels of the fetch responses.
+func TestFetchStatusLabel(t *testing.T) {
+	statuses := []struct {
+		code  int
+		label string
+	}{
+		{http.StatusOK, "200"},
+		{http.StatusNotModified, "304"},
+		{http.StatusTooManyRequests, "429"},
+		{-1, "error"},
+		{0, "error"},
+	}
+
+	for _, s := range statuses {
+		resp := httpclient.HTTPResponse{Status: httpclient.ResponseStatus{Code: s.code}}
+		if label := fetchStatusLabel(resp); label != s.label {
+			t.Errorf("Expected %d == %s, got %s", s.code, s.label, label)
+		}
+	}
+}
+
+// TestFetchURLHeadPreflight checks that with HEAD_PREFLIGHT the missing files are not requested with a GET,
+// unless the HEAD requests are disabled for the domain.
+func TestFetchURLHeadPreflight(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("HEAD_PREFLIGHT", true)
+	defer viper.Set("HEAD_PREFLIGHT", nil)
+
+	const found = "https://example.org/found/publiccode.yml"
+	const missing = "https://example.org/missing/publiccode.yml"
+	tests := []struct {
+		domain Domain
+		url    string
+		code   int
+		heads  int
+		calls  int
+	}{
+		{Domain{Host: "example.org"}, found, http.StatusOK, 1, 1},
+		{Domain{Host: "example.org"}, missing, http.StatusNotFound, 1, 0},
+		{Domain{Host: "example.org", NoHeadPreflight: true}, missing, http.StatusNotFound, 0, 1},
+	}
+
+	for _, test := range tests {
+		fetcher := newFakeFetcher(map[string][]int{found: {http.StatusOK}})
+		c := Crawler{fetcher: fetcher}
+
+		resp, _ := c.fetchURL(context.Background(), Repository{FileRawURL: test.url, Domain: test.domain})
+		if resp.Status.Code != test.code || fetcher.heads[test.url] != test.heads || fetcher.calls[test.url] != test.calls {
+			t.Errorf("Expected %s == %d after %d HEAD and %d GET, got %d after %d HEAD and %d GET", test.url,
+				test.code, test.heads, test.calls, resp.Status.Code, fetcher.heads[test.url], fetcher.calls[test.url])
+		}
+	}
+}
+
+// TestFetchURLForbidden checks that a 403 not due to the rate limit is returned by httpclient with its status,
+// and not retried.
+func TestFetchURLForbidden(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("HTTP_BASE_DELAY", time.Nanosecond)
+	defer viper.Set("HTTP_BASE_DELAY", nil)
+	viper.Set("HTTP_MAX_RETRIES", 3)
+	defer viper.Set("HTTP_MAX_RETRIES", nil)
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	c := Crawler{fetcher: httpFetcher{}}
+	resp, err := c.fetchURL(context.Background(), Repository{FileRawURL: ts.URL + "/publiccode.yml"})
+	if resp.Status.Code != http.StatusForbidden || !errors.Is(err, ErrForbidden) || !errors.Is(err, httpclient.ErrForbidden) {
+		t.Errorf("Expected the 403 with ErrForbidden, got %d (%v)", resp.Status.Code, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("Expected the 403 not retried, got %d requests", n)
+	}
+}
+
+// TestShouldRetry checks the classification of the fetch failures, by status class and failure mode.
+func TestShouldRetry(t *testing.T) {
+	tests := []struct {
+		status int
+		err    error
+		retry  bool
+	}{
+		// Successes.
+		{http.StatusOK, nil, false},
+		{http.StatusNotModified, nil, false},
+		{http.StatusOK, httpclient.ErrBodyTooLarge, false},
+		// Missing files are terminal.
+		{http.StatusNotFound, nil, false},
+		{http.StatusNotFound, wrapError(ErrNotFound, errors.New("not found")), false},
+		{0, wrapError(ErrNotFound, errors.New("cached")), false},
+		{http.StatusGone, nil, false},
+		// Rate limits and permissions.
+		{http.StatusForbidden, nil, false},
+		{http.StatusForbidden, wrapError(ErrForbidden, httpclient.ErrForbidden), false},
+		{http.StatusForbidden, httpclient.ErrRateLimited, true},
+		{http.StatusForbidden, wrapError(ErrRateLimited, httpclient.ErrRateLimited), true},
+		{http.StatusTooManyRequests, nil, true},
+		{http.StatusTooManyRequests, wrapError(ErrRateLimited, errors.New("rate limited")), true},
+		// Transient failures.
+		{http.StatusInternalServerError, nil, true},
+		{http.StatusBadGateway, errors.New("unexpected status"), true},
+		{http.StatusServiceUnavailable, nil, true},
+		{-1, context.DeadlineExceeded, true},
+		{0, wrapError(ErrNetwork, context.DeadlineExceeded), true},
+		// Redirects fail again the same way, and the circuit breaker sends no request.
+		{-1, httpclient.ErrRedirectLoop, false},
+		{-1, httpclient.ErrTooManyRedirects, false},
+		{0, errCircuitOpen, false},
+	}
+
+	for _, test := range tests {
+		if retry := shouldRetry(test.status, test.err); retry != test.retry {
+			t.Errorf("Expected %d (%v) == %t, got %t", test.status, test.err, test.retry, retry)
+		}
+	}
+}
+
+// stuckFetcher answers the requests only when released, even if they are cancelled.
+type stuckFetcher struct {
+	release chan struct{}
+}
+
+func (f stuckFetcher) GetURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error) {
+	<-f.release
+	return httpclient.HTTPResponse{Status: httpclient.ResponseStatus{Text: "404 Not Found", Code: http.StatusNotFound}}, nil
+}
+
+func (f stuckFetcher) HeadURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error) {
+	return f.GetURL(ctx, url, headers)
+}
+
+// TestWaitAbandoned checks that the crawl waits for the repositories abandoned after REPO_TIMEOUT, up to the bound.
+func TestWaitAbandoned(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("REPO_TIMEOUT", "10ms")
+	defer viper.Set("REPO_TIMEOUT", nil)
+
+	fetcher := stuckFetcher{release: make(chan struct{})}
+	c := Crawler{index: "test", ctx: context.Background(), fetcher: fetcher}
+	// The metrics are registered on first use, not while the abandoned repository reads them.
+	metrics.GetCounter("repository_timeout", c.index)
+	c.repositoriesWg.Add(1)
+	c.ProcessRepo(Repository{Name: "italia/repo", Hostname: "github.com", FileRawURL: "https://raw.example.org/italia/repo/publiccode.yml"})
+
+	if c.waitAbandoned(10 * time.Millisecond) {
+		t.Errorf("Expected the abandoned repository still processed")
+	}
+	close(fetcher.release)
+	if !c.waitAbandoned(5 * time.Second) {
+		t.Errorf("Expected the abandoned repository stopped once released")
+	}
+}
@@ -0,0 +1,115 @@
+package crawler
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/italia/developers-italia-backend/crawler/metrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// repoFilter selects the repositories to process by their name.
+type repoFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// newRepoFilter compiles the include and exclude patterns of the repository names.
+func newRepoFilter(include, exclude []string) (repoFilter, error) {
+	var f repoFilter
+	var err error
+
+	f.include, err = compilePatterns(include)
+	if err != nil {
+		return f, err
+	}
+	f.exclude, err = compilePatterns(exclude)
+
+	return f, err
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// allowed returns true if name matches no exclude pattern and, when include patterns are set,
+// at least one of them. Exclude wins over include.
+func (f repoFilter) allowed(name string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipArchivedOrFork returns true if a repository with the given flags is skipped by SKIP_ARCHIVED/SKIP_FORKS.
+func skipArchivedOrFork(archived, fork bool) bool {
+	return (archived && viper.GetBool("SKIP_ARCHIVED")) || (fork && viper.GetBool("SKIP_FORKS"))
+}
+
+// hasRequiredTopics returns true if no REQUIRE_TOPICS are set, or if topics contains at least one of them
+// (case insensitive).
+func hasRequiredTopics(topics []string) bool {
+	required := viper.GetStringSlice("REQUIRE_TOPICS")
+	if len(required) == 0 {
+		return true
+	}
+	for _, r := range required {
+		for _, topic := range topics {
+			if strings.EqualFold(r, topic) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// topicsFiltered returns true if the topics of the repository are known, so that REQUIRE_TOPICS applies:
+// only the GitHub and GitLab APIs return them.
+func topicsFiltered(repository Repository) bool {
+	api := repository.Domain.API()
+	return api == "github" || api == "gitlab"
+}
+
+// skipRepository returns true if the repository must not be processed, incrementing
+// repository_skipped or repository_skipped_archived_fork.
+func (c *Crawler) skipRepository(repository Repository) bool {
+	if skipArchivedOrFork(repository.Archived, repository.Fork) {
+		repository.logger().WithFields(log.Fields{"archived": repository.Archived, "fork": repository.Fork}).
+			Debug("repository skipped by SKIP_ARCHIVED/SKIP_FORKS")
+		metrics.GetCounter("repository_skipped_archived_fork", c.index).Inc()
+		return true
+	}
+
+	if topicsFiltered(repository) && !hasRequiredTopics(repository.Topics) {
+		repository.logger().WithField("topics", repository.Topics).Debug("repository skipped by REQUIRE_TOPICS")
+		metrics.GetCounter("repository_skipped", c.index).Inc()
+		return true
+	}
+
+	if c.currentFilter().allowed(repository.Name) {
+		return false
+	}
+
+	repository.logger().Debug("repository skipped by REPO_INCLUDE/REPO_EXCLUDE")
+	metrics.GetCounter("repository_skipped", c.index).Inc()
+	return true
+}
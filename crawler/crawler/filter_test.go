@@ -0,0 +1,69 @@
+package crawler
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// TestRepoFilter checks the include and exclude patterns, with exclude winning over include.
+func TestRepoFilter(t *testing.T) {
+	f, err := newRepoFilter([]string{"^italia/"}, []string{"-archived$", "^italia/test-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []struct {
+		in  string
+		out bool
+	}{
+		{"italia/developers-italia-backend", true},
+		{"italia/old-archived", false},
+		{"italia/test-repo", false},
+		{"other/developers-italia-backend", false},
+	}
+
+	for _, n := range names {
+		if f.allowed(n.in) != n.out {
+			t.Errorf("Expected %s allowed == %t", n.in, n.out)
+		}
+	}
+
+	if !(repoFilter{}).allowed("any/repo") {
+		t.Error("Expected every repository to be allowed without patterns")
+	}
+	if _, err := newRepoFilter([]string{"("}, nil); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
+
+// TestSkipRepositoryTopics checks that REQUIRE_TOPICS skips only the GitHub and GitLab repositories without them.
+func TestSkipRepositoryTopics(t *testing.T) {
+	viper.Set("REQUIRE_TOPICS", []string{"publiccode", "italia"})
+	defer viper.Set("REQUIRE_TOPICS", nil)
+
+	repositories := []struct {
+		domain string
+		topics []string
+		skip   bool
+	}{
+		{"github.com", []string{"go", "Publiccode"}, false},
+		{"github.com", []string{"go"}, true},
+		{"gitlab.com", nil, true},
+		{"gitlab.com", []string{"italia"}, false},
+		{"bitbucket.org", nil, false},
+	}
+
+	c := Crawler{}
+	for _, r := range repositories {
+		repository := Repository{Name: "italia/repo", Domain: Domain{Host: r.domain}, Topics: r.topics}
+		if skip := c.skipRepository(repository); skip != r.skip {
+			t.Errorf("Expected %s repository with topics %v skipped == %t", r.domain, r.topics, r.skip)
+		}
+	}
+
+	viper.Set("REQUIRE_TOPICS", nil)
+	if !hasRequiredTopics(nil) {
+		t.Error("Expected every repository to be allowed without REQUIRE_TOPICS")
+	}
+}
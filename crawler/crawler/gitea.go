@@ -0,0 +1,282 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// GiteaSearch is the complete result from the Gitea API respose for /repos/search.
+type GiteaSearch struct {
+	OK   bool        `json:"ok"`
+	Data []GiteaRepo `json:"data"`
+}
+
+// GiteaRepo is a complete result from the Gitea API respose for a single repository.
+type GiteaRepo struct {
+	ID    int `json:"id"`
+	Owner struct {
+		ID        int    `json:"id"`
+		Login     string `json:"login"`
+		FullName  string `json:"full_name"`
+		AvatarURL string `json:"avatar_url"`
+	} `json:"owner"`
+	Name            string    `json:"name"`
+	FullName        string    `json:"full_name"`
+	Description     string    `json:"description"`
+	Empty           bool      `json:"empty"`
+	Private         bool      `json:"private"`
+	Fork            bool      `json:"fork"`
+	Mirror          bool      `json:"mirror"`
+	Archived        bool      `json:"archived"`
+	Size            int       `json:"size"`
+	HTMLURL         string    `json:"html_url"`
+	SSHURL          string    `json:"ssh_url"`
+	CloneURL        string    `json:"clone_url"`
+	OriginalURL     string    `json:"original_url"`
+	Website         string    `json:"website"`
+	StarsCount      int       `json:"stars_count"`
+	ForksCount      int       `json:"forks_count"`
+	WatchersCount   int       `json:"watchers_count"`
+	OpenIssuesCount int       `json:"open_issues_count"`
+	DefaultBranch   string    `json:"default_branch"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// RegisterGiteaAPI register the crawler function for Gitea API.
+// It get the list of repositories on "link" url: the repositories of the organization in the whitelist url,
+// or the search of all the repositories of the instance, limited to the organization of the domain (org) if set.
+// If a next page is available return its url.
+// Otherwise returns an empty ("") string.
+func RegisterGiteaAPI() OrganizationHandler {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) (string, error) {
+		// Set Authorization header.
+		headers := make(map[string]string)
+		auth, err := domain.authHeader()
+		if err != nil {
+			return link, err
+		}
+		if auth != "" {
+			headers["Authorization"] = auth
+		}
+
+		// Parse url.
+		u, err := url.Parse(link)
+		if err != nil {
+			return link, err
+		}
+		// Set domain host to new host.
+		domain.Host = u.Hostname()
+
+		// List the repositories of the organization instead of searching them all, if set.
+		if domain.Org != "" && u.Path == "/"+giteaSearchPath {
+			u.Path = path.Join("/api/v1/orgs", domain.Org, "repos")
+			link = u.String()
+		}
+
+		// Get List of repositories.
+		resp, err := getURL(ctx, link, headers)
+		if err != nil {
+			return link, err
+		}
+		if resp.Status.Code != http.StatusOK {
+			log.Warnf("Request returned: %s", string(resp.Body))
+			return "", errors.New("request returned an incorrect http.Status: " + resp.Status.Text)
+		}
+
+		// Fill response as list of values (repositories data).
+		// The organization repositories are a plain list, the search ones are wrapped in data.
+		var results GiteaSearch
+		if strings.HasPrefix(u.Path, "/api/v1/orgs/") {
+			err = json.Unmarshal(resp.Body, &results.Data)
+		} else {
+			err = json.Unmarshal(resp.Body, &results)
+		}
+		if err != nil {
+			return link, err
+		}
+
+		// Add repositories to the channel that will perform the check on everyone.
+		for _, v := range results.Data {
+			err = addGiteaProjectToRepositories(v, domain, pa, headers, repositories)
+			if err != nil {
+				log.Infof("addGiteaProjectToRepositories %v", err)
+			}
+		}
+
+		// Return next url.
+		nextLink := httpclient.HeaderLink(resp.Headers.Get("Link"), "next")
+
+		// if last page for this organization, the nextLink is empty or equal to actual link.
+		if nextLink == "" || nextLink == link {
+			return "", nil
+		}
+
+		return nextLink, nil
+	}
+}
+
+// RegisterSingleGiteaAPI register the crawler function for single repository Gitea API.
+// Return nil if the repository was successfully added to repositories channel.
+// Otherwise return the generated error.
+func RegisterSingleGiteaAPI() SingleRepoHandler {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) error {
+		// Set Authorization header.
+		headers := make(map[string]string)
+		auth, err := domain.authHeader()
+		if err != nil {
+			return err
+		}
+		if auth != "" {
+			headers["Authorization"] = auth
+		}
+
+		// Parse url.
+		u, err := url.Parse(link)
+		if err != nil {
+			return err
+		}
+
+		// Set domain host to new host.
+		domain.Host = u.Hostname()
+
+		u.Path = path.Join("api/v1/repos", u.Path)
+		u.Path = strings.TrimSuffix(u.Path, ".git")
+
+		// Get single Repo.
+		resp, err := getURL(ctx, u.String(), headers)
+		if err != nil {
+			return err
+		}
+		if resp.Status.Code != http.StatusOK {
+			log.Warnf("Request returned: %s", string(resp.Body))
+			return errors.New("request returned an incorrect http.Status: " + resp.Status.Text)
+		}
+
+		var v GiteaRepo
+		err = json.Unmarshal(resp.Body, &v)
+		if err != nil {
+			return err
+		}
+
+		if v.Empty || v.DefaultBranch == "" {
+			return errors.New("repository is empty: " + v.HTMLURL)
+		}
+
+		return addGiteaProjectToRepositories(v, domain, pa, headers, repositories)
+	}
+}
+
+// generateGiteaRawURL returns the file Gitea specific file raw url.
+func generateGiteaRawURL(baseURL, defaultBranch string) (string, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, "raw/branch", defaultBranch, viper.GetString("CRAWLED_FILENAME"))
+
+	return u.String(), err
+}
+
+// addGiteaProjectToRepositories adds the project from api response to repository channel.
+func addGiteaProjectToRepositories(v GiteaRepo, domain Domain, pa PA, headers map[string]string, repositories chan Repository) error {
+	// If the repository was never used, there is no default branch to look into.
+	if v.Empty || v.DefaultBranch == "" {
+		return nil
+	}
+
+	// Join file raw URL string.
+	rawURL, err := generateGiteaRawURL(v.HTMLURL, v.DefaultBranch)
+	if err != nil {
+		return err
+	}
+
+	// Marshal all the repository metadata.
+	metadata, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("gitea metadata: %v", err)
+		return err
+	}
+
+	repositories <- Repository{
+		Name:        v.FullName,
+		Hostname:    domain.Host,
+		FileRawURL:  rawURL,
+		GitCloneURL: v.CloneURL,
+		GitBranch:   v.DefaultBranch,
+		Domain:      domain,
+		Pa:          pa,
+		Headers:     headers,
+		RawHeaders:  domain.RawHeaders,
+		Metadata:    metadata,
+		Archived:    v.Archived,
+		Fork:        v.Fork,
+	}
+
+	return nil
+}
+
+// giteaSearchPath is the path of the repository search API of Gitea.
+const giteaSearchPath = "api/v1/repos/search"
+
+// GenerateGiteaAPIURL returns the api url of given Gitea instance link: the repositories of the organization
+// in its path, or the search of all the repositories of the instance if the path is empty.
+// IN: https://gitea.com/italia
+// OUT:https://gitea.com/api/v1/orgs/italia/repos?limit=50
+// IN: https://gitea.com
+// OUT:https://gitea.com/api/v1/repos/search?limit=50
+func GenerateGiteaAPIURL() GeneratorAPIURL {
+	return func(in string) (out []string, err error) {
+		u, err := url.Parse(in)
+		if err != nil {
+			return []string{in}, err
+		}
+		if org := strings.Split(strings.Trim(u.Path, "/"), "/")[0]; org != "" {
+			u.Path = path.Join("/api/v1/orgs", org, "repos")
+		} else {
+			u.Path = giteaSearchPath
+		}
+		u.RawQuery = url.Values{"limit": []string{"50"}}.Encode()
+
+		out = append(out, u.String())
+		return
+	}
+}
+
+// IsGitea returns "true" if the url can use Gitea API.
+func IsGitea(link string) bool {
+	if len(link) == 0 {
+		log.Errorf("IsGitea: empty link %s.", link)
+		return false
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		log.Errorf("IsGitea: impossible to parse %s.", link)
+		return false
+	}
+	u.Path = "api/v1/version"
+
+	resp, err := getURL(context.Background(), u.String(), nil)
+	if err != nil {
+		log.Debugf("can %s use Gitea API? No.", link)
+		return false
+	}
+	if resp.Status.Code != http.StatusOK {
+		log.Debugf("can %s use Gitea API? No.", link)
+		return false
+	}
+
+	log.Debugf("can %s use Gitea API? Yes.", link)
+	return true
+}
@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GenerateGiteaAPIURL returns the api url of given Gitea instance link: the repositories of the organization
+// in its path, or the search of all the repositories of the instance if the path is empty.
+func TestGenerateGiteaAPIURL(t *testing.T) {
+	// Disablle log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	links := []struct {
+		in  string
+		out string
+	}{
+		{"https://gitea.com/italia", "https://gitea.com/api/v1/orgs/italia/repos?limit=50"},
+		{"https://gitea.com/italia/", "https://gitea.com/api/v1/orgs/italia/repos?limit=50"},
+		{"https://gitea.com", "https://gitea.com/api/v1/repos/search?limit=50"},
+		{"https://gitea.com/", "https://gitea.com/api/v1/repos/search?limit=50"},
+		{":unparsable", ":unparsable"},
+	}
+
+	for _, l := range links {
+		genURL := GenerateGiteaAPIURL()
+		if out, err := genURL(l.in); out[0] != l.out {
+			t.Logf("Expected %s == %s: %v ", out[0], l.out, err)
+			t.Fail()
+		}
+	}
+
+}
+
+// TestGiteaOrgAPI checks that the repositories of the organization in the whitelist url, or of the org of the domain,
+// are listed, following the pages.
+func TestGiteaOrgAPI(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/orgs/italia/repos" {
+			t.Errorf("Unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		page := r.URL.Query().Get("page")
+		if page == "" {
+			w.Header().Set("Link", `<http://`+r.Host+`/api/v1/orgs/italia/repos?limit=50&page=2>; rel="next"`)
+			page = "1"
+		}
+		fmt.Fprintf(w, `[{"full_name": "italia/repo%s", "html_url": "http://%s/italia/repo%s", "default_branch": "main"}]`, page, r.Host, page)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		url string
+		org string
+	}{
+		{server.URL + "/italia", ""},
+		{server.URL, "italia"},
+	}
+
+	for _, test := range tests {
+		links, _ := GenerateGiteaAPIURL()(test.url)
+		handler := RegisterGiteaAPI()
+		repositories := make(chan Repository, 2)
+		link := links[0]
+		for link != "" {
+			next, err := handler(context.Background(), Domain{Host: "gitea.example.org", Org: test.org}, link, repositories, PA{})
+			if err != nil {
+				t.Fatalf("Unexpected error on %s: %v", link, err)
+			}
+			link = next
+		}
+		close(repositories)
+
+		var names []string
+		for repository := range repositories {
+			names = append(names, repository.Name)
+		}
+		if len(names) != 2 || names[0] != "italia/repo1" || names[1] != "italia/repo2" {
+			t.Errorf("%s: unexpected repositories %v", test.url, names)
+		}
+	}
+}
@@ -1,10 +1,11 @@
 package crawler
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"math/rand"
+	"fmt"
 	"net/http"
 	"net/url"
 	"path"
@@ -13,7 +14,6 @@ import (
 
 	"github.com/italia/developers-italia-backend/crawler/httpclient"
 	log "github.com/sirupsen/logrus"
-	"github.com/spf13/viper"
 )
 
 // GithubOrgs is the complete result from the Github API respose for /orgs/<Name>/repos.
@@ -83,6 +83,7 @@ type GithubOrgs []struct {
 	ForksCount       int       `json:"forks_count"`
 	MirrorURL        string    `json:"mirror_url"`
 	Archived         bool      `json:"archived"`
+	Topics           []string  `json:"topics"`
 	OpenIssuesCount  int       `json:"open_issues_count"`
 	License          struct {
 		Key    string `json:"key"`
@@ -168,6 +169,7 @@ type GithubRepo struct {
 	ForksCount       int         `json:"forks_count"`
 	MirrorURL        interface{} `json:"mirror_url"`
 	Archived         bool        `json:"archived"`
+	Topics           []string    `json:"topics"`
 	OpenIssuesCount  int         `json:"open_issues_count"`
 	License          interface{} `json:"license"`
 	Forks            int         `json:"forks"`
@@ -217,12 +219,9 @@ type GithubFiles []struct {
 	} `json:"_links"`
 }
 
+// githubBasicAuth returns the Authorization header with the domain token with the most remaining quota.
 func githubBasicAuth(domain Domain) string {
-	if len(domain.BasicAuth) > 0 {
-		auth := domain.BasicAuth[rand.Intn(len(domain.BasicAuth))]
-		return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
-	}
-	return ""
+	return githubTokens.pick(domain)
 }
 
 // RegisterGithubAPI register the crawler function for Github API.
@@ -230,7 +229,7 @@ func githubBasicAuth(domain Domain) string {
 // If a next page is available return its url.
 // Otherwise returns an empty ("") string.
 func RegisterGithubAPI() OrganizationHandler {
-	return func(domain Domain, link string, repositories chan Repository, pa PA) (string, error) {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) (string, error) {
 		// Set BasicAuth header
 		headers := make(map[string]string)
 		headers["Authorization"] = githubBasicAuth(domain)
@@ -243,8 +242,17 @@ func RegisterGithubAPI() OrganizationHandler {
 		// Set domain host to new host.
 		domain.Host = u.Hostname()
 
+		// In incremental mode list the recently pushed repositories first, to stop at the first one not updated.
+		if !domain.since.IsZero() && u.Query().Get("sort") == "" {
+			q := u.Query()
+			q.Set("sort", "pushed")
+			q.Set("direction", "desc")
+			u.RawQuery = q.Encode()
+			link = u.String()
+		}
+
 		// Get List of repositories.
-		resp, err := httpclient.GetURL(link, headers)
+		resp, err := getURL(ctx, link, headers)
 		if err != nil {
 			return link, err
 		}
@@ -252,6 +260,10 @@ func RegisterGithubAPI() OrganizationHandler {
 			log.Warnf("Request returned: %s", string(resp.Body))
 			return "", errors.New("request returned an incorrect http.Status: " + resp.Status.Text)
 		}
+		headers, err = githubRateLimit(ctx, domain, headers, resp.Headers)
+		if err != nil {
+			return link, err
+		}
 
 		// Fill response as list of values (repositories data).
 		var results GithubOrgs
@@ -262,18 +274,42 @@ func RegisterGithubAPI() OrganizationHandler {
 
 		// Add repositories to the channel that will perform the check on everyone.
 		for _, v := range results {
+			// The following repositories were pushed before the previous crawl too.
+			if !domain.updatedSince(v.PushedAt) {
+				return "", nil
+			}
+
 			// Marshal all the repository metadata.
 			metadata, err := json.Marshal(v)
 			if err != nil {
 				log.Errorf("github metadata: %v", err)
 			}
+
+			// Don't list the files of the repositories that are going to be skipped.
+			if skipArchivedOrFork(v.Archived, v.Fork) || !hasRequiredTopics(v.Topics) {
+				repositories <- Repository{
+					Name:     v.FullName,
+					Hostname: domain.Host,
+					Domain:   domain,
+					Pa:       pa,
+					Archived: v.Archived,
+					Fork:     v.Fork,
+					Topics:   v.Topics,
+				}
+				continue
+			}
+
 			contents := strings.Replace(v.ContentsURL, "{+path}", "", -1)
 			// Get List of files.
-			resp, err := httpclient.GetURL(contents, headers)
+			resp, err := getURL(ctx, contents, headers)
 			if err != nil {
 				log.Errorf("Request returned an error: %v", err)
 				continue
 			}
+			headers, err = githubRateLimit(ctx, domain, headers, resp.Headers)
+			if err != nil {
+				return link, err
+			}
 			if resp.Status.Code != http.StatusOK {
 				log.Infof("Request returned an invalid status code: %d", resp.Status.Code)
 			}
@@ -284,7 +320,16 @@ func RegisterGithubAPI() OrganizationHandler {
 				log.Infof("Repository is empty: %s", link)
 			}
 
-			err = addGithubProjectsToRepositories(files, v.FullName, v.CloneURL, v.DefaultBranch, domain.Host, domain, pa, headers, metadata, repositories)
+			// Search a file with a valid name and a downloadURL, in any directory if SEARCH_FILE_PATH is set.
+			file := files.find(domain.crawledFilenames())
+			if file.DownloadURL == "" && searchFilePathEnabled() {
+				file, headers, err = githubSearchFile(ctx, domain, v.FullName, v.ContentsURL, headers)
+				if err != nil {
+					log.Infof("githubSearchFile %s: %v", v.FullName, err)
+				}
+			}
+
+			err = addGithubProjectsToRepositories(file, v.FullName, v.CloneURL, v.DefaultBranch, domain.Host, v.Archived, v.Fork, v.Topics, domain, pa, headers, metadata, repositories)
 			if err != nil {
 				log.Infof("addGithubProectsToRepositories %v", err)
 			}
@@ -292,7 +337,7 @@ func RegisterGithubAPI() OrganizationHandler {
 		}
 
 		// Return next url.
-		nextLink := httpclient.HeaderLink(resp.Headers.Get("Link"), "next")
+		nextLink := githubNextLink(link, resp.Headers.Get("Link"))
 
 		// if last page for this organization, the nextLink is empty or equal to actual link.
 		if nextLink == "" || nextLink == link {
@@ -303,11 +348,31 @@ func RegisterGithubAPI() OrganizationHandler {
 	}
 }
 
+// githubNextLink returns the url of the next page in the Link header of the response to link, if any.
+// The url is resolved against link, since GitHub Enterprise Server behind a proxy can return it
+// relative to the host (e.g. "/api/v3/organizations/1/repos?page=2") instead of absolute like github.com.
+func githubNextLink(link, header string) string {
+	next := httpclient.HeaderLink(header, "next")
+	if next == "" {
+		return ""
+	}
+
+	base, err := url.Parse(link)
+	if err != nil {
+		return next
+	}
+	u, err := base.Parse(next)
+	if err != nil {
+		return next
+	}
+	return u.String()
+}
+
 // RegisterSingleGithubAPI register the crawler function for single repository Github API.
 // Return nil if the repository was successfully added to repositories channel.
 // Otherwise return the generated error.
 func RegisterSingleGithubAPI() SingleRepoHandler {
-	return func(domain Domain, link string, repositories chan Repository, pa PA) error {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) error {
 		// Set BasicAuth header.
 		headers := make(map[string]string)
 		headers["Authorization"] = githubBasicAuth(domain)
@@ -321,12 +386,10 @@ func RegisterSingleGithubAPI() SingleRepoHandler {
 		// Set domain host to new host.
 		domain.Host = u.Hostname()
 
-		u.Path = path.Join("repos", u.Path)
-		u.Path = strings.Trim(u.Path, "/")
-		u.Host = "api." + u.Host
+		u = githubAPIURL(*u, path.Join("repos", u.Path))
 
 		// Get List of repositories.
-		resp, err := httpclient.GetURL(u.String(), headers)
+		resp, err := getURL(ctx, u.String(), headers)
 		if err != nil {
 			return err
 		}
@@ -334,6 +397,10 @@ func RegisterSingleGithubAPI() SingleRepoHandler {
 			log.Warnf("Request returned: %s", string(resp.Body))
 			return errors.New("request returned an incorrect http.Status: " + resp.Status.Text)
 		}
+		headers, err = githubRateLimit(ctx, domain, headers, resp.Headers)
+		if err != nil {
+			return err
+		}
 
 		var v GithubRepo
 		err = json.Unmarshal(resp.Body, &v)
@@ -350,7 +417,11 @@ func RegisterSingleGithubAPI() SingleRepoHandler {
 		contents := strings.Replace(v.ContentsURL, "{+path}", "", -1)
 
 		// Get List of files.
-		resp, err = httpclient.GetURL(contents, headers)
+		resp, err = getURL(ctx, contents, headers)
+		if err != nil {
+			return err
+		}
+		headers, err = githubRateLimit(ctx, domain, headers, resp.Headers)
 		if err != nil {
 			return err
 		}
@@ -365,83 +436,231 @@ func RegisterSingleGithubAPI() SingleRepoHandler {
 			log.Infof("Repository is empty: %s", link)
 		}
 
-		foundIt := false
-		// Search a file with a valid name and a downloadURL.
-		for _, f := range files {
-			if f.Name == viper.GetString("CRAWLED_FILENAME") && f.DownloadURL != "" {
-				// Add repository to channel.
-				repositories <- Repository{
-					Name:        v.FullName,
-					Hostname:    u.Hostname(),
-					FileRawURL:  f.DownloadURL,
-					GitCloneURL: v.CloneURL,
-					GitBranch:   v.DefaultBranch,
-					Domain:      domain,
-					Pa:          pa,
-					Headers:     headers,
-					Metadata:    metadata,
-				}
-				foundIt = true
+		// Search a file with a valid name and a downloadURL, in any directory if SEARCH_FILE_PATH is set.
+		file := files.find(domain.crawledFilenames())
+		if file.DownloadURL == "" && searchFilePathEnabled() {
+			file, headers, err = githubSearchFile(ctx, domain, v.FullName, v.ContentsURL, headers)
+			if err != nil {
+				return err
 			}
 		}
-		if !foundIt {
-			return errors.New("Repository does not contain " + viper.GetString("CRAWLED_FILENAME"))
+		if file.DownloadURL == "" {
+			return errors.New("Repository does not contain " + strings.Join(domain.crawledFilenames(), " or "))
+		}
+		// Add repository to channel.
+		repositories <- Repository{
+			Name:        v.FullName,
+			Hostname:    u.Hostname(),
+			FileRawURL:  file.DownloadURL,
+			FileAPIURL:  file.apiURL(domain),
+			GitCloneURL: v.CloneURL,
+			GitBranch:   v.DefaultBranch,
+			Domain:      domain,
+			Pa:          pa,
+			Headers:     headers,
+			RawHeaders:  domain.RawHeaders,
+			Metadata:    metadata,
+			Filename:    file.Name,
+			Archived:    v.Archived,
+			Fork:        v.Fork,
+			Topics:      v.Topics,
 		}
 		return nil
 	}
 }
 
 // addGithubProjectsToRepositories adds the projects from api response to repository channel.
-func addGithubProjectsToRepositories(files GithubFiles, fullName, cloneURL, defaultBranch, hostname string,
-	domain Domain, pa PA, headers map[string]string, metadata []byte, repositories chan Repository) error {
-	// Search a file with a valid name and a downloadURL.
-	for _, f := range files {
-		if f.Name == viper.GetString("CRAWLED_FILENAME") && f.DownloadURL != "" {
-			// Add repository to channel.
-			repositories <- Repository{
-				Name:        fullName,
-				Hostname:    hostname,
-				FileRawURL:  f.DownloadURL,
-				GitCloneURL: cloneURL,
-				GitBranch:   defaultBranch,
-				Domain:      domain,
-				Pa:          pa,
-				Headers:     headers,
-				Metadata:    metadata,
-			}
+func addGithubProjectsToRepositories(file githubFile, fullName, cloneURL, defaultBranch, hostname string, archived, fork bool,
+	topics []string, domain Domain, pa PA, headers map[string]string, metadata []byte, repositories chan Repository) error {
+	if file.DownloadURL != "" {
+		// Add repository to channel.
+		repositories <- Repository{
+			Name:        fullName,
+			Hostname:    hostname,
+			FileRawURL:  file.DownloadURL,
+			FileAPIURL:  file.apiURL(domain),
+			GitCloneURL: cloneURL,
+			GitBranch:   defaultBranch,
+			Domain:      domain,
+			Pa:          pa,
+			Headers:     headers,
+			RawHeaders:  domain.RawHeaders,
+			Metadata:    metadata,
+			Filename:    file.Name,
+			Archived:    archived,
+			Fork:        fork,
+			Topics:      topics,
 		}
 	}
 
 	return nil
 }
 
+// githubFile is a file found in a repository.
+type githubFile struct {
+	Name        string
+	DownloadURL string
+	// Url of the file in the contents API.
+	URL string
+}
+
+// apiURL returns the url the file is fetched from instead of its download url: its contents API url
+// if domain is set to read the files with the contents API (contents-api), otherwise "".
+func (file githubFile) apiURL(domain Domain) string {
+	if !domain.ContentsAPI {
+		return ""
+	}
+	return file.URL
+}
+
+// find returns the first of filenames in files, or an empty githubFile if none is there.
+func (files GithubFiles) find(filenames []string) githubFile {
+	for _, filename := range filenames {
+		for _, f := range files {
+			if f.Name == filename && f.DownloadURL != "" {
+				return githubFile{Name: f.Name, DownloadURL: f.DownloadURL, URL: f.URL}
+			}
+		}
+	}
+	return githubFile{}
+}
+
+// githubContents is the response of the contents API for a single file.
+type githubContents struct {
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`
+}
+
+// githubContentsResponse returns the contents API response resp of a file as if it was the raw file:
+// with the base64 content decoded as body, and without the JSON Content-Type.
+func githubContentsResponse(resp httpclient.HTTPResponse) (httpclient.HTTPResponse, error) {
+	var contents githubContents
+	if err := json.Unmarshal(resp.Body, &contents); err != nil {
+		return resp, err
+	}
+	if contents.Encoding != "base64" {
+		return resp, fmt.Errorf("unsupported encoding of the contents: %q", contents.Encoding)
+	}
+	// The content is split in lines.
+	body, err := base64.StdEncoding.DecodeString(strings.Replace(contents.Content, "\n", "", -1))
+	if err != nil {
+		return resp, err
+	}
+
+	resp.Body = body
+	resp.Headers = resp.Headers.Clone()
+	resp.Headers.Del("Content-Type")
+	resp.Headers.Del("Content-Length")
+	return resp, nil
+}
+
+// GithubCodeSearch is the result from the Github API response for /search/code.
+type GithubCodeSearch struct {
+	TotalCount int `json:"total_count"`
+	Items      []struct {
+		Name string `json:"name"`
+		Path string `json:"path"`
+		URL  string `json:"url"`
+	} `json:"items"`
+}
+
+// githubSearchFile looks for the first of the domain file names in any directory of the repository fullName
+// with the code search API, and returns the one nearest to the root.
+func githubSearchFile(ctx context.Context, domain Domain, fullName, contentsURL string, headers map[string]string) (githubFile, map[string]string, error) {
+	// The search API is on the same host of the contents API.
+	u, err := url.Parse(strings.Replace(contentsURL, "{+path}", "", -1))
+	if err != nil {
+		return githubFile{}, headers, err
+	}
+
+	for _, filename := range domain.crawledFilenames() {
+		u.Path = "/search/code"
+		u.RawQuery = url.Values{"q": []string{"filename:" + filename + " repo:" + fullName}}.Encode()
+
+		resp, err := getURL(ctx, u.String(), headers)
+		if err != nil {
+			return githubFile{}, headers, err
+		}
+		headers, err = githubRateLimit(ctx, domain, headers, resp.Headers)
+		if err != nil {
+			return githubFile{}, headers, err
+		}
+		if resp.Status.Code != http.StatusOK {
+			return githubFile{}, headers, errors.New("request returned an incorrect http.Status: " + resp.Status.Text)
+		}
+
+		var result GithubCodeSearch
+		err = json.Unmarshal(resp.Body, &result)
+		if err != nil {
+			return githubFile{}, headers, err
+		}
+
+		var paths []string
+		for _, item := range result.Items {
+			if item.Name == filename {
+				paths = append(paths, item.Path)
+			}
+		}
+		filePath := shallowestPath(paths)
+		if filePath == "" {
+			continue
+		}
+
+		// Get the download url of the file.
+		resp, err = getURL(ctx, strings.Replace(contentsURL, "{+path}", filePath, -1), headers)
+		if err != nil {
+			return githubFile{}, headers, err
+		}
+		if resp.Status.Code != http.StatusOK {
+			return githubFile{}, headers, errors.New("request returned an incorrect http.Status: " + resp.Status.Text)
+		}
+		var file struct {
+			DownloadURL string `json:"download_url"`
+			URL         string `json:"url"`
+		}
+		err = json.Unmarshal(resp.Body, &file)
+		if err != nil {
+			return githubFile{}, headers, err
+		}
+
+		return githubFile{Name: filename, DownloadURL: file.DownloadURL, URL: file.URL}, headers, nil
+	}
+
+	return githubFile{}, headers, nil
+}
+
 // GenerateGithubAPIURL returns the api url of given Gitlab organization link.
 // IN: https://github.com/italia
 // OUT:https://api.github.com/orgs/italia/repos,https://api.github.com/users/italia/repos
+// IN: https://ghe.example.org/italia
+// OUT:https://ghe.example.org/api/v3/orgs/italia/repos,https://ghe.example.org/api/v3/users/italia/repos
 func GenerateGithubAPIURL() GeneratorAPIURL {
 	return func(in string) (out []string, err error) {
 		u, err := url.Parse(in)
 		if err != nil {
 			return []string{in}, err
 		}
-		u.Path = path.Join("orgs", u.Path, "repos")
-		u.Path = strings.Trim(u.Path, "/")
-		u.Host = "api." + u.Host
-		out = append(out, u.String())
-
-		u2, err := url.Parse(in)
-		if err != nil {
-			return []string{in}, err
-		}
-		u2.Path = path.Join("users", u2.Path, "repos")
-		u2.Path = strings.Trim(u2.Path, "/")
-		u2.Host = "api." + u2.Host
-		out = append(out, u2.String())
+		out = append(out, githubAPIURL(*u, path.Join("orgs", u.Path, "repos")).String())
+		out = append(out, githubAPIURL(*u, path.Join("users", u.Path, "repos")).String())
 
 		return
 	}
 }
 
+// githubAPIURL returns the url of the API endpoint p (e.g. "orgs/italia/repos") of the GitHub instance of u:
+// on api.github.com for github.com, under /api/v3 for GitHub Enterprise Server. The file raw urls don't need
+// the same treatment, since they are the download urls returned by the API.
+func githubAPIURL(u url.URL, p string) *url.URL {
+	u.RawPath = ""
+	if u.Hostname() == "github.com" {
+		u.Host = "api." + u.Host
+		u.Path = strings.Trim(p, "/")
+	} else {
+		u.Path = path.Join("/api/v3", p)
+	}
+	return &u
+}
+
 // IsGithub returns "true" if the url can use Github API.
 func IsGithub(link string) bool {
 	if len(link) == 0 {
@@ -454,10 +673,9 @@ func IsGithub(link string) bool {
 		log.Errorf("IsGithub: impossible to parse %s.", link)
 		return false
 	}
-	u.Path = "rate_limit"
-	u.Host = "api." + u.Host
+	u = githubAPIURL(*u, "rate_limit")
 
-	resp, err := httpclient.GetURL(u.String(), nil)
+	resp, err := getURL(context.Background(), u.String(), nil)
 	if err != nil {
 		log.Debugf("can %s use Github API? No.", link)
 		return false
@@ -0,0 +1,137 @@
+package crawler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/italia/developers-italia-backend/crawler/metrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// tokenQuota is the last known rate limit quota of a token.
+type tokenQuota struct {
+	remaining int
+	reset     time.Time
+}
+
+// githubTokenPool keeps the quota of the GitHub tokens, by Authorization header value.
+type githubTokenPool struct {
+	sync.Mutex
+	quotas map[string]tokenQuota
+}
+
+var githubTokens = githubTokenPool{quotas: make(map[string]tokenQuota)}
+
+// authSchemes are the prefixes of the basic-auth entries that are already an Authorization header value.
+var authSchemes = []string{"token ", "Bearer ", "Basic "}
+
+// githubAuthHeader returns the Authorization header for a basic-auth entry of the domain: the entry as is
+// if it starts with a scheme (e.g. "token <personal-access-token>"), otherwise the Basic encoding of
+// the "user:token" entry.
+func githubAuthHeader(auth string) string {
+	for _, scheme := range authSchemes {
+		if strings.HasPrefix(auth, scheme) {
+			return auth
+		}
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(auth))
+}
+
+// pick returns the Authorization header of the domain token with the most remaining quota.
+// The tokens with an unknown quota are preferred, the ties are broken randomly.
+func (p *githubTokenPool) pick(domain Domain) string {
+	if len(domain.BasicAuth) == 0 {
+		return ""
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	best := ""
+	bestRemaining := -1
+	offset := rand.Intn(len(domain.BasicAuth))
+	for i := range domain.BasicAuth {
+		header := githubAuthHeader(domain.BasicAuth[(i+offset)%len(domain.BasicAuth)])
+		remaining := p.remaining(header)
+		if remaining > bestRemaining {
+			best, bestRemaining = header, remaining
+		}
+	}
+
+	return best
+}
+
+// quota returns the known remaining quota of the token in header.
+func (p *githubTokenPool) quota(header string) int {
+	p.Lock()
+	defer p.Unlock()
+	return p.remaining(header)
+}
+
+// remaining returns the known remaining quota of header, a large number if unknown or reset.
+// It must be called with the lock held.
+func (p *githubTokenPool) remaining(header string) int {
+	quota, ok := p.quotas[header]
+	if !ok || time.Now().After(quota.reset) {
+		return int(^uint(0) >> 1)
+	}
+	return quota.remaining
+}
+
+// update records the quota of the token in header from the rate limit headers of a response.
+func (p *githubTokenPool) update(header string, respHeaders http.Header) {
+	remaining, err := strconv.Atoi(respHeaders.Get(headerRateRemaining))
+	if err != nil || header == "" {
+		return
+	}
+	reset, _ := strconv.ParseInt(respHeaders.Get(headerRateReset), 10, 64)
+
+	p.Lock()
+	p.quotas[header] = tokenQuota{remaining: remaining, reset: time.Unix(reset, 0)}
+	p.Unlock()
+
+	metrics.GetGaugeVec("github_token_remaining", viper.GetString("ELASTIC_PUBLICCODE_INDEX"), "token").
+		WithLabelValues(tokenID(header)).Set(float64(remaining))
+}
+
+// tokenID returns a non reversible identifier of the token in header, used as metric label.
+func tokenID(header string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(header)))[:8]
+}
+
+// githubRateLimit records the quota of the token used for a response and, when it drops to
+// RATELIMIT_THRESHOLD or below, returns a copy of headers with the token with the most remaining
+// quota. It waits for the rate limit reset (see waitRateLimit) only when all the tokens are exhausted.
+// headers is never modified, since it is shared with the repositories already sent to the channel.
+func githubRateLimit(ctx context.Context, domain Domain, headers map[string]string, respHeaders http.Header) (map[string]string, error) {
+	current := headers["Authorization"]
+	githubTokens.update(current, respHeaders)
+
+	remaining, err := strconv.Atoi(respHeaders.Get(headerRateRemaining))
+	if err != nil || remaining > viper.GetInt("RATELIMIT_THRESHOLD") {
+		return headers, waitRateLimit(ctx, domain, respHeaders)
+	}
+
+	next := githubTokens.pick(domain)
+	if next == current || githubTokens.quota(next) <= viper.GetInt("RATELIMIT_THRESHOLD") {
+		return headers, waitRateLimit(ctx, domain, respHeaders)
+	}
+
+	log.Infof("%s: token %s rate limited, switching to token %s", domain.Host, tokenID(current), tokenID(next))
+	rotated := make(map[string]string, len(headers))
+	for k, v := range headers {
+		rotated[k] = v
+	}
+	rotated["Authorization"] = next
+
+	return rotated, nil
+}
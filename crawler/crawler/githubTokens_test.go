@@ -0,0 +1,138 @@
+package crawler
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// TestGithubRateLimitRotation checks that a rate limited token is replaced by one with quota left.
+func TestGithubRateLimitRotation(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	viper.Set("RATELIMIT_THRESHOLD", 10)
+	defer viper.Set("RATELIMIT_THRESHOLD", nil)
+	githubTokens = githubTokenPool{quotas: make(map[string]tokenQuota)}
+
+	domain := Domain{Host: "github.com", BasicAuth: []string{"user:token1", "user:token2"}}
+	first, second := githubAuthHeader("user:token1"), githubAuthHeader("user:token2")
+	reset := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+
+	// The second token has some quota left.
+	githubTokens.update(second, rateLimitHeaders("4000", reset))
+
+	headers := map[string]string{"Authorization": first}
+	rotated, err := githubRateLimit(context.Background(), domain, headers, rateLimitHeaders("2", reset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated["Authorization"] != second {
+		t.Errorf("Expected the token to be rotated to %s, got %s", tokenID(second), tokenID(rotated["Authorization"]))
+	}
+	if headers["Authorization"] != first {
+		t.Error("Expected the original headers to be unchanged")
+	}
+
+	// Above the threshold the token is kept.
+	kept, err := githubRateLimit(context.Background(), domain, rotated, rateLimitHeaders("3999", reset))
+	if err != nil || kept["Authorization"] != second {
+		t.Errorf("Expected the token %s to be kept, got %s (%v)", tokenID(second), tokenID(kept["Authorization"]), err)
+	}
+}
+
+// rateLimitHeaders returns the response headers with the given rate limit.
+func rateLimitHeaders(remaining, reset string) http.Header {
+	h := http.Header{}
+	h.Set(headerRateRemaining, remaining)
+	h.Set(headerRateReset, reset)
+	return h
+}
+
+// TestGithubAuthHeaderExample checks the Authorization headers of the github.com and GitHub Enterprise
+// entries of domains.yml.example.
+func TestGithubAuthHeaderExample(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	os.Setenv("GITHUB_TOKEN", "secret")
+	defer os.Unsetenv("GITHUB_TOKEN")
+
+	data, err := ioutil.ReadFile("../domains.yml.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enable the example credentials and contents API, commented out.
+	lines := strings.Split(string(data), "\n")
+	for i, inAuth := 0, false; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "#contents-api: true" {
+			lines[i] = strings.Replace(lines[i], "#", "", 1)
+			continue
+		}
+		if strings.TrimSpace(lines[i]) == "#basic-auth:" || (inAuth && strings.HasPrefix(lines[i], "  #  - ")) {
+			inAuth = true
+			lines[i] = strings.Replace(lines[i], "#", "", 1)
+			continue
+		}
+		inAuth = false
+	}
+
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "domains.yml")
+	if err := ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	domains, err := ReadAndParseDomains(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expandDomainsEnv(domains); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := map[string]string{"github.com": "token secret", "ghe.example.org": "token <personal-access-token>"}
+	for _, domain := range domains {
+		expected, ok := headers[domain.Host]
+		if !ok {
+			continue
+		}
+		delete(headers, domain.Host)
+		if header := githubBasicAuth(domain); header != expected {
+			t.Errorf("Expected the header %q of %s in the example, got %q", expected, domain.Host, header)
+		}
+		if domain.Host == "ghe.example.org" && !domain.ContentsAPI {
+			t.Errorf("Expected the contents API enabled for %s in the example", domain.Host)
+		}
+	}
+	if len(headers) > 0 {
+		t.Errorf("Expected %v in the example", headers)
+	}
+}
+
+func TestGithubAuthHeader(t *testing.T) {
+	tests := []struct {
+		auth   string
+		header string
+	}{
+		{"token abc", "token abc"},
+		{"Bearer abc", "Bearer abc"},
+		{"Basic dXNlcjphYmM=", "Basic dXNlcjphYmM="},
+		{"user:abc", "Basic dXNlcjphYmM="},
+	}
+	for _, test := range tests {
+		if header := githubAuthHeader(test.auth); header != test.header {
+			t.Errorf("Expected %q for %q, got %q", test.header, test.auth, header)
+		}
+	}
+}
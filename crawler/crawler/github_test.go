@@ -1,9 +1,14 @@
 package crawler
 
 import (
+	"context"
+	"encoding/base64"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -46,6 +51,7 @@ func TestGenerateGithubAPIURL(t *testing.T) {
 		out string
 	}{
 		{"https://github.com/italia", "https://api.github.com/orgs/italia/repos"},
+		{"https://ghe.example.org/italia", "https://ghe.example.org/api/v3/orgs/italia/repos"},
 		{":unparsable", ":unparsable"},
 	}
 
@@ -58,3 +64,67 @@ func TestGenerateGithubAPIURL(t *testing.T) {
 	}
 
 }
+
+// TestGithubEnterprisePagination checks that the pages of an organization on GitHub Enterprise Server are followed
+// through the Link header, absolute or relative to the host.
+func TestGithubEnterprisePagination(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.RequestURI() {
+		case "/api/v3/orgs/italia/repos":
+			w.Header().Set("Link", `<http://`+r.Host+`/api/v3/organizations/1/repos?page=2>; rel="next", <http://`+r.Host+`/api/v3/organizations/1/repos?page=3>; rel="last"`)
+		case "/api/v3/organizations/1/repos?page=2":
+			w.Header().Set("Link", `</api/v3/organizations/1/repos?page=3>; rel="next", </api/v3/organizations/1/repos?page=1>; rel="first"`)
+		case "/api/v3/organizations/1/repos?page=3":
+			w.Header().Set("Link", `</api/v3/organizations/1/repos?page=1>; rel="first"`)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	handler := RegisterGithubAPI()
+	repositories := make(chan Repository)
+	link := server.URL + "/api/v3/orgs/italia/repos"
+	var pages int
+	for link != "" {
+		next, err := handler(context.Background(), Domain{Host: "ghe.example.org"}, link, repositories, PA{})
+		if err != nil {
+			t.Fatalf("Unexpected error on %s: %v", link, err)
+		}
+		pages++
+		link = next
+	}
+	if pages != 3 {
+		t.Errorf("Expected 3 pages, got %d", pages)
+	}
+}
+
+// TestGithubContentsResponse checks that the files read with the contents API are decoded like the raw ones.
+func TestGithubContentsResponse(t *testing.T) {
+	content := base64.StdEncoding.EncodeToString([]byte("publiccodeYmlVersion: \"0.2\"\nname: Medusa\n"))
+	resp := httpclient.HTTPResponse{
+		Body:    []byte(`{"encoding": "base64", "content": "` + content[:10] + `\n` + content[10:] + `\n"}`),
+		Status:  httpclient.ResponseStatus{Code: http.StatusOK},
+		Headers: http.Header{"Content-Type": []string{"application/json; charset=utf-8"}, "Etag": []string{`"v1"`}},
+	}
+
+	decoded, err := githubContentsResponse(resp)
+	if err != nil || string(decoded.Body) != "publiccodeYmlVersion: \"0.2\"\nname: Medusa\n" {
+		t.Fatalf("Unexpected body %q (%v)", decoded.Body, err)
+	}
+	if decoded.Headers.Get("Content-Type") != "" || decoded.Headers.Get("ETag") != `"v1"` || resp.Headers.Get("Content-Type") == "" {
+		t.Errorf("Unexpected headers %v", decoded.Headers)
+	}
+	if err := checkYAMLResponse(decoded); err != nil {
+		t.Errorf("Expected a YAML response, got %v", err)
+	}
+
+	if _, err := githubContentsResponse(httpclient.HTTPResponse{Body: []byte(`{"encoding": "none"}`)}); err == nil {
+		t.Errorf("Expected an error for the unsupported encodings")
+	}
+}
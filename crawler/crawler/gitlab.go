@@ -1,11 +1,13 @@
 package crawler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -46,6 +48,7 @@ type GitlabRepo struct {
 	CreatedAt         time.Time     `json:"created_at"`
 	DefaultBranch     string        `json:"default_branch"`
 	TagList           []interface{} `json:"tag_list"`
+	Topics            []string      `json:"topics"`
 	SSHURLToRepo      string        `json:"ssh_url_to_repo"`
 	HTTPURLToRepo     string        `json:"http_url_to_repo"`
 	WebURL            string        `json:"web_url"`
@@ -53,6 +56,8 @@ type GitlabRepo struct {
 	StarCount         int           `json:"star_count"`
 	ForksCount        int           `json:"forks_count"`
 	LastActivityAt    time.Time     `json:"last_activity_at"`
+	Archived          bool          `json:"archived"`
+	ForkedFromProject *GitlabRepo   `json:"forked_from_project,omitempty"`
 }
 
 // GitlabProject is a software project hosted on Gitlab.
@@ -66,6 +71,7 @@ type GitlabProject struct {
 	CreatedAt         time.Time     `json:"created_at"`
 	DefaultBranch     string        `json:"default_branch"`
 	TagList           []interface{} `json:"tag_list"`
+	Topics            []string      `json:"topics"`
 	SSHURLToRepo      string        `json:"ssh_url_to_repo"`
 	HTTPURLToRepo     string        `json:"http_url_to_repo"`
 	WebURL            string        `json:"web_url"`
@@ -113,6 +119,7 @@ type GitlabProject struct {
 	PrintingMergeRequestLinkEnabled           bool          `json:"printing_merge_request_link_enabled"`
 	MergeMethod                               string        `json:"merge_method"`
 	ApprovalsBeforeMerge                      int           `json:"approvals_before_merge"`
+	ForkedFromProject                         *GitlabRepo   `json:"forked_from_project,omitempty"`
 }
 
 // GitlabSharedProject is a software project hosted on Gitlab, owned by a group and shared with someone.
@@ -126,6 +133,7 @@ type GitlabSharedProject struct {
 	CreatedAt         time.Time     `json:"created_at"`
 	DefaultBranch     string        `json:"default_branch"`
 	TagList           []interface{} `json:"tag_list"`
+	Topics            []string      `json:"topics"`
 	SSHURLToRepo      string        `json:"ssh_url_to_repo"`
 	HTTPURLToRepo     string        `json:"http_url_to_repo"`
 	WebURL            string        `json:"web_url"`
@@ -162,7 +170,7 @@ type GitlabSharedProject struct {
 		FullPath string      `json:"full_path"`
 		ParentID interface{} `json:"parent_id"`
 	} `json:"namespace"`
-	ForkedFromProject GitlabRepo  `json:"forked_from_project,omitempty"`
+	ForkedFromProject *GitlabRepo `json:"forked_from_project,omitempty"`
 	ImportStatus      string      `json:"import_status"`
 	OpenIssuesCount   int         `json:"open_issues_count,omitempty"`
 	PublicJobs        bool        `json:"public_jobs"`
@@ -190,7 +198,7 @@ type GitlabSharedProject struct {
 
 // RegisterGitlabAPI register the crawler function for Gitlab API.
 func RegisterGitlabAPI() OrganizationHandler {
-	return func(domain Domain, link string, repositories chan Repository, pa PA) (string, error) {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) (string, error) {
 		log.Debugf("RegisterGitlabAPI: %s ", link)
 
 		// Set BasicAuth header.
@@ -212,7 +220,7 @@ func RegisterGitlabAPI() OrganizationHandler {
 		domain.Host = u.Hostname()
 
 		// Get List of repositories.
-		resp, err := httpclient.GetURL(link, headers)
+		resp, err := getURL(ctx, link, headers)
 		if err != nil {
 			return link, err
 		}
@@ -229,34 +237,30 @@ func RegisterGitlabAPI() OrganizationHandler {
 		}
 
 		// Add repositories to the channel that will perform the check on every project.
-		err = addGitlabProjectsToRepositories(results.Projects, domain, pa, headers, repositories)
+		err = addGitlabProjectsToRepositories(ctx, results.Projects, domain, pa, headers, repositories)
 		if err != nil {
 			return link, err
 		}
 		// Add repositories to the channel that will perform the check on every sharedd project.
-		err = addGitlabSharedProjectsToRepositories(results.SharedProjects, domain, pa, headers, repositories)
+		err = addGitlabSharedProjectsToRepositories(ctx, results.SharedProjects, domain, pa, headers, repositories)
 		if err != nil {
 			return link, err
 		}
 
-		// if last page for this organization, the Link is empty.
-		if len(resp.Headers.Get("Link")) == 0 {
-			return "", nil
-		}
-
-		// Return next url.
+		// Return next url, from the Link header or, if missing (e.g. behind some proxies), X-Next-Page.
 		nextLink := httpclient.HeaderLink(resp.Headers.Get("Link"), "next")
 		if nextLink == "" {
-			return "", nil
+			nextLink = gitlabNextPageURL(u, resp.Headers.Get("X-Next-Page"))
 		}
 
+		// if last page for this organization, nextLink is empty.
 		return nextLink, nil
 	}
 }
 
 // RegisterSingleGitlabAPI register the crawler function for single Bitbucket API.
 func RegisterSingleGitlabAPI() SingleRepoHandler {
-	return func(domain Domain, link string, repositories chan Repository, pa PA) error {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) error {
 		// Set BasicAuth header
 		headers := make(map[string]string)
 		if domain.BasicAuth != nil {
@@ -279,10 +283,10 @@ func RegisterSingleGitlabAPI() SingleRepoHandler {
 
 		// Dirty concatenation. With the normal URL String() the escaped characters are escaped two times.
 		repoString := strings.Trim(u.Path, "/")
-		fullURL := "https://" + u.Hostname() + "/api/v4/projects/" + url.QueryEscape(repoString)
+		fullURL := u.Scheme + "://" + u.Host + "/api/v4/projects/" + url.QueryEscape(repoString)
 
 		// Get single Repo
-		resp, err := httpclient.GetURL(fullURL, headers)
+		resp, err := getURL(ctx, fullURL, headers)
 		if err != nil {
 			return err
 		}
@@ -299,7 +303,7 @@ func RegisterSingleGitlabAPI() SingleRepoHandler {
 		}
 
 		// Join file raw URL string.
-		fileRawURL, err := generateGitlabRawURL(result.WebURL, result.DefaultBranch)
+		filename, fileRawURL, err := gitlabFileRawURL(ctx, domain, result.ID, result.WebURL, result.DefaultBranch, headers)
 		if err != nil {
 			return err
 		}
@@ -322,7 +326,12 @@ func RegisterSingleGitlabAPI() SingleRepoHandler {
 				Domain:      domain,
 				Pa:          pa,
 				Headers:     headers,
+				RawHeaders:  domain.RawHeaders,
 				Metadata:    metadata,
+				Filename:    filename,
+				Archived:    result.Archived,
+				Fork:        result.ForkedFromProject != nil,
+				Topics:      gitlabTopics(result.Topics, result.TagList),
 			}
 		} else {
 			return errors.New("repository is empty." + result.WebURL)
@@ -332,22 +341,100 @@ func RegisterSingleGitlabAPI() SingleRepoHandler {
 	}
 }
 
-// generateGitlabRawURL returns the file Gitlab specific file raw url.
+// generateGitlabRawURL returns the file Gitlab specific file raw url, on the host of the project web url.
+// IN: https://gitlab.example.org/group/project
+// OUT: https://gitlab.example.org/group/project/-/raw/master/publiccode.yml
 func generateGitlabRawURL(baseURL, defaultBranch string) (string, error) {
 	u, err := url.Parse(baseURL)
 	if err != nil {
 		return "", err
 	}
-	u.Path = path.Join(u.Path, "raw", defaultBranch, viper.GetString("CRAWLED_FILENAME"))
+	u.Path = path.Join(u.Path, "-/raw", defaultBranch, viper.GetString("CRAWLED_FILENAME"))
 
 	return u.String(), err
 }
 
+// GitlabBlob is a result from the Gitlab API response for the blobs search of a project.
+type GitlabBlob struct {
+	Basename  string `json:"basename"`
+	Filename  string `json:"filename"`
+	Path      string `json:"path"`
+	Ref       string `json:"ref"`
+	ProjectID int    `json:"project_id"`
+}
+
+// gitlabFileRawURL returns the name and the raw url of the file of the project. If SEARCH_FILE_PATH is set the
+// file is searched in any directory with the blobs search API, otherwise (or if not found) the name is empty
+// and the url is the one of CRAWLED_FILENAME in the root, leaving the candidate names to be tried when fetched.
+func gitlabFileRawURL(ctx context.Context, domain Domain, projectID int, webURL, defaultBranch string, headers map[string]string) (string, string, error) {
+	if searchFilePathEnabled() && defaultBranch != "" {
+		filename, filePath, err := gitlabSearchFile(ctx, domain, projectID, headers)
+		if err != nil {
+			log.Infof("gitlabSearchFile %s: %v", webURL, err)
+		}
+		if filePath != "" {
+			u, err := url.Parse(webURL)
+			if err != nil {
+				return "", "", err
+			}
+			u.Path = path.Join(u.Path, "-/raw", defaultBranch, filePath)
+			return filename, u.String(), nil
+		}
+	}
+
+	rawURL, err := generateGitlabRawURL(webURL, defaultBranch)
+	return "", rawURL, err
+}
+
+// gitlabSearchFile looks for the first of the domain file names in any directory of the project with the
+// blobs search API, and returns the name and the path of the one nearest to the root.
+func gitlabSearchFile(ctx context.Context, domain Domain, projectID int, headers map[string]string) (string, string, error) {
+	for _, filename := range domain.crawledFilenames() {
+		u := url.URL{
+			Scheme:   "https",
+			Host:     domain.Host,
+			Path:     "/api/v4/projects/" + strconv.Itoa(projectID) + "/search",
+			RawQuery: url.Values{"scope": []string{"blobs"}, "search": []string{"filename:" + filename}}.Encode(),
+		}
+
+		resp, err := getURL(ctx, u.String(), headers)
+		if err != nil {
+			return "", "", err
+		}
+		if resp.Status.Code != http.StatusOK {
+			return "", "", errors.New("request returned an incorrect http.Status: " + resp.Status.Text)
+		}
+
+		var blobs []GitlabBlob
+		err = json.Unmarshal(resp.Body, &blobs)
+		if err != nil {
+			return "", "", err
+		}
+
+		var paths []string
+		for _, blob := range blobs {
+			if path.Base(blob.Path) == filename {
+				paths = append(paths, blob.Path)
+			}
+		}
+		if filePath := shallowestPath(paths); filePath != "" {
+			return filename, filePath, nil
+		}
+	}
+
+	return "", "", nil
+}
+
 // addGitlabProjectsToRepositories adds the projects from api response to repository channel.
-func addGitlabProjectsToRepositories(projects []GitlabProject, domain Domain, pa PA, headers map[string]string, repositories chan Repository) error {
+func addGitlabProjectsToRepositories(ctx context.Context, projects []GitlabProject, domain Domain, pa PA, headers map[string]string, repositories chan Repository) error {
 	for _, v := range projects {
+		// Skip the projects not updated since the previous crawl, in incremental mode.
+		if !domain.updatedSince(v.LastActivityAt) {
+			continue
+		}
+
 		// Join file raw URL string.
-		rawURL, err := generateGitlabRawURL(v.WebURL, v.DefaultBranch)
+		filename, rawURL, err := gitlabFileRawURL(ctx, domain, v.ID, v.WebURL, v.DefaultBranch, headers)
 		if err != nil {
 			return err
 		}
@@ -369,7 +456,12 @@ func addGitlabProjectsToRepositories(projects []GitlabProject, domain Domain, pa
 				Domain:      domain,
 				Pa:          pa,
 				Headers:     headers,
+				RawHeaders:  domain.RawHeaders,
 				Metadata:    metadata,
+				Filename:    filename,
+				Archived:    v.Archived,
+				Fork:        v.ForkedFromProject != nil,
+				Topics:      gitlabTopics(v.Topics, v.TagList),
 			}
 		}
 	}
@@ -378,10 +470,15 @@ func addGitlabProjectsToRepositories(projects []GitlabProject, domain Domain, pa
 }
 
 // addGitlabSharedProjectsToRepositories adds the shared projects from api response to repository channel.
-func addGitlabSharedProjectsToRepositories(projects []GitlabSharedProject, domain Domain, pa PA, headers map[string]string, repositories chan Repository) error {
+func addGitlabSharedProjectsToRepositories(ctx context.Context, projects []GitlabSharedProject, domain Domain, pa PA, headers map[string]string, repositories chan Repository) error {
 	for _, v := range projects {
+		// Skip the projects not updated since the previous crawl, in incremental mode.
+		if !domain.updatedSince(v.LastActivityAt) {
+			continue
+		}
+
 		// Join file raw URL string.
-		rawURL, err := generateGitlabRawURL(v.WebURL, v.DefaultBranch)
+		filename, rawURL, err := gitlabFileRawURL(ctx, domain, v.ID, v.WebURL, v.DefaultBranch, headers)
 		if err != nil {
 			return err
 		}
@@ -403,7 +500,12 @@ func addGitlabSharedProjectsToRepositories(projects []GitlabSharedProject, domai
 				Domain:      domain,
 				Pa:          pa,
 				Headers:     headers,
+				RawHeaders:  domain.RawHeaders,
 				Metadata:    metadata,
+				Filename:    filename,
+				Archived:    v.Archived,
+				Fork:        v.ForkedFromProject != nil,
+				Topics:      gitlabTopics(v.Topics, v.TagList),
 			}
 		}
 	}
@@ -411,16 +513,47 @@ func addGitlabSharedProjectsToRepositories(projects []GitlabSharedProject, domai
 	return nil
 }
 
-// GenerateGitlabAPIURL returns the api url of given Gitlab organization link.
+// gitlabTopics returns the topics of a project, from tag_list on the GitLab versions before 14.0.
+func gitlabTopics(topics []string, tagList []interface{}) []string {
+	if len(topics) > 0 {
+		return topics
+	}
+	for _, tag := range tagList {
+		if s, ok := tag.(string); ok {
+			topics = append(topics, s)
+		}
+	}
+	return topics
+}
+
+// gitlabNextPageURL returns the url of the page nextPage of the list at u, or "" if nextPage is empty.
+func gitlabNextPageURL(u *url.URL, nextPage string) string {
+	if nextPage == "" {
+		return ""
+	}
+	next := *u
+	q := next.Query()
+	q.Set("page", nextPage)
+	next.RawQuery = q.Encode()
+
+	return next.String()
+}
+
+// GenerateGitlabAPIURL returns the api url of given Gitlab organization link, on the same host
+// (gitlab.com or a self-hosted instance). The path of the subgroups is escaped, as the API expects.
 // IN: https://gitlab.org/blockninja
 // OUT:https://gitlab.com/api/v4/groups/blockninja
+// IN: https://gitlab.example.org/group/subgroup
+// OUT:https://gitlab.example.org/api/v4/groups/group%2Fsubgroup
 func GenerateGitlabAPIURL() GeneratorAPIURL {
 	return func(in string) (out []string, err error) {
 		u, err := url.Parse(in)
 		if err != nil {
 			return []string{in}, err
 		}
-		u.Path = path.Join("api/v4/groups", u.Path)
+		group := strings.Trim(u.Path, "/")
+		u.Path = "/api/v4/groups/" + group
+		u.RawPath = "/api/v4/groups/" + url.PathEscape(group)
 
 		out = append(out, u.String())
 		return
@@ -442,7 +575,7 @@ func IsGitlab(link string) bool {
 
 	u.Path = "api/v4/templates/gitlab_ci_ymls"
 
-	resp, err := httpclient.GetURL(u.String(), nil)
+	resp, err := getURL(context.Background(), u.String(), nil)
 	if err != nil {
 		log.Debugf("can %s use Gitlab API? No.", link)
 		return false
@@ -1,10 +1,14 @@
 package crawler
 
 import (
+	"context"
+	"encoding/json"
 	"io/ioutil"
+	"net/url"
 	"testing"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
 )
 
 // IsGitlab returns "true" if the url can use Gitlab API.
@@ -46,6 +50,7 @@ func TestGenerateGitlabAPIURL(t *testing.T) {
 		out string
 	}{
 		{"https://gitlab.com/blockninja", "https://gitlab.com/api/v4/groups/blockninja"},
+		{"https://gitlab.example.org/group/subgroup/", "https://gitlab.example.org/api/v4/groups/group%2Fsubgroup"},
 		{":unparsable", ":unparsable"},
 	}
 
@@ -58,3 +63,45 @@ func TestGenerateGitlabAPIURL(t *testing.T) {
 	}
 
 }
+
+func TestGenerateGitlabRawURL(t *testing.T) {
+	viper.Set("CRAWLED_FILENAME", "publiccode.yml")
+	defer viper.Set("CRAWLED_FILENAME", nil)
+
+	out, err := generateGitlabRawURL("https://gitlab.example.org/group/project", "master")
+	if err != nil || out != "https://gitlab.example.org/group/project/-/raw/master/publiccode.yml" {
+		t.Errorf("Unexpected raw url %s (%v)", out, err)
+	}
+}
+
+func TestGitlabNextPageURL(t *testing.T) {
+	u, _ := url.Parse("https://gitlab.example.org/api/v4/groups/group%2Fsubgroup?per_page=100")
+
+	if out := gitlabNextPageURL(u, "2"); out != "https://gitlab.example.org/api/v4/groups/group%2Fsubgroup?page=2&per_page=100" {
+		t.Errorf("Unexpected next page url %s", out)
+	}
+	if out := gitlabNextPageURL(u, ""); out != "" {
+		t.Errorf("Expected no next page, got %s", out)
+	}
+}
+
+// TestGitlabSharedProjectsFork checks that the shared projects with a forked_from_project are forks,
+// even when its id is not returned.
+func TestGitlabSharedProjectsFork(t *testing.T) {
+	var projects []GitlabSharedProject
+	data := `[{"path_with_namespace": "group/fork", "web_url": "https://gitlab.com/group/fork", "default_branch": "main",
+		"forked_from_project": {"name": "upstream"}},
+		{"path_with_namespace": "group/project", "web_url": "https://gitlab.com/group/project", "default_branch": "main"}]`
+	if err := json.Unmarshal([]byte(data), &projects); err != nil {
+		t.Fatal(err)
+	}
+
+	repositories := make(chan Repository, 2)
+	err := addGitlabSharedProjectsToRepositories(context.Background(), projects, Domain{Host: "gitlab.com"}, PA{}, nil, repositories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork, project := <-repositories, <-repositories; !fork.Fork || project.Fork {
+		t.Errorf("Expected only %s to be a fork, got %v and %v", fork.Name, fork.Fork, project.Fork)
+	}
+}
@@ -0,0 +1,78 @@
+package crawler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// incrementalEnabled returns true if only the repositories updated since the previous crawl
+// of their domain are crawled (CRAWL_MODE = "incremental").
+func incrementalEnabled() bool {
+	return viper.GetString("CRAWL_MODE") == "incremental"
+}
+
+// lastCrawlsPath returns the path of the file with the start time of the last crawl of every domain.
+func lastCrawlsPath() string {
+	return filepath.Join(viper.GetString("CRAWLER_DATADIR"), "last_crawl.json")
+}
+
+// loadLastCrawls returns the start time of the last completed crawl of every domain, by host.
+func loadLastCrawls() (map[string]time.Time, error) {
+	lastCrawls := make(map[string]time.Time)
+
+	data, err := ioutil.ReadFile(lastCrawlsPath())
+	if os.IsNotExist(err) {
+		return lastCrawls, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &lastCrawls)
+	return lastCrawls, err
+}
+
+// completedDomains returns the configured domains crawled completely, whose last crawl time can advance:
+// the ones with list errors, repositories not processed or files not fetched (see seenRepositories.fail)
+// are crawled again from their previous crawl.
+func (c *Crawler) completedDomains() []Domain {
+	var domains []Domain
+	for _, domain := range c.currentDomains() {
+		if c.seen.failed(domain.Host) {
+			log.WithField("domain", domain.Host).Warn("domain not crawled completely, its last crawl time is kept")
+			continue
+		}
+		domains = append(domains, domain)
+	}
+	return domains
+}
+
+// saveLastCrawls records start as the time of the last completed crawl of domains.
+func saveLastCrawls(domains []Domain, start time.Time) error {
+	lastCrawls, err := loadLastCrawls()
+	if err != nil {
+		return err
+	}
+	for _, domain := range domains {
+		lastCrawls[domain.Host] = start
+	}
+
+	data, err := json.MarshalIndent(lastCrawls, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(lastCrawlsPath(), data, fileMode())
+}
+
+// updatedSince returns true if a repository updated at t has to be crawled: always in full mode,
+// otherwise only if updated after the previous crawl of the domain.
+func (domain Domain) updatedSince(t time.Time) bool {
+	return domain.since.IsZero() || t.After(domain.since)
+}
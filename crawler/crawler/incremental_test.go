@@ -0,0 +1,75 @@
+package crawler
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// TestLastCrawls checks that the last crawl times are saved and read back by domain.
+func TestLastCrawls(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("CRAWLER_DATADIR", dir)
+	defer viper.Set("CRAWLER_DATADIR", nil)
+
+	lastCrawls, err := loadLastCrawls()
+	if err != nil || len(lastCrawls) != 0 {
+		t.Errorf("Expected no last crawls, got %v (%v)", lastCrawls, err)
+	}
+
+	start := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	err = saveLastCrawls([]Domain{{Host: "github.com"}, {Host: "gitlab.com"}}, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = saveLastCrawls([]Domain{{Host: "gitlab.com"}}, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastCrawls, err = loadLastCrawls()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lastCrawls["github.com"].Equal(start) || !lastCrawls["gitlab.com"].Equal(start.Add(time.Hour)) {
+		t.Errorf("Unexpected last crawls %v", lastCrawls)
+	}
+}
+
+// TestCompletedDomains checks that the last crawl time advances only for the domains crawled completely.
+func TestCompletedDomains(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	c := Crawler{domains: []Domain{{Host: "github.com"}, {Host: "gitlab.com"}, {Host: "bitbucket.org"}, {Host: "gitea.com"}}}
+	c.seen.listError("gitlab.com")
+	c.seen.notProcessed("bitbucket.org")
+	c.seen.fail("gitea.com")
+
+	domains := c.completedDomains()
+	if len(domains) != 1 || domains[0].Host != "github.com" {
+		t.Errorf("Expected only github.com completed, got %v", domains)
+	}
+}
+
+func TestUpdatedSince(t *testing.T) {
+	since := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	if !(Domain{}).updatedSince(since.Add(-time.Hour)) {
+		t.Errorf("Expected every repository to be crawled in full mode")
+	}
+	if (Domain{since: since}).updatedSince(since.Add(-time.Hour)) {
+		t.Errorf("Expected a repository updated before the previous crawl to be skipped")
+	}
+	if !(Domain{since: since}).updatedSince(since.Add(time.Hour)) {
+		t.Errorf("Expected a repository updated after the previous crawl to be crawled")
+	}
+}
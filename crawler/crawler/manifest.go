@@ -0,0 +1,152 @@
+package crawler
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// manifestEntry is the checksum and the size of a saved file.
+type manifestEntry struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// newManifestEntry returns the manifest entry of data.
+func newManifestEntry(data []byte) manifestEntry {
+	return manifestEntry{SHA256: fmt.Sprintf("%x", sha256.Sum256(data)), Size: int64(len(data))}
+}
+
+// manifest maps the paths of the files saved in CRAWLER_DATADIR, relative to it, to their entries.
+// It's kept in CRAWLER_DATADIR/manifest.json and loaded on first use.
+type manifest struct {
+	sync.Mutex
+	datadir string
+	entries map[string]manifestEntry
+}
+
+// savedManifest is the manifest of the local storage, updated by SaveToFile.
+var savedManifest manifest
+
+// manifestPath returns the path of the manifest of the data directory.
+func manifestPath(datadir string) string {
+	return filepath.Join(datadir, "manifest.json")
+}
+
+// readManifest reads the manifest of the data directory, empty if it doesn't exist.
+func readManifest(datadir string) (map[string]manifestEntry, error) {
+	entries := make(map[string]manifestEntry)
+
+	data, err := ioutil.ReadFile(manifestPath(datadir))
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &entries)
+	return entries, err
+}
+
+// update sets the entry of the file at path (relative to CRAWLER_DATADIR) with data, or removes it if
+// data is nil. The manifest is written only if it changed.
+func (m *manifest) update(path string, data []byte) error {
+	m.Lock()
+	defer m.Unlock()
+
+	datadir := viper.GetString("CRAWLER_DATADIR")
+	if m.entries == nil || m.datadir != datadir {
+		entries, err := readManifest(datadir)
+		if err != nil {
+			return err
+		}
+		m.datadir, m.entries = datadir, entries
+	}
+
+	path = filepath.ToSlash(path)
+	entry, ok := m.entries[path]
+	if data == nil {
+		if !ok {
+			return nil
+		}
+		delete(m.entries, path)
+	} else {
+		if ok && entry == newManifestEntry(data) {
+			return nil
+		}
+		m.entries[path] = newManifestEntry(data)
+	}
+
+	out, err := json.MarshalIndent(m.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(manifestPath(datadir), out, fileMode())
+}
+
+// ManifestMismatch is a saved file that doesn't match the manifest.
+type ManifestMismatch struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// VerifyManifest checks the files saved in datadir against its manifest, returning the ones missing,
+// with a different size or checksum, or not in the manifest.
+func VerifyManifest(datadir string) ([]ManifestMismatch, error) {
+	entries, err := readManifest(datadir)
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatches []ManifestMismatch
+	for path, entry := range entries {
+		data, err := ioutil.ReadFile(filepath.Join(datadir, filepath.FromSlash(path)))
+		switch {
+		case os.IsNotExist(err):
+			mismatches = append(mismatches, ManifestMismatch{path, "missing"})
+		case err != nil:
+			return nil, err
+		case int64(len(data)) != entry.Size:
+			mismatches = append(mismatches, ManifestMismatch{path, fmt.Sprintf("size %d, %d expected", len(data), entry.Size)})
+		case newManifestEntry(data).SHA256 != entry.SHA256:
+			mismatches = append(mismatches, ManifestMismatch{path, "checksum mismatch"})
+		}
+	}
+
+	// The saved files are in the directories of the repositories, the clones are not saved files.
+	err = filepath.Walk(datadir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && path == filepath.Join(datadir, "repos") {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || filepath.Dir(path) == datadir || isSidecarFile(path) || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(datadir, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := entries[filepath.ToSlash(rel)]; !ok {
+			mismatches = append(mismatches, ManifestMismatch{filepath.ToSlash(rel), "not in the manifest"})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Path < mismatches[j].Path })
+	return mismatches, nil
+}
@@ -0,0 +1,67 @@
+package crawler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// TestVerifyManifest checks that the saved files are recorded in the manifest, and that the missing,
+// changed and unknown files are reported.
+func TestVerifyManifest(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("CRAWLER_DATADIR", dir)
+	defer viper.Set("CRAWLER_DATADIR", nil)
+
+	domain := Domain{Host: "github.com"}
+	for _, name := range []string{"italia/a", "italia/b", "italia/c"} {
+		err := SaveToFile(domain, "github.com", name, "publiccode.yml", []byte("name: "+name+"\n"), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if mismatches, err := VerifyManifest(dir); err != nil || len(mismatches) != 0 {
+		t.Fatalf("Expected no mismatches, got %v (%v)", mismatches, err)
+	}
+
+	// Corrupt a file, remove another and add one not saved by the crawler.
+	if err := ioutil.WriteFile(filepath.Join(dir, "github.com/italia/a/test_publiccode.yml"), []byte("name: x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "github.com/italia/b/test_publiccode.yml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "github.com/italia/c/test_other.yml"), []byte("name: x\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mismatches, err := VerifyManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ManifestMismatch{
+		{"github.com/italia/a/test_publiccode.yml", "size 8, 15 expected"},
+		{"github.com/italia/b/test_publiccode.yml", "missing"},
+		{"github.com/italia/c/test_other.yml", "not in the manifest"},
+	}
+	if len(mismatches) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, mismatches)
+	}
+	for i := range expected {
+		if mismatches[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], mismatches[i])
+		}
+	}
+}
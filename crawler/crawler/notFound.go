@@ -0,0 +1,63 @@
+package crawler
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+	"github.com/spf13/viper"
+)
+
+// notFoundCache keeps the urls that returned 404 in the crawl, so that they are not requested again
+// (e.g. by the fallback branches or by a repository listed twice) until their entry expires.
+type notFoundCache struct {
+	sync.Mutex
+	expires map[string]time.Time
+}
+
+// notFoundTTL returns how long a 404 is remembered (NOT_FOUND_CACHE_TTL), by default 5 minutes.
+// With 0 the urls are always requested.
+func notFoundTTL() time.Duration {
+	if !viper.IsSet("NOT_FOUND_CACHE_TTL") {
+		return 5 * time.Minute
+	}
+	return viper.GetDuration("NOT_FOUND_CACHE_TTL")
+}
+
+// add remembers that url returned 404 at now, for ttl.
+func (nf *notFoundCache) add(url string, now time.Time, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	nf.Lock()
+	defer nf.Unlock()
+
+	if nf.expires == nil {
+		nf.expires = make(map[string]time.Time)
+	}
+	nf.expires[url] = now.Add(ttl)
+}
+
+// has returns true if url returned 404 and its entry is not expired at now.
+func (nf *notFoundCache) has(url string, now time.Time) bool {
+	nf.Lock()
+	defer nf.Unlock()
+
+	expires, ok := nf.expires[url]
+	if ok && !now.Before(expires) {
+		delete(nf.expires, url)
+		return false
+	}
+	return ok
+}
+
+// notFoundResponse is the response returned, without any request, for the urls in the cache.
+func notFoundResponse(url string) httpclient.HTTPResponse {
+	return httpclient.HTTPResponse{
+		Status:  httpclient.ResponseStatus{Text: "404 Not Found (cached)", Code: http.StatusNotFound},
+		Headers: http.Header{},
+		URL:     url,
+	}
+}
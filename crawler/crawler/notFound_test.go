@@ -0,0 +1,50 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// TestNotFoundCache checks that the urls that returned 404 are not requested again until their entry expires.
+func TestNotFoundCache(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	const rawURL = "https://example.org/publiccode.yml"
+	fetcher := newFakeFetcher(map[string][]int{rawURL: {http.StatusNotFound, http.StatusOK}})
+	c := Crawler{fetcher: fetcher}
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.fetchURL(context.Background(), Repository{FileRawURL: rawURL})
+		if resp.Status.Code != http.StatusNotFound || !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected a 404, got %d (%v)", resp.Status.Code, err)
+		}
+	}
+	if fetcher.calls[rawURL] != 1 {
+		t.Errorf("Expected a single request, got %d", fetcher.calls[rawURL])
+	}
+
+	var nf notFoundCache
+	now := time.Now()
+	nf.add(rawURL, now, time.Minute)
+	if !nf.has(rawURL, now.Add(30*time.Second)) || nf.has(rawURL, now.Add(time.Minute)) {
+		t.Error("Expected the entry to expire after the ttl")
+	}
+	nf.add(rawURL, now, 0)
+	if nf.has(rawURL, now) {
+		t.Error("Expected no entry with a ttl of 0")
+	}
+
+	viper.Set("NOT_FOUND_CACHE_TTL", "0s")
+	defer viper.Set("NOT_FOUND_CACHE_TTL", nil)
+	if ttl := notFoundTTL(); ttl != 0 {
+		t.Errorf("Expected the cache disabled, got a ttl of %v", ttl)
+	}
+}
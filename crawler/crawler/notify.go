@@ -0,0 +1,55 @@
+package crawler
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+	"github.com/spf13/viper"
+)
+
+// newRepositoryNotification is the payload POSTed to NOTIFY_WEBHOOK_URL.
+type newRepositoryNotification struct {
+	Source    string    `json:"source"`
+	Name      string    `json:"name"`
+	RawURL    string    `json:"raw_url"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// notifyEnabled returns true if the new repositories have to be notified (NOTIFY_ENABLED).
+func notifyEnabled() bool {
+	return viper.GetBool("NOTIFY_ENABLED") && viper.GetString("NOTIFY_WEBHOOK_URL") != ""
+}
+
+// notifyIfNewRepository POSTs a notification to NOTIFY_WEBHOOK_URL if the repository was never
+// notified before. The notified repositories are marked in DATADIR/<source>/<vendor>/<repo>/.notified.
+func (c *Crawler) notifyIfNewRepository(repository Repository) error {
+	vendor, repo := splitFullName(repository.Name)
+	marker := filepath.Join(viper.GetString("CRAWLER_DATADIR"), repository.folder(), vendor, repo, ".notified")
+	if _, err := os.Stat(marker); err == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(newRepositoryNotification{
+		Source:    repository.Hostname,
+		Name:      repository.Name,
+		RawURL:    repository.FileRawURL,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = httpclient.PostJSON(c.ctx, viper.GetString("NOTIFY_WEBHOOK_URL"), data)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(marker), dirMode())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(marker, []byte(time.Now().Format(time.RFC3339)), fileMode())
+}
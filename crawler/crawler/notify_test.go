@@ -0,0 +1,56 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// TestNotifyIfNewRepository checks that a repository is notified only the first time.
+func TestNotifyIfNewRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var notifications []newRepositoryNotification
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n newRepositoryNotification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("Invalid notification: %v", err)
+		}
+		notifications = append(notifications, n)
+	}))
+	defer ts.Close()
+
+	viper.Set("CRAWLER_DATADIR", dir)
+	viper.Set("NOTIFY_WEBHOOK_URL", ts.URL)
+	defer viper.Set("CRAWLER_DATADIR", nil)
+	defer viper.Set("NOTIFY_WEBHOOK_URL", nil)
+
+	c := Crawler{ctx: context.Background()}
+	repository := Repository{
+		Name:       "italia/developers-italia-backend",
+		Hostname:   "github.com",
+		FileRawURL: "https://raw.githubusercontent.com/italia/developers-italia-backend/master/publiccode.yml",
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.notifyIfNewRepository(repository); err != nil {
+			t.Fatalf("notifyIfNewRepository returned an error: %v", err)
+		}
+	}
+
+	if len(notifications) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(notifications))
+	}
+	if n := notifications[0]; n.Source != "github.com" || n.Name != repository.Name || n.RawURL != repository.FileRawURL {
+		t.Errorf("Unexpected notification %+v", n)
+	}
+}
@@ -0,0 +1,75 @@
+package crawler
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/italia/developers-italia-backend/crawler/metrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// pruneStale removes the files saved by the previous crawls in the directories of the repositories not seen
+// in this crawl (deleted, or whose file was removed), with their sidecar files. Only the repositories of
+// the owners listed in this crawl are pruned, the ones of the publishers and domains not crawled
+// (e.g. with another whitelist) are kept. Nothing is removed if the crawl is incomplete, see crawlComplete.
+func (c *Crawler) pruneStale() error {
+	if complete, reason := c.crawlComplete(); !complete {
+		log.Warnf("%s, the stale files are not pruned", reason)
+		return nil
+	}
+	if viper.GetString("STORAGE") == "s3" {
+		log.Warn("The stale files are only pruned from the local storage")
+		return nil
+	}
+
+	datadir := viper.GetString("CRAWLER_DATADIR")
+	prefix := c.index + "_"
+
+	return filepath.Walk(datadir, func(path string, info os.FileInfo, err error) error {
+		// The sidecar files of a pruned file are removed with it.
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// The clones are not saved files.
+		if info.IsDir() && path == filepath.Join(datadir, "repos") {
+			return filepath.SkipDir
+		}
+
+		dir := filepath.Dir(path)
+		if info.IsDir() || dir == datadir || !strings.HasPrefix(info.Name(), prefix) || isSidecarFile(path) {
+			return nil
+		}
+		if c.seen.seen(dir) || !c.seen.listed(dir) {
+			return nil
+		}
+
+		for _, p := range []string{path, path + ".sha256", path + ".http.json", path + ".meta.json"} {
+			err := os.Remove(p)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		rel, err := filepath.Rel(datadir, path)
+		if err != nil {
+			return err
+		}
+		err = savedManifest.update(rel, nil)
+		if err != nil {
+			return err
+		}
+		log.WithField("path", path).Info("stale file pruned")
+		metrics.GetCounter("repository_file_pruned", c.index).Inc()
+
+		return nil
+	})
+}
+
+// isSidecarFile returns true if path is a file kept next to a saved file.
+func isSidecarFile(path string) bool {
+	return strings.HasSuffix(path, ".sha256") || strings.HasSuffix(path, ".http.json") || strings.HasSuffix(path, ".meta.json")
+}
@@ -0,0 +1,156 @@
+package crawler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+	"github.com/italia/developers-italia-backend/crawler/metrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// TestPruneStale checks that only the saved files of the repositories not seen are removed, and only for the
+// owners listed in the crawl.
+func TestPruneStale(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("CRAWLER_DATADIR", dir)
+	defer viper.Set("CRAWLER_DATADIR", nil)
+	viper.Set("CRAWLED_FILENAME", "publiccode.yml")
+	defer viper.Set("CRAWLED_FILENAME", nil)
+
+	files := []string{
+		"github.com/italia/seen/test_publiccode.yml",
+		"github.com/italia/seen/test_publiccode.yml.sha256",
+		"github.com/italia/stale/test_publiccode.yml",
+		"github.com/italia/stale/test_publiccode.yml.sha256",
+		"github.com/italia/stale/.notified",
+		"github.com/italia/other/other_publiccode.yml",
+		"github.com/another/stale/test_publiccode.yml",
+		"gitlab.com/italia/stale/test_publiccode.yml",
+		"repos/github.com/italia/stale/gitClone/test_publiccode.yml",
+		"test_summary.json",
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := Crawler{index: "test"}
+
+	// Nothing is pruned if no repository was seen.
+	if err := c.pruneStale(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, files[2])); err != nil {
+		t.Errorf("Expected no file to be pruned without seen repositories: %v", err)
+	}
+
+	c.seen.add(Repository{Hostname: "github.com", Name: "italia/seen"})
+	if err := c.pruneStale(); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned := map[string]bool{files[2]: true, files[3]: true}
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(dir, f))
+		if pruned[f] && !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be pruned", f)
+		}
+		if !pruned[f] && err != nil {
+			t.Errorf("Expected %s to be kept: %v", f, err)
+		}
+	}
+}
+
+// blockingFetcher answers the requests only when they are cancelled.
+type blockingFetcher struct{}
+
+func (blockingFetcher) GetURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error) {
+	<-ctx.Done()
+	return httpclient.HTTPResponse{Status: httpclient.ResponseStatus{Text: ctx.Err().Error(), Code: -1}}, ctx.Err()
+}
+
+func (f blockingFetcher) HeadURL(ctx context.Context, url string, headers map[string]string) (httpclient.HTTPResponse, error) {
+	return f.GetURL(ctx, url, headers)
+}
+
+// TestPruneStaleIncomplete checks that nothing is pruned after a partial crawl: in incremental mode, with
+// repositories over MAX_REPOS_PER_DOMAIN or abandoned after REPO_TIMEOUT.
+func TestPruneStaleIncomplete(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("CRAWLER_DATADIR", dir)
+	defer viper.Set("CRAWLER_DATADIR", nil)
+	viper.Set("CRAWLED_FILENAME", "publiccode.yml")
+	defer viper.Set("CRAWLED_FILENAME", nil)
+
+	stale := filepath.Join(dir, "github.com/italia/stale/test_publiccode.yml")
+	if err := os.MkdirAll(filepath.Dir(stale), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(stale, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	seen := Repository{Hostname: "github.com", Name: "italia/seen", Domain: Domain{Host: "github.com"}}
+
+	tests := []struct {
+		key   string
+		value interface{}
+		crawl func(c *Crawler)
+	}{
+		{"CRAWL_MODE", "incremental", func(c *Crawler) {}},
+		{"MAX_REPOS_PER_DOMAIN", 1, func(c *Crawler) {
+			repositories, sent := c.pageRepositories(context.Background(), seen.Domain)
+			repositories <- seen
+			repositories <- Repository{Hostname: "github.com", Name: "italia/stale", Domain: seen.Domain}
+			sent()
+		}},
+		{"REPO_TIMEOUT", "10ms", func(c *Crawler) {
+			// The metrics are registered on first use, not while the abandoned repository reads them.
+			metrics.GetCounter("repository_timeout", c.index)
+			c.repositoriesWg.Add(1)
+			c.ProcessRepo(Repository{Hostname: "github.com", Name: "italia/stale", FileRawURL: "https://example.org/publiccode.yml",
+				Domain: seen.Domain})
+		}},
+	}
+
+	for _, test := range tests {
+		viper.Set(test.key, test.value)
+		c := Crawler{index: "test", ctx: context.Background(), fetcher: blockingFetcher{}, repositories: make(chan Repository, 2)}
+		c.seen.add(seen)
+		test.crawl(&c)
+		err := c.pruneStale()
+		// The abandoned repository goes on reading the config until its fetch is done.
+		c.waitAbandoned(time.Minute)
+		viper.Set(test.key, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(stale); err != nil {
+			t.Errorf("Expected nothing pruned with %s: %v", test.key, err)
+		}
+	}
+}
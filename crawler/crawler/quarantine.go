@@ -0,0 +1,114 @@
+package crawler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// quarantineState counts the consecutive crawls where the file of a repository was invalid,
+// and the crawls it was skipped since quarantined.
+type quarantineState struct {
+	Failures int `json:"failures"`
+	Skipped  int `json:"skipped"`
+}
+
+// quarantineThreshold returns the number of consecutive crawls with an invalid file after which a repository
+// is quarantined (QUARANTINE_THRESHOLD), 0 if disabled.
+func quarantineThreshold() int {
+	n := viper.GetInt("QUARANTINE_THRESHOLD")
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// quarantineRecheck returns every how many crawls the quarantined repositories are processed again
+// (QUARANTINE_RECHECK), by default 10.
+func quarantineRecheck() int {
+	n := viper.GetInt("QUARANTINE_RECHECK")
+	if n <= 0 {
+		return 10
+	}
+	return n
+}
+
+// quarantinePath returns the path of the state of the repository, DATADIR/<source>/<vendor>/<repo>/.quarantine.
+func quarantinePath(repository Repository) string {
+	vendor, repo := splitFullName(repository.Name)
+	return filepath.Join(viper.GetString("CRAWLER_DATADIR"), repository.folder(), vendor, repo, ".quarantine")
+}
+
+func readQuarantineState(filePath string) (quarantineState, error) {
+	var state quarantineState
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+func writeQuarantineState(filePath string, state quarantineState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filePath), dirMode())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filePath, data, fileMode())
+}
+
+// skipQuarantined returns true if the repository is quarantined and it's not its turn to be rechecked,
+// counting the crawl skipped (except in dry run mode, see dryRun). Every QUARANTINE_RECHECK crawls it's
+// processed again, and with QUARANTINE_RECHECK_ALL all the quarantined repositories are.
+func skipQuarantined(repository Repository) (bool, error) {
+	threshold := quarantineThreshold()
+	if threshold == 0 || viper.GetBool("QUARANTINE_RECHECK_ALL") {
+		return false, nil
+	}
+
+	filePath := quarantinePath(repository)
+	state, err := readQuarantineState(filePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil || state.Failures < threshold || state.Skipped+1 >= quarantineRecheck() {
+		return false, err
+	}
+
+	if dryRun() {
+		return true, nil
+	}
+	state.Skipped++
+	return true, writeQuarantineState(filePath, state)
+}
+
+// recordValidation updates the consecutive crawls with an invalid file of the repository with the
+// validation outcome errs: a valid file takes the repository out of quarantine. The state is not changed
+// in dry run mode, see dryRun.
+func recordValidation(repository Repository, errs ValidationErrors) error {
+	if quarantineThreshold() == 0 || dryRun() {
+		return nil
+	}
+
+	filePath := quarantinePath(repository)
+	if len(errs) == 0 {
+		err := os.Remove(filePath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// A missing or unreadable state starts from zero.
+	state, _ := readQuarantineState(filePath)
+	state.Failures++
+	state.Skipped = 0
+	return writeQuarantineState(filePath, state)
+}
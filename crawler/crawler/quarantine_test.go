@@ -0,0 +1,102 @@
+package crawler
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+// TestQuarantine checks that a repository invalid for QUARANTINE_THRESHOLD crawls is processed again
+// only every QUARANTINE_RECHECK crawls, until its file is valid.
+func TestQuarantine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	viper.Set("CRAWLER_DATADIR", dir)
+	viper.Set("QUARANTINE_THRESHOLD", 2)
+	viper.Set("QUARANTINE_RECHECK", 3)
+	defer viper.Set("CRAWLER_DATADIR", nil)
+	defer viper.Set("QUARANTINE_THRESHOLD", nil)
+	defer viper.Set("QUARANTINE_RECHECK", nil)
+
+	repository := Repository{Name: "italia/repo", Hostname: "github.com", Domain: Domain{Host: "github.com"}}
+	invalid := ValidationErrors{{Key: "name", Reason: "missing mandatory key"}}
+
+	// The processed (false) and skipped (true) crawls, with the file always invalid.
+	var crawls []bool
+	for i := 0; i < 8; i++ {
+		skip, err := skipQuarantined(repository)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crawls = append(crawls, skip)
+		if !skip {
+			if err := recordValidation(repository, invalid); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expected := []bool{false, false, true, true, false, true, true, false}
+	for i := range expected {
+		if crawls[i] != expected[i] {
+			t.Fatalf("Expected crawls %v, got %v", expected, crawls)
+		}
+	}
+
+	viper.Set("QUARANTINE_RECHECK_ALL", true)
+	skip, _ := skipQuarantined(repository)
+	viper.Set("QUARANTINE_RECHECK_ALL", nil)
+	if skip {
+		t.Errorf("Expected the repository rechecked with QUARANTINE_RECHECK_ALL")
+	}
+
+	// A valid file takes the repository out of quarantine.
+	if err := recordValidation(repository, nil); err != nil {
+		t.Fatal(err)
+	}
+	if skip, _ := skipQuarantined(repository); skip {
+		t.Errorf("Expected the repository out of quarantine")
+	}
+	if _, err := os.Stat(quarantinePath(repository)); !os.IsNotExist(err) {
+		t.Errorf("Expected the quarantine state removed, got %v", err)
+	}
+}
+
+// TestQuarantineDryRun checks that the quarantine state is read, but never written, in dry run mode.
+func TestQuarantineDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	viper.Set("CRAWLER_DATADIR", dir)
+	viper.Set("QUARANTINE_THRESHOLD", 1)
+	viper.Set("DRY_RUN", true)
+	defer viper.Set("CRAWLER_DATADIR", nil)
+	defer viper.Set("QUARANTINE_THRESHOLD", nil)
+	defer viper.Set("DRY_RUN", nil)
+
+	repository := Repository{Name: "italia/repo", Hostname: "github.com", Domain: Domain{Host: "github.com"}}
+	if err := recordValidation(repository, ValidationErrors{{Key: "name", Reason: "missing mandatory key"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(quarantinePath(repository)); !os.IsNotExist(err) {
+		t.Fatalf("Expected no quarantine state written, got %v", err)
+	}
+
+	if err := writeQuarantineState(quarantinePath(repository), quarantineState{Failures: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if skip, err := skipQuarantined(repository); err != nil || !skip {
+		t.Errorf("Expected the quarantined repository skipped, got %v (%v)", skip, err)
+	}
+	if state, err := readQuarantineState(quarantinePath(repository)); err != nil || state.Skipped != 0 {
+		t.Errorf("Expected the quarantine state unchanged, got %+v (%v)", state, err)
+	}
+}
@@ -0,0 +1,92 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/italia/developers-italia-backend/crawler/metrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"golang.org/x/time/rate"
+)
+
+const (
+	headerRateReset     = "X-RateLimit-Reset"
+	headerRateRemaining = "X-RateLimit-Remaining"
+)
+
+// waitRateLimit inspects the X-RateLimit-Remaining and X-RateLimit-Reset headers of a response
+// and, when the remaining requests drop to RATELIMIT_THRESHOLD or below, waits until the reset
+// time so that the following requests don't get a 403.
+// The wait is interrupted (returning the context error) when ctx is done.
+func waitRateLimit(ctx context.Context, domain Domain, headers http.Header) error {
+	remaining, err := strconv.Atoi(headers.Get(headerRateRemaining))
+	if err != nil {
+		// No rate limit information in this response.
+		return nil
+	}
+	metrics.GetGaugeVec("ratelimit_remaining", viper.GetString("ELASTIC_PUBLICCODE_INDEX"), "domain").WithLabelValues(domain.Host).Set(float64(remaining))
+
+	if remaining > viper.GetInt("RATELIMIT_THRESHOLD") {
+		return nil
+	}
+
+	reset, err := strconv.ParseInt(headers.Get(headerRateReset), 10, 64)
+	if err != nil {
+		log.Warnf("%s: invalid %s header: %v", domain.Host, headerRateReset, err)
+		return nil
+	}
+
+	wait := time.Until(time.Unix(reset, 0))
+	if wait <= 0 {
+		return nil
+	}
+	log.Infof("%s: %d requests remaining, waiting %v for the rate limit reset", domain.Host, remaining, wait)
+
+	return sleepContext(ctx, wait)
+}
+
+// domainLimiters holds the request rate limiters of the domains, by host.
+var domainLimiters sync.Map
+
+// waitDomainLimit waits until a request to domain is allowed by its rate limit: the
+// rate-limit of the domain in domains.yml, or RATELIMIT_DEFAULT (requests per second) if unset.
+// A rate limit of 0 means no limit.
+func waitDomainLimit(ctx context.Context, domain Domain) error {
+	limiter := domainLimiter(domain)
+	if limiter == nil {
+		return nil
+	}
+
+	return limiter.Wait(ctx)
+}
+
+// domainLimiter returns the rate limiter shared by the requests to domain, or nil if unlimited.
+func domainLimiter(domain Domain) *rate.Limiter {
+	limit := domain.RateLimit
+	if limit == 0 {
+		limit = viper.GetFloat64("RATELIMIT_DEFAULT")
+	}
+	if limit <= 0 {
+		return nil
+	}
+
+	limiter, _ := domainLimiters.LoadOrStore(domain.Host, rate.NewLimiter(rate.Limit(limit), 1))
+	return limiter.(*rate.Limiter)
+}
+
+// sleepContext pauses for the given duration or until ctx is done, whichever happens first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
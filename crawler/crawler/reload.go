@@ -0,0 +1,208 @@
+package crawler
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// loadDomains reads and validates the domains in file. In incremental mode the start time
+// of the previous crawl is set on each of them.
+func loadDomains(file string) ([]Domain, error) {
+	domains, err := ReadAndParseDomains(file)
+	if err != nil {
+		return nil, err
+	}
+	err = expandDomainsEnv(domains)
+	if err != nil {
+		return nil, err
+	}
+	err = validateDomains(domains)
+	if err != nil {
+		return nil, err
+	}
+
+	// In incremental mode only crawl the repositories updated since the previous crawl.
+	if incrementalEnabled() {
+		lastCrawls, err := loadLastCrawls()
+		if err != nil {
+			return nil, fmt.Errorf("error reading the last crawl times: %v", err)
+		}
+		for i := range domains {
+			domains[i].since = lastCrawls[domains[i].Host]
+		}
+	}
+
+	return domains, nil
+}
+
+// currentDomains returns the configured domains.
+func (c *Crawler) currentDomains() []Domain {
+	c.domainsMu.RLock()
+	defer c.domainsMu.RUnlock()
+	return c.domains
+}
+
+// isConfigured returns true if host is one of the configured domains.
+func (c *Crawler) isConfigured(host string) bool {
+	for _, domain := range c.currentDomains() {
+		if domain.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadDomains replaces the configured domains with the ones in file, leaving them unchanged on error.
+// The organizations started afterwards use the new domains, while the ones of a removed domain stop
+// after the page being processed.
+func (c *Crawler) reloadDomains(file string) error {
+	domains, err := loadDomains(file)
+	if err != nil {
+		return err
+	}
+
+	previous := make(map[string]bool)
+	for _, domain := range c.currentDomains() {
+		previous[domain.Host] = true
+	}
+	for _, domain := range domains {
+		if !previous[domain.Host] {
+			log.WithField("domain", domain.Host).Info("domain added")
+		}
+		delete(previous, domain.Host)
+	}
+	for host := range previous {
+		log.WithField("domain", host).Info("domain removed")
+	}
+
+	c.domainsMu.Lock()
+	c.domains = domains
+	c.domainsMu.Unlock()
+	return nil
+}
+
+// reloadableKeys are the config keys applied to the crawl in progress on SIGHUP. The other ones are read
+// by the workers from the global viper while crawling, and they apply from the next run.
+var reloadableKeys = map[string]bool{"page_delay": true, "repo_include": true, "repo_exclude": true}
+
+// readConfig reads the config file into a new viper instance, leaving the one read by the workers untouched.
+func readConfig(file string) (*viper.Viper, error) {
+	fresh := viper.New()
+	fresh.SetConfigFile(file)
+	if err := fresh.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return fresh, nil
+}
+
+// configValue returns the value of key in use: the one reloaded on SIGHUP for the reloadable keys,
+// otherwise the one of the global viper.
+func (c *Crawler) configValue(key string) interface{} {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	if c.reloaded != nil && reloadableKeys[strings.ToLower(key)] {
+		return c.reloaded.Get(key)
+	}
+	return viper.Get(key)
+}
+
+// changedConfigKeys returns the keys of fresh whose values differ from the ones in use.
+func (c *Crawler) changedConfigKeys(fresh *viper.Viper) []string {
+	var changed []string
+	for _, key := range fresh.AllKeys() {
+		if fmt.Sprint(fresh.Get(key)) != fmt.Sprint(c.configValue(key)) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// reloadConfig applies the reloadable keys (see reloadableKeys) of the config file to the crawl in progress,
+// leaving them unchanged on error. The other changed keys are logged, they apply from the next run.
+func (c *Crawler) reloadConfig(file string) error {
+	fresh, err := readConfig(file)
+	if err != nil {
+		return err
+	}
+	filter, err := newRepoFilter(fresh.GetStringSlice("REPO_INCLUDE"), fresh.GetStringSlice("REPO_EXCLUDE"))
+	if err != nil {
+		return fmt.Errorf("invalid REPO_INCLUDE/REPO_EXCLUDE pattern: %v", err)
+	}
+
+	var applied, next []string
+	for _, key := range c.changedConfigKeys(fresh) {
+		if reloadableKeys[key] {
+			applied = append(applied, key)
+		} else {
+			next = append(next, key)
+		}
+	}
+
+	c.configMu.Lock()
+	c.reloaded = fresh
+	c.filter = filter
+	c.configMu.Unlock()
+
+	if len(applied) > 0 {
+		log.Infof("Config changed, applied to this run: %v", applied)
+	}
+	if len(next) > 0 {
+		log.Warnf("Config changed, applied from the next run: %v", next)
+	}
+	return nil
+}
+
+// pageDelay returns the PAGE_DELAY in use, the reloaded one if any.
+func (c *Crawler) pageDelay() time.Duration {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+
+	if c.reloaded != nil {
+		return c.reloaded.GetDuration("PAGE_DELAY")
+	}
+	return viper.GetDuration("PAGE_DELAY")
+}
+
+// currentFilter returns the REPO_INCLUDE/REPO_EXCLUDE filter in use.
+func (c *Crawler) currentFilter() repoFilter {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+	return c.filter
+}
+
+// handleReload reloads the domains file and the config on SIGHUP, until the crawl context is done.
+// Only the reloadable keys of the config are applied to the crawl in progress, see reloadConfig.
+func (c *Crawler) handleReload(domainsFile string) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-sigs:
+				log.Info("Received SIGHUP, reloading the domains and the config")
+				if file := viper.ConfigFileUsed(); file != "" {
+					if err := c.reloadConfig(file); err != nil {
+						log.Errorf("Error reloading the config, unchanged: %v", err)
+					}
+				}
+				if err := c.reloadDomains(domainsFile); err != nil {
+					log.Errorf("Error reloading %s, domains unchanged: %v", domainsFile, err)
+				}
+			}
+		}
+	}()
+}
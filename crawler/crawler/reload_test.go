@@ -0,0 +1,130 @@
+package crawler
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// TestReloadDomains checks that the domains are replaced by the reloaded ones, and left unchanged
+// if the file is invalid.
+func TestReloadDomains(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+	RegisterClientAPIs()
+
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "domains.yml")
+
+	c := Crawler{domains: []Domain{{Host: "gitlab.com"}, {Host: "github.com"}}}
+
+	err = ioutil.WriteFile(file, []byte("- host: \"github.com\"\n- host: \"gitea.example.org\"\n  type: \"gitea\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.reloadDomains(file); err != nil {
+		t.Fatal(err)
+	}
+	if c.isConfigured("gitlab.com") || !c.isConfigured("github.com") || !c.isConfigured("gitea.example.org") {
+		t.Errorf("Unexpected domains after the reload: %v", c.currentDomains())
+	}
+	if domain, err := c.KnownHost("https://gitea.example.org/org"); err != nil || domain.API() != "gitea" {
+		t.Errorf("Expected the added domain to be known, got %v (%v)", domain, err)
+	}
+
+	err = ioutil.WriteFile(file, []byte("- host: \"github.com\"\n- host: \"github.com\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.reloadDomains(file); err == nil {
+		t.Errorf("Expected an error for the duplicate domains")
+	}
+	if len(c.currentDomains()) != 2 || !c.isConfigured("gitea.example.org") {
+		t.Errorf("Expected the domains unchanged, got %v", c.currentDomains())
+	}
+}
+
+// TestChangedConfigKeys checks that the keys changed in the config file are found, without applying them.
+func TestChangedConfigKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.toml")
+
+	viper.Set("MAX_REPOS_PER_DOMAIN", 3)
+	defer viper.Set("MAX_REPOS_PER_DOMAIN", nil)
+
+	err = ioutil.WriteFile(file, []byte("MAX_REPOS_PER_DOMAIN = 3\nDRY_RUN = true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := readConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c Crawler
+	changed := c.changedConfigKeys(fresh)
+	if len(changed) != 1 || changed[0] != "dry_run" {
+		t.Errorf("Expected only dry_run changed, got %v", changed)
+	}
+	if viper.GetBool("DRY_RUN") {
+		t.Errorf("Expected the config in use unchanged")
+	}
+}
+
+// TestReloadConfig checks that only the reloadable keys are applied, and that an invalid config changes nothing.
+func TestReloadConfig(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.toml")
+
+	viper.Set("PAGE_DELAY", "1s")
+	defer viper.Set("PAGE_DELAY", nil)
+
+	var c Crawler
+	if c.pageDelay() != time.Second || !c.currentFilter().allowed("italia/repo") {
+		t.Fatalf("Expected the config read at the start before a reload")
+	}
+
+	err = ioutil.WriteFile(file, []byte("PAGE_DELAY = \"2s\"\nREPO_EXCLUDE = [\"^italia/\"]\nDRY_RUN = true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.reloadConfig(file); err != nil {
+		t.Fatal(err)
+	}
+	if c.pageDelay() != 2*time.Second || c.currentFilter().allowed("italia/repo") || !c.currentFilter().allowed("other/repo") {
+		t.Errorf("Expected PAGE_DELAY and REPO_EXCLUDE applied, got %v", c.pageDelay())
+	}
+	if viper.GetBool("DRY_RUN") || viper.GetDuration("PAGE_DELAY") != time.Second {
+		t.Errorf("Expected the global config unchanged")
+	}
+
+	err = ioutil.WriteFile(file, []byte("PAGE_DELAY = \"3s\"\nREPO_EXCLUDE = [\"(\"]\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.reloadConfig(file); err == nil {
+		t.Errorf("Expected an error for the invalid pattern")
+	}
+	if c.pageDelay() != 2*time.Second || c.currentFilter().allowed("italia/repo") {
+		t.Errorf("Expected the config unchanged, got %v", c.pageDelay())
+	}
+}
@@ -0,0 +1,133 @@
+package crawler
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// listedRepository is an entry of a list of repositories read by the "file" domains.
+type listedRepository struct {
+	// Host of the code hosting service of the repository, e.g. "github.com".
+	Source string `yaml:"source"`
+	// Full name of the repository, e.g. "italia/developers-italia-backend".
+	FullName string `yaml:"full-name"`
+}
+
+// readRepositoryList reads the list of repositories at path: a CSV file of "source,fullName" lines
+// (with "#" comments), or a YAML list of "source" and "full-name" entries if the extension is .yml or .yaml.
+func readRepositoryList(path string) ([]listedRepository, error) {
+	var list []listedRepository
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &list); err != nil {
+			return nil, err
+		}
+	default:
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		r := csv.NewReader(f)
+		r.Comment = '#'
+		r.FieldsPerRecord = 2
+		r.TrimLeadingSpace = true
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, listedRepository{Source: record[0], FullName: record[1]})
+		}
+	}
+
+	for i, entry := range list {
+		list[i].Source = strings.Trim(strings.TrimSpace(entry.Source), "/")
+		list[i].FullName = strings.Trim(strings.TrimSpace(entry.FullName), "/")
+		if list[i].Source == "" || list[i].FullName == "" {
+			return nil, fmt.Errorf("entry #%d: source and full name are required", i+1)
+		}
+	}
+
+	return list, nil
+}
+
+// sourceDomain returns the Domain of the code hosting service source, for an entry of the list of domain:
+// the one configured in domains.yml if any, otherwise the one inferred from the host.
+func (domain Domain) sourceDomain(source string) (*Domain, error) {
+	if domain.resolve != nil {
+		return domain.resolve("https://" + source)
+	}
+	return &Domain{Host: source}, nil
+}
+
+// RegisterFileAPI register the crawler function for the "file" domains, listing repositories from a local file.
+// The path of the "link" url (e.g. file://curated.local/srv/lists/curated.csv) is the path of the file,
+// read by readRepositoryList. Each repository is processed with the single repository API of its source,
+// and an error is returned, after the whole list, if some of them could not be read.
+// The list is a single page, so the returned next url is always empty ("").
+func RegisterFileAPI() OrganizationHandler {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) (string, error) {
+		u, err := url.Parse(link)
+		if err != nil {
+			return "", err
+		}
+		if u.Path == "" {
+			return "", errors.New("missing path of the list of repositories in " + link)
+		}
+
+		list, err := readRepositoryList(u.Path)
+		if err != nil {
+			return "", fmt.Errorf("error in reading %s: %v", u.Path, err)
+		}
+
+		failed := 0
+		for _, entry := range list {
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
+
+			repoURL := "https://" + entry.Source + "/" + entry.FullName
+			source, err := domain.sourceDomain(entry.Source)
+			if err == nil {
+				err = source.processSingleRepo(ctx, repoURL, repositories, pa)
+			}
+			if err != nil {
+				log.WithFields(log.Fields{"domain": domain.Host, "url": repoURL}).WithError(err).Error("error reading listed repository")
+				failed++
+			}
+		}
+		if failed > 0 {
+			return "", fmt.Errorf("%d of %d listed repositories could not be read", failed, len(list))
+		}
+
+		return "", nil
+	}
+}
+
+// GenerateFileAPIURL returns the url of the list of repositories, unchanged.
+func GenerateFileAPIURL() GeneratorAPIURL {
+	return func(in string) (out []string, err error) {
+		return []string{in}, nil
+	}
+}
@@ -0,0 +1,97 @@
+package crawler
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TestReadRepositoryList checks that the CSV and YAML lists are read, and that incomplete entries are rejected.
+func TestReadRepositoryList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lists := []struct {
+		filename string
+		data     string
+		entries  int
+		valid    bool
+	}{
+		{"list.csv", "# source,full name\ngithub.com,italia/repo\n gitlab.com, /group/project/\n", 2, true},
+		{"list.yml", "- source: github.com\n  full-name: italia/repo\n", 1, true},
+		{"list.csv", "github.com\n", 0, false},
+		{"list.csv", "github.com,\n", 0, false},
+		{"list.yaml", "- source: github.com\n", 0, false},
+	}
+
+	for _, l := range lists {
+		path := filepath.Join(dir, l.filename)
+		if err := ioutil.WriteFile(path, []byte(l.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		list, err := readRepositoryList(path)
+		if (err == nil) != l.valid || len(list) != l.entries {
+			t.Errorf("Expected %d entries from %q, got %v (%v)", l.entries, l.data, list, err)
+		}
+		if l.valid && list[len(list)-1].FullName == "" {
+			t.Errorf("Unexpected entries %v", list)
+		}
+	}
+}
+
+// TestFileAPI checks that the listed repositories are processed with the single repository API of their source.
+func TestFileAPI(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	RegisterClientAPIs()
+	clientAPIs["test"] = ClientAPI{
+		Single: func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) error {
+			repositories <- Repository{Name: link, Domain: domain}
+			return nil
+		},
+	}
+	defer delete(clientAPIs, "test")
+
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "list.csv")
+	if err := ioutil.WriteFile(path, []byte("git.example.org,italia/repo\nunknown.example.org,italia/other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	domain := Domain{Host: "curated.local", Type: "file", resolve: func(link string) (*Domain, error) {
+		u, _ := url.Parse(link)
+		if u.Hostname() != "git.example.org" {
+			return &Domain{Host: u.Hostname()}, nil
+		}
+		return &Domain{Host: u.Hostname(), Type: "test"}, nil
+	}}
+
+	repositories := make(chan Repository, 2)
+	next, err := domain.processAndGetNextURL(context.Background(), "file://curated.local"+path, repositories, PA{})
+	close(repositories)
+	// The repository of the unknown source is an error, after the whole list.
+	if err == nil || next != "" {
+		t.Fatalf("Expected a single page with an error, got %q (%v)", next, err)
+	}
+
+	var processed []Repository
+	for repository := range repositories {
+		processed = append(processed, repository)
+	}
+	if len(processed) != 1 || processed[0].Name != "https://git.example.org/italia/repo" || processed[0].Domain.Host != "git.example.org" {
+		t.Errorf("Unexpected repositories %v", processed)
+	}
+}
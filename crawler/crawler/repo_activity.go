@@ -44,7 +44,7 @@ func (repository *Repository) CalculateRepoActivity(days int) (float64, map[int]
 
 	vendor, repo := splitFullName(repository.Name)
 
-	path := filepath.Join(viper.GetString("CRAWLER_DATADIR"), "repos", repository.Hostname, vendor, repo, "gitClone")
+	path := filepath.Join(viper.GetString("CRAWLER_DATADIR"), "repos", repository.folder(), vendor, repo, "gitClone")
 
 	// MkdirAll will create all the folder path, if not exists.
 	if _, err := os.Stat(path); os.IsNotExist(err) {
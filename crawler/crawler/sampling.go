@@ -0,0 +1,100 @@
+package crawler
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxReposPerDomain returns the maximum number of repositories crawled for each domain
+// (MAX_REPOS_PER_DOMAIN), 0 if unlimited.
+func maxReposPerDomain() int {
+	n := viper.GetInt("MAX_REPOS_PER_DOMAIN")
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// domainLimits counts the repositories emitted for each domain, up to maxReposPerDomain.
+type domainLimits struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+// take returns true and counts the repository if the limit of host is not reached yet.
+func (l *domainLimits) take(host string, max int) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.counts == nil {
+		l.counts = make(map[string]int)
+	}
+	if l.counts[host] >= max {
+		return false
+	}
+	l.counts[host]++
+	return true
+}
+
+// reached returns true if max repositories of host were emitted.
+func (l *domainLimits) reached(host string, max int) bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.counts[host] >= max
+}
+
+// deterministicEnabled returns true if the repositories of each page are emitted sorted by name (DETERMINISTIC),
+// and processed one at a time unless MAX_CONCURRENT_REQUESTS is set, for reproducible crawls.
+func deterministicEnabled() bool {
+	return viper.GetBool("DETERMINISTIC")
+}
+
+// pageRepositories returns the channel where the repositories of a page of domain are sent, and a function
+// to call when done sending. The repositories are forwarded to the repositories channel until
+// MAX_REPOS_PER_DOMAIN are emitted, dropping the others, with the span of the page in ctx when tracing,
+// and the function waits for the forwarding to end. In DETERMINISTIC mode the repositories of the page
+// are collected and forwarded sorted by name once done. Otherwise the repositories channel is returned as is.
+func (c *Crawler) pageRepositories(ctx context.Context, domain Domain) (chan Repository, func()) {
+	max := maxReposPerDomain()
+	deterministic := deterministicEnabled()
+	if max == 0 && !tracingEnabled() && !deterministic {
+		return c.repositories, func() {}
+	}
+
+	spanContext := trace.SpanContextFromContext(ctx)
+	repositories := make(chan Repository)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var page []Repository
+		forward := func(repository Repository) {
+			if max == 0 || c.limits.take(domain.Host, max) {
+				repository.spanContext = spanContext
+				c.repositories <- repository
+				return
+			}
+			c.seen.notProcessed(domain.Host)
+		}
+		for repository := range repositories {
+			if deterministic {
+				page = append(page, repository)
+				continue
+			}
+			forward(repository)
+		}
+
+		sort.SliceStable(page, func(i, j int) bool { return page[i].Name < page[j].Name })
+		for _, repository := range page {
+			forward(repository)
+		}
+	}()
+
+	return repositories, func() {
+		close(repositories)
+		<-done
+	}
+}
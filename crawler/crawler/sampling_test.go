@@ -0,0 +1,86 @@
+package crawler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestLimitRepositories checks that at most MAX_REPOS_PER_DOMAIN repositories are emitted for each domain,
+// across the pages.
+func TestLimitRepositories(t *testing.T) {
+	viper.Set("MAX_REPOS_PER_DOMAIN", 2)
+	defer viper.Set("MAX_REPOS_PER_DOMAIN", nil)
+
+	c := Crawler{repositories: make(chan Repository, 10)}
+	github := Domain{Host: "github.com"}
+
+	for page := 0; page < 2; page++ {
+		repositories, sent := c.pageRepositories(context.Background(), github)
+		for i := 0; i < 3; i++ {
+			repositories <- Repository{Name: "italia/repo", Domain: github}
+		}
+		sent()
+	}
+	repositories, sent := c.pageRepositories(context.Background(), Domain{Host: "gitlab.com"})
+	repositories <- Repository{Name: "group/project"}
+	sent()
+
+	if n := len(c.repositories); n != 3 {
+		t.Errorf("Expected 3 repositories emitted, got %d", n)
+	}
+	if !c.limits.reached("github.com", 2) || c.limits.reached("gitlab.com", 2) {
+		t.Errorf("Unexpected limits %v", c.limits.counts)
+	}
+
+	viper.Set("MAX_REPOS_PER_DOMAIN", 0)
+	if repositories, _ := c.pageRepositories(context.Background(), github); repositories != c.repositories {
+		t.Errorf("Expected the repositories channel without a limit")
+	}
+}
+
+// TestPageRepositoriesSpan checks that the repositories carry the span of the page when tracing.
+func TestPageRepositoriesSpan(t *testing.T) {
+	viper.Set("OTLP_ENDPOINT", "localhost:4318")
+	defer viper.Set("OTLP_ENDPOINT", nil)
+
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{1},
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), spanContext)
+
+	c := Crawler{repositories: make(chan Repository, 1)}
+	repositories, sent := c.pageRepositories(ctx, Domain{Host: "github.com"})
+	repositories <- Repository{Name: "italia/repo"}
+	sent()
+
+	if repository := <-c.repositories; !repository.spanContext.Equal(spanContext) {
+		t.Errorf("Expected the span of the page, got %v", repository.spanContext)
+	}
+}
+
+// TestPageRepositoriesDeterministic checks that in DETERMINISTIC mode the repositories of a page are emitted sorted by name.
+func TestPageRepositoriesDeterministic(t *testing.T) {
+	viper.Set("DETERMINISTIC", true)
+	defer viper.Set("DETERMINISTIC", nil)
+
+	c := Crawler{repositories: make(chan Repository, 3)}
+	repositories, sent := c.pageRepositories(context.Background(), Domain{Host: "github.com"})
+	for _, name := range []string{"italia/b", "italia/c", "italia/a"} {
+		repositories <- Repository{Name: name}
+	}
+	sent()
+	close(c.repositories)
+
+	var names []string
+	for repository := range c.repositories {
+		names = append(names, repository.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"italia/a", "italia/b", "italia/c"}) {
+		t.Errorf("Expected the repositories sorted by name, got %v", names)
+	}
+}
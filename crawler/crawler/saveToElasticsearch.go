@@ -13,6 +13,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 	"github.com/ghodss/yaml"
+	es "github.com/olivere/elastic"
 )
 
 type administration struct {
@@ -27,6 +28,9 @@ func (c *Crawler) saveToES(repo Repository, activityIndex float64, vitality []in
 	type softwareES struct {
 		FileRawURL            string            `json:"fileRawURL"`
 		ID                    string            `json:"id"`
+		Source                string            `json:"source"`
+		Vendor                string            `json:"vendor"`
+		Repo                  string            `json:"repo"`
 		CrawlTime             string            `json:"crawltime"`
 		ItRiusoCodiceIPALabel string            `json:"it-riuso-codiceIPA-label"`
 		Slug                  string            `json:"slug"`
@@ -39,16 +43,20 @@ func (c *Crawler) saveToES(repo Repository, activityIndex float64, vitality []in
 	// Parse the publiccode.yml file
 	parser := pcode.NewParser()
 	parser.Strict = false
-	parser.RemoteBaseURL = strings.TrimRight(repo.FileRawURL, viper.GetString("CRAWLED_FILENAME"))
+	parser.RemoteBaseURL = remoteBaseURL(repo.FileRawURL, repo.filename())
 	err := parser.Parse(data)
 	if err != nil {
 		log.Errorf("Error parsing publiccode.yml: %v", err)
 	}
 
 	// Create a softwareES object and populate it
+	vendor, repoName := splitFullName(repo.Name)
 	file := softwareES{
 		FileRawURL:            repo.FileRawURL,
 		ID:                    repo.generateID(),
+		Source:                repo.Hostname,
+		Vendor:                vendor,
+		Repo:                  repoName,
 		CrawlTime:             time.Now().Format(time.RFC3339),
 		Slug:				   repo.generateSlug(),
 		ItRiusoCodiceIPALabel: ipa.GetAdministrationName(parser.PublicCode.It.Riuso.CodiceIPA),
@@ -64,38 +72,53 @@ func (c *Crawler) saveToES(repo Repository, activityIndex float64, vitality []in
 	}
 	err = yaml.Unmarshal(yml, &file.PublicCode)
 
-	// Put publiccode data in ES.
-	ctx := context.Background()
-	_, err = c.es.Index().
+	// Queue publiccode data for the ES bulk indexing.
+	c.esBulk.Add(es.NewBulkIndexRequest().
 		Index(c.index).
 		Type("software").
 		Id(file.ID).
-		BodyJson(file).
-		Do(ctx)
-	if err != nil {
-		return err
-	}
+		Doc(file))
 
 	metrics.GetCounter("repository_file_indexed", c.index).Inc()
 
 	// Add administration data.
 	if parser.PublicCode.It.Riuso.CodiceIPA != "" {
-		// Put administrations data in ES.
-		_, err = c.es.Index().
+		// Queue administrations data for the ES bulk indexing.
+		c.esBulk.Add(es.NewBulkIndexRequest().
 			Index(viper.GetString("ELASTIC_PUBLISHERS_INDEX")).
 			Type("administration").
 			Id(parser.PublicCode.It.Riuso.CodiceIPA).
-			BodyJson(administration{
+			Doc(administration{
 				Name:      file.ItRiusoCodiceIPALabel,
 				CodiceIPA: parser.PublicCode.It.Riuso.CodiceIPA,
-			}).
-			Do(ctx)
-		if err != nil {
-			return err
-		}
+			}))
+	}
+
+	return err
+}
+
+// startBulkProcessor starts the ES bulk processor that batches the documents indexed by saveToES.
+func (c *Crawler) startBulkProcessor() (*es.BulkProcessor, error) {
+	bulkActions := viper.GetInt("ELASTIC_BULK_ACTIONS")
+	if bulkActions <= 0 {
+		bulkActions = 100
 	}
 
-	return nil
+	return c.es.BulkProcessor().
+		Name("publiccode-indexer").
+		Workers(1).
+		BulkActions(bulkActions).
+		FlushInterval(5 * time.Second).
+		After(func(executionID int64, requests []es.BulkableRequest, response *es.BulkResponse, err error) {
+			if err != nil {
+				log.Errorf("Error in ES bulk indexing: %v", err)
+				return
+			}
+			for _, item := range response.Failed() {
+				log.Errorf("Error indexing %s/%s in ES: %v", item.Index, item.Id, item.Error)
+			}
+		}).
+		Do(context.Background())
 }
 
 // generateID generates a hash based on unique git repo URL.
@@ -1,21 +1,27 @@
 package crawler
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
 	"github.com/italia/developers-italia-backend/crawler/metrics"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
-// SaveToFile save the chosen <file_name> in DATADIR/repos/<source>/<vendor>/<repo>/<crawler_timestamp>_<file_name>.
-func SaveToFile(domain Domain, hostname string, name string, data []byte, index string) error {
+// SaveToFile save the chosen <file_name> in <source>/<vendor>/<repo>/<crawler_timestamp>_<file_name>
+// of the configured storage (by default DATADIR). The source is the folder of the repository: the id of its
+// domain if set, otherwise its hostname.
+func SaveToFile(domain Domain, hostname, name, filename string, data []byte, index string) error {
 	if domain.Host == "" {
 		return errors.New("cannot save a file without domain host")
 	}
@@ -23,32 +29,157 @@ func SaveToFile(domain Domain, hostname string, name string, data []byte, index
 		return errors.New("cannot save a file without name")
 	}
 
-	fileName := index + "_" + viper.GetString("CRAWLED_FILENAME")
-	vendor, repo := splitFullName(name)
-
-	path := filepath.Join(viper.GetString("CRAWLER_DATADIR"), hostname, vendor, repo)
+	key := savedFileKey(hostname, name, filename, index)
+	err := fileStorage.Save(key, data)
+	if err != nil && err != errFileUnchanged {
+		return err
+	}
 
-	// MkdirAll will create all the folder path, if not exists.
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		err := os.MkdirAll(path, os.ModePerm)
-		if err != nil {
+	// Keep the checksums of the files in the data directory, to verify them with VerifyManifest.
+	if _, ok := fileStorage.(localStorage); ok {
+		if err := savedManifest.update(key, data); err != nil {
 			return err
 		}
 	}
 
-	err := ioutil.WriteFile(filepath.Join(path, fileName), data, 0644)
+	if err == errFileUnchanged {
+		metrics.GetCounter("repository_file_unchanged", index).Inc()
+		return nil
+	}
+	metrics.GetCounter("repository_file_saved", index).Inc()
+	return nil
+}
+
+// savedFileKey returns the path of the file saved by SaveToFile, relative to the storage root.
+func savedFileKey(hostname, name, filename, index string) string {
+	vendor, repo := splitFullName(name)
+	return filepath.Join(hostname, vendor, repo, index+"_"+filename)
+}
+
+// savedFilePath returns the local path of the file saved by SaveToFile.
+func savedFilePath(hostname, name, filename, index string) string {
+	return filepath.Join(viper.GetString("CRAWLER_DATADIR"), savedFileKey(hostname, name, filename, index))
+}
+
+// fileUnchanged returns true if filePath exists and its sidecar hash file matches hash.
+func fileUnchanged(filePath, hash string) bool {
+	if _, err := os.Stat(filePath); err != nil {
+		return false
+	}
+	stored, err := ioutil.ReadFile(filePath + ".sha256")
+	if err != nil {
+		return false
+	}
+
+	return string(stored) == hash
+}
+
+// fileMetadata is the provenance of a saved file, written next to it with WRITE_METADATA.
+type fileMetadata struct {
+	Source      string    `json:"source"`
+	RawURL      string    `json:"raw_url"`
+	CrawledAt   time.Time `json:"crawled_at"`
+	HTTPStatus  int       `json:"http_status"`
+	ContentHash string    `json:"content_hash"`
+}
+
+// fileMetadataPath returns the path of the sidecar file with the provenance of the saved file.
+func fileMetadataPath(repository Repository, index string) string {
+	return savedFilePath(repository.folder(), repository.Name, repository.filename(), index) + ".meta.json"
+}
+
+// saveFileMetadata writes the provenance of the file of the repository, fetched at crawledAt with resp,
+// in <saved file>.meta.json. Like the cache validators, it's kept in DATADIR whatever the configured storage is.
+func saveFileMetadata(repository Repository, index string, resp httpclient.HTTPResponse, crawledAt time.Time) error {
+	data, err := json.MarshalIndent(fileMetadata{
+		Source:      repository.Hostname,
+		RawURL:      repository.FileRawURL,
+		CrawledAt:   crawledAt,
+		HTTPStatus:  resp.Status.Code,
+		ContentHash: contentKey(resp.Body),
+	}, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	metrics.GetCounter("repository_file_saved", index).Inc()
-	return err
+	filePath := fileMetadataPath(repository, index)
+	err = os.MkdirAll(filepath.Dir(filePath), dirMode())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filePath, data, fileMode())
 }
 
+// fileMode returns the permissions of the files written in the data directory (FILE_MODE), by default 0644.
+func fileMode() os.FileMode {
+	mode, _ := configuredMode("FILE_MODE", 0644)
+	return mode
+}
+
+// dirMode returns the permissions of the directories created in the data directory (DIR_MODE),
+// by default 0777. Unlike the files ones they are restricted by the umask.
+func dirMode() os.FileMode {
+	mode, _ := configuredMode("DIR_MODE", os.ModePerm)
+	return mode
+}
+
+// configuredMode returns the permissions set in key as an octal number (e.g. "0640"), def if unset or invalid.
+func configuredMode(key string, def os.FileMode) (os.FileMode, error) {
+	value := viper.GetString(key)
+	if value == "" {
+		return def, nil
+	}
+
+	mode, err := strconv.ParseUint(strings.TrimPrefix(value, "0o"), 8, 32)
+	if err != nil || mode > uint64(os.ModePerm) {
+		return def, fmt.Errorf("invalid %s %q, expected octal permissions (e.g. \"0640\")", key, value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory of filename and
+// then renames it to filename, so that readers never see a partially written file.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	// Remove the temporary file if anything goes wrong before the rename.
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), perm)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// unknownVendor is the vendor used for the repositories whose name has no vendor part.
+const unknownVendor = "_unknown_vendor"
+
 // splitFullName split a git FullName format to vendor and repo strings.
+// The vendor is the whole namespace, so that nested Gitlab subgroups
+// (group/subgroup/team/project) are preserved, and the repo is the last segment.
+// Names without a slash are assigned to unknownVendor.
 func splitFullName(fullName string) (string, string) {
-	s := strings.Split(fullName, "/")
-	return s[0], s[1]
+	fullName = strings.Trim(fullName, "/")
+	i := strings.LastIndex(fullName, "/")
+	if i == -1 {
+		return unknownVendor, fullName
+	}
+	return fullName[:i], fullName[i+1:]
 }
 
 // Save the bad publiccode.yaml url to a file used by the publiccode-issueopener script.
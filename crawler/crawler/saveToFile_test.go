@@ -0,0 +1,141 @@
+package crawler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+	"github.com/spf13/viper"
+)
+
+// TestWriteFileAtomic checks that the file is written with the given mode and no temporary files are left.
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "publiccode.yml")
+	data := []byte("publiccodeYmlVersion: \"0.2\"\n")
+
+	for i := 0; i < 2; i++ {
+		if err := writeFileAtomic(filename, data, 0644); err != nil {
+			t.Fatalf("writeFileAtomic returned an error: %v", err)
+		}
+	}
+
+	read, err := ioutil.ReadFile(filename)
+	if err != nil || string(read) != string(data) {
+		t.Errorf("Expected %q, got %q (%v)", data, read, err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("Expected mode 0644, got %v", info.Mode().Perm())
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected only the saved file in %s, got %d files", dir, len(files))
+	}
+}
+
+// TestSplitFullName checks the vendor and repo extracted from a git FullName.
+func TestSplitFullName(t *testing.T) {
+	names := []struct {
+		in     string
+		vendor string
+		repo   string
+	}{
+		{"developers-italia-backend", unknownVendor, "developers-italia-backend"},
+		{"italia/developers-italia-backend", "italia", "developers-italia-backend"},
+		{"/italia/developers-italia-backend/", "italia", "developers-italia-backend"},
+		{"group/subgroup/project", "group/subgroup", "project"},
+		{"group/subgroup/team/project", "group/subgroup/team", "project"},
+	}
+
+	for _, n := range names {
+		if vendor, repo := splitFullName(n.in); vendor != n.vendor || repo != n.repo {
+			t.Errorf("Expected %s == %s/%s, got %s/%s", n.in, n.vendor, n.repo, vendor, repo)
+		}
+	}
+}
+
+// TestConfiguredMode checks that FILE_MODE and DIR_MODE are parsed as octal, with the defaults if unset.
+func TestConfiguredMode(t *testing.T) {
+	defer viper.Set("FILE_MODE", nil)
+
+	modes := []struct {
+		value string
+		mode  os.FileMode
+		valid bool
+	}{
+		{"", 0644, true},
+		{"0640", 0640, true},
+		{"600", 0600, true},
+		{"0o750", 0750, true},
+		{"0986", 0644, false},
+		{"rw-r-----", 0644, false},
+		{"01777", 0644, false},
+	}
+
+	for _, m := range modes {
+		viper.Set("FILE_MODE", m.value)
+		mode, err := configuredMode("FILE_MODE", 0644)
+		if mode != m.mode || (err == nil) != m.valid {
+			t.Errorf("Expected %q == %o, got %o (%v)", m.value, m.mode, mode, err)
+		}
+	}
+	if dirMode() != os.ModePerm {
+		t.Errorf("Expected the default DIR_MODE %o, got %o", os.ModePerm, dirMode())
+	}
+}
+
+// TestSaveFileMetadata checks the provenance written next to the saved file.
+func TestSaveFileMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("CRAWLER_DATADIR", dir)
+	defer viper.Set("CRAWLER_DATADIR", nil)
+
+	repository := Repository{
+		Name:       "italia/developers-italia-backend",
+		Hostname:   "github.com",
+		FileRawURL: "https://raw.githubusercontent.com/italia/developers-italia-backend/master/publiccode.yml",
+		Domain:     Domain{Host: "github.com"},
+		Filename:   "publiccode.yml",
+	}
+	resp := httpclient.HTTPResponse{Body: []byte("name: Medusa\n"), Status: httpclient.ResponseStatus{Code: http.StatusOK}}
+	crawledAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := saveFileMetadata(repository, "test", resp, crawledAt); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "github.com", "italia", "developers-italia-backend", "test_publiccode.yml.meta.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metadata fileMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatal(err)
+	}
+	expected := fileMetadata{
+		Source:      "github.com",
+		RawURL:      repository.FileRawURL,
+		CrawledAt:   crawledAt,
+		HTTPStatus:  http.StatusOK,
+		ContentHash: contentKey(resp.Body),
+	}
+	if metadata != expected {
+		t.Errorf("Expected %+v, got %+v", expected, metadata)
+	}
+}
@@ -0,0 +1,222 @@
+package crawler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// seenRepositories are the repositories found (or not reachable) in this crawl, whose saved files must be kept.
+type seenRepositories struct {
+	sync.Mutex
+	dirs map[string]bool
+	// Directories of the owners (organizations or users) of the repositories seen, the parents of dirs.
+	owners map[string]bool
+	// Repositories as <source>/<name>, see seenKey.
+	keys map[string]bool
+	// Number of organizations or repositories that could not be listed.
+	listErrors int32
+	// Number of repositories listed but not processed: over MAX_REPOS_PER_DOMAIN or abandoned after REPO_TIMEOUT.
+	unprocessed int32
+	// Hosts of the domains not crawled completely: with list errors, repositories not processed or not fetched.
+	failedHosts map[string]bool
+}
+
+// seenKey returns the key of repository in the repositories seen, <source>/<name> (e.g. github.com/italia/repo).
+func seenKey(repository Repository) string {
+	return repository.folder() + "/" + repository.Name
+}
+
+// add marks repository, and its directory, as seen.
+func (s *seenRepositories) add(repository Repository) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.dirs == nil {
+		s.dirs = make(map[string]bool)
+		s.owners = make(map[string]bool)
+		s.keys = make(map[string]bool)
+	}
+	dir := filepath.Dir(savedFilePath(repository.folder(), repository.Name, repository.filename(), ""))
+	s.dirs[dir] = true
+	s.owners[filepath.Dir(dir)] = true
+	s.keys[seenKey(repository)] = true
+}
+
+// listed returns true if dir is the directory of a repository of an owner listed in this crawl,
+// one with some repository seen.
+func (s *seenRepositories) listed(dir string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.owners[filepath.Dir(dir)]
+}
+
+// seen returns true if dir is the directory of a repository seen in this crawl.
+func (s *seenRepositories) seen(dir string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.dirs[dir]
+}
+
+// has returns true if repository was seen in this crawl.
+func (s *seenRepositories) has(repository Repository) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.keys[seenKey(repository)]
+}
+
+// list returns the keys of the repositories seen, sorted.
+func (s *seenRepositories) list() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	keys := make([]string, 0, len(s.keys))
+	for key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// count returns the number of repositories seen.
+func (s *seenRepositories) count() int {
+	s.Lock()
+	defer s.Unlock()
+
+	return len(s.dirs)
+}
+
+// listError records an organization or repository of the domain host that could not be listed.
+func (s *seenRepositories) listError(host string) {
+	atomic.AddInt32(&s.listErrors, 1)
+	s.fail(host)
+}
+
+// notProcessed records a repository, or the following pages, of the domain host that were not processed.
+func (s *seenRepositories) notProcessed(host string) {
+	atomic.AddInt32(&s.unprocessed, 1)
+	s.fail(host)
+}
+
+// fail records that the domain host was not crawled completely, e.g. a file could not be fetched.
+func (s *seenRepositories) fail(host string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.failedHosts == nil {
+		s.failedHosts = make(map[string]bool)
+	}
+	s.failedHosts[host] = true
+}
+
+// failed returns true if the domain host was not crawled completely, see fail.
+func (s *seenRepositories) failed(host string) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.failedHosts[host]
+}
+
+// crawlComplete returns true if all the existing repositories were seen in this crawl, so that the ones not seen
+// were removed. Otherwise it returns false with the reason: no repository was seen, some could not be listed or
+// processed, or only the updated ones were crawled (incremental mode).
+func (c *Crawler) crawlComplete() (bool, string) {
+	if c.seen.count() == 0 {
+		return false, "no repository seen in this crawl"
+	}
+	if n := atomic.LoadInt32(&c.seen.listErrors); n > 0 {
+		return false, fmt.Sprintf("%d organizations or repositories could not be listed", n)
+	}
+	if n := atomic.LoadInt32(&c.seen.unprocessed); n > 0 {
+		return false, fmt.Sprintf("%d repositories (or pages) were not processed, by MAX_REPOS_PER_DOMAIN or REPO_TIMEOUT", n)
+	}
+	if incrementalEnabled() {
+		return false, "only the updated repositories are crawled in incremental mode"
+	}
+	return true, ""
+}
+
+// seenRun are the repositories seen in a complete crawl, written in DATADIR/seen.json.
+type seenRun struct {
+	RunID        string   `json:"run_id"`
+	Repositories []string `json:"repositories"`
+}
+
+func seenRunPath() string {
+	return filepath.Join(viper.GetString("CRAWLER_DATADIR"), "seen.json")
+}
+
+// loadSeenRun returns the repositories seen in the previous crawl, none if it was never recorded.
+func loadSeenRun() (seenRun, error) {
+	var run seenRun
+	data, err := ioutil.ReadFile(seenRunPath())
+	if os.IsNotExist(err) {
+		return run, nil
+	}
+	if err != nil {
+		return run, err
+	}
+	err = json.Unmarshal(data, &run)
+	return run, err
+}
+
+// diffSeen returns the repositories in current and not in previous (added), and the ones in previous
+// and not in current (removed). Both lists must be sorted.
+func diffSeen(previous, current []string) (added, removed []string) {
+	i, j := 0, 0
+	for i < len(previous) || j < len(current) {
+		switch {
+		case j == len(current) || (i < len(previous) && previous[i] < current[j]):
+			removed = append(removed, previous[i])
+			i++
+		case i == len(previous) || current[j] < previous[i]:
+			added = append(added, current[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return added, removed
+}
+
+// saveSeen compares the repositories seen in the crawl started at start with the ones of the previous crawl,
+// and records them for the next one. Nothing is recorded if the crawl is incomplete, see crawlComplete.
+func (c *Crawler) saveSeen(start time.Time) error {
+	if complete, reason := c.crawlComplete(); !complete {
+		log.Warnf("%s, the repositories seen are not recorded", reason)
+		return nil
+	}
+
+	previous, err := loadSeenRun()
+	if err != nil {
+		return err
+	}
+	run := seenRun{RunID: start.UTC().Format("20060102T150405Z"), Repositories: c.seen.list()}
+	if previous.RunID != "" {
+		added, removed := diffSeen(previous.Repositories, run.Repositories)
+		log.WithFields(log.Fields{
+			"previous_run": previous.RunID,
+			"added":        len(added),
+			"removed":      len(removed),
+		}).Info("repositories seen compared with the previous crawl")
+	}
+
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(seenRunPath(), data, fileMode())
+}
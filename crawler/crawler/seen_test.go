@@ -0,0 +1,112 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+func TestDiffSeen(t *testing.T) {
+	tests := []struct {
+		previous []string
+		current  []string
+		added    []string
+		removed  []string
+	}{
+		{nil, nil, nil, nil},
+		{nil, []string{"a"}, []string{"a"}, nil},
+		{[]string{"a"}, nil, nil, []string{"a"}},
+		{[]string{"a", "b", "d"}, []string{"b", "c", "d", "e"}, []string{"c", "e"}, []string{"a"}},
+	}
+
+	for _, test := range tests {
+		added, removed := diffSeen(test.previous, test.current)
+		if !reflect.DeepEqual(added, test.added) || !reflect.DeepEqual(removed, test.removed) {
+			t.Errorf("Expected %v added and %v removed from %v to %v, got %v and %v",
+				test.added, test.removed, test.previous, test.current, added, removed)
+		}
+	}
+}
+
+// TestSaveSeen checks that the repositories seen are recorded only for the complete crawls.
+func TestSaveSeen(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "crawler")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	viper.Set("CRAWLER_DATADIR", dir)
+	defer viper.Set("CRAWLER_DATADIR", nil)
+
+	var c Crawler
+	repository := Repository{Hostname: "github.com", Name: "italia/repo"}
+	c.seen.add(repository)
+	c.seen.add(Repository{Hostname: "gitlab.com", Name: "italia/repo", Domain: Domain{ID: "gitlab"}})
+	if !c.seen.has(repository) || c.seen.has(Repository{Hostname: "github.com", Name: "italia/other"}) {
+		t.Errorf("Unexpected repositories seen %v", c.seen.list())
+	}
+
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := c.saveSeen(start); err != nil {
+		t.Fatal(err)
+	}
+	run, err := loadSeenRun()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := seenRun{RunID: "20200102T030405Z", Repositories: []string{"github.com/italia/repo", "gitlab/italia/repo"}}
+	if !reflect.DeepEqual(run, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, run)
+	}
+
+	// The incomplete crawls keep the previous one: with a listing error, in incremental mode or sampled.
+	for _, incomplete := range []func(c *Crawler){
+		func(c *Crawler) { c.seen.listError("github.com") },
+		func(c *Crawler) { viper.Set("CRAWL_MODE", "incremental") },
+		func(c *Crawler) { c.seen.notProcessed("github.com") },
+	} {
+		var c Crawler
+		c.seen.add(repository)
+		incomplete(&c)
+		err := c.saveSeen(start.Add(time.Hour))
+		viper.Set("CRAWL_MODE", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if run, _ := loadSeenRun(); run.RunID != expected.RunID {
+			t.Errorf("Expected the run %s kept, got %s", expected.RunID, run.RunID)
+		}
+	}
+}
+
+// TestCrawlOrgListError checks that the organizations whose API urls cannot be generated are list errors.
+func TestCrawlOrgListError(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	clientAPIs["test"] = ClientAPI{
+		Organization: func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) (string, error) {
+			return "", nil
+		},
+		APIURL: func(in string) ([]string, error) {
+			return nil, errors.New("invalid url")
+		},
+	}
+	defer delete(clientAPIs, "test")
+
+	c := Crawler{ctx: context.Background()}
+	c.CrawlOrg("https://git.example.org/italia", &Domain{Host: "git.example.org", Type: "test"}, PA{})
+	if complete, _ := c.crawlComplete(); complete || c.seen.listErrors != 1 {
+		t.Errorf("Expected a list error, got %d", c.seen.listErrors)
+	}
+}
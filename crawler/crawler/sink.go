@@ -0,0 +1,184 @@
+package crawler
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	// Postgres driver for database/sql.
+	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+)
+
+// RepositoryRecord is the metadata of a crawled repository, sent to the Sink.
+type RepositoryRecord struct {
+	Source      string
+	Name        string
+	RawURL      string
+	Valid       bool
+	LastSeen    time.Time
+	ContentHash string
+}
+
+// Sink receives the metadata of the crawled repositories, in addition to the saved files.
+// It's not a Storage: a Storage keeps the content of the files by path, in place of DATADIR, while a Sink
+// gets one record for each processed repository, also the invalid ones that have no file saved, and
+// writes them in batches to a database, next to the storage.
+type Sink interface {
+	// Add queues record, it doesn't block on the writes. The records added after Close are dropped.
+	Add(record RepositoryRecord)
+	// Close writes the queued records and releases the sink.
+	Close() error
+}
+
+const (
+	postgresSinkBatchSize     = 100
+	postgresSinkFlushInterval = 5 * time.Second
+
+	postgresSinkSchema = `CREATE TABLE IF NOT EXISTS repositories (
+	source       text        NOT NULL,
+	name         text        NOT NULL,
+	raw_url      text        NOT NULL,
+	valid        boolean     NOT NULL,
+	last_seen    timestamptz NOT NULL,
+	content_hash text        NOT NULL,
+	PRIMARY KEY (source, name)
+)`
+	postgresSinkUpsert = `INSERT INTO repositories (source, name, raw_url, valid, last_seen, content_hash)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (source, name) DO UPDATE SET
+	raw_url = EXCLUDED.raw_url,
+	valid = EXCLUDED.valid,
+	last_seen = EXCLUDED.last_seen,
+	content_hash = EXCLUDED.content_hash`
+)
+
+// postgresSink upserts the records in the repositories table of a Postgres database, by (source, name).
+// The records are written in batches by a single goroutine.
+type postgresSink struct {
+	db      *sql.DB
+	records chan RepositoryRecord
+	done    chan error
+
+	// Guards records against the Add after Close, e.g. from a repository abandoned after REPO_TIMEOUT.
+	mu     sync.Mutex
+	closed bool
+	err    error
+}
+
+// NewPostgresSink connects to the Postgres database at dsn, creates the repositories table
+// if needed and starts the writer.
+func NewPostgresSink(dsn string) (Sink, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(postgresSinkSchema)
+	if err != nil {
+		db.Close() // nolint: errcheck
+		return nil, err
+	}
+
+	s := &postgresSink{
+		db:      db,
+		records: make(chan RepositoryRecord, 1000),
+		done:    make(chan error, 1),
+	}
+	go s.run()
+
+	return s, nil
+}
+
+// Add queues record for the next batch, or drops it if the sink is closed.
+func (s *postgresSink) Add(record RepositoryRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		log.Warnf("Postgres sink closed, record of %s dropped", record.Name)
+		return
+	}
+	s.records <- record
+}
+
+// Close writes the last batch and closes the database. The next calls return the same error.
+func (s *postgresSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return s.err
+	}
+	s.closed = true
+
+	close(s.records)
+	s.err = <-s.done
+	if closeErr := s.db.Close(); s.err == nil {
+		s.err = closeErr
+	}
+	return s.err
+}
+
+// run writes the records in batches of postgresSinkBatchSize, or every postgresSinkFlushInterval,
+// until the records channel is closed. The first error is sent to done.
+func (s *postgresSink) run() {
+	ticker := time.NewTicker(postgresSinkFlushInterval)
+	defer ticker.Stop()
+
+	var firstErr error
+	batch := make([]RepositoryRecord, 0, postgresSinkBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.write(batch); err != nil {
+			log.Errorf("Error writing %d records to Postgres: %v", len(batch), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case record, ok := <-s.records:
+			if !ok {
+				flush()
+				s.done <- firstErr
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= postgresSinkBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// write upserts the records in a single transaction.
+func (s *postgresSink) write(records []RepositoryRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(postgresSinkUpsert)
+	if err != nil {
+		tx.Rollback() // nolint: errcheck
+		return err
+	}
+	defer stmt.Close() // nolint: errcheck
+
+	for _, r := range records {
+		_, err = stmt.Exec(r.Source, r.Name, r.RawURL, r.Valid, r.LastSeen, r.ContentHash)
+		if err != nil {
+			tx.Rollback() // nolint: errcheck
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,32 @@
+package crawler
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TestPostgresSinkAddAfterClose checks that the records added after Close are dropped, and that Close can be
+// called again.
+func TestPostgresSinkAddAfterClose(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	// The database is not connected until a record is written.
+	db, err := sql.Open("postgres", "postgres://localhost/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &postgresSink{db: db, records: make(chan RepositoryRecord, 1), done: make(chan error, 1)}
+	go s.run()
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Unexpected error closing the sink: %v", err)
+	}
+	s.Add(RepositoryRecord{Name: "italia/repo"})
+	if err := s.Close(); err != nil {
+		t.Errorf("Unexpected error closing the sink again: %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package crawler
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+	"gopkg.in/yaml.v2"
+)
+
+// checkYAMLResponse returns an error if the response is obviously not a YAML file, e.g. an HTML
+// error page or a JSON error served with status 200 by a misconfigured endpoint. The error is an
+// ErrInvalidPublicCode.
+func checkYAMLResponse(resp httpclient.HTTPResponse) error {
+	if contentType := resp.Headers.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml" || mediaType == "application/json") {
+			return wrapError(ErrInvalidPublicCode, errors.New("unexpected content type "+mediaType))
+		}
+	}
+
+	if strings.HasPrefix(http.DetectContentType(resp.Body), "text/html") {
+		return wrapError(ErrInvalidPublicCode, errors.New("the body is HTML"))
+	}
+
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(resp.Body, &document); err != nil || document == nil {
+		return wrapError(ErrInvalidPublicCode, errors.New("the body is not a YAML mapping"))
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package crawler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+)
+
+func TestCheckYAMLResponse(t *testing.T) {
+	responses := []struct {
+		contentType string
+		body        string
+		valid       bool
+	}{
+		{"text/plain; charset=utf-8", "publiccodeYmlVersion: \"0.2\"\nname: Medusa\n", true},
+		{"", "publiccodeYmlVersion: \"0.2\"\n", true},
+		{"application/octet-stream", "# comment\npubliccodeYmlVersion: \"0.2\"\n", true},
+		{"text/html; charset=utf-8", "publiccodeYmlVersion: \"0.2\"\n", false},
+		{"text/plain", "<!DOCTYPE html><html><body>404 Not Found</body></html>", false},
+		{"application/json", `{"message": "Not Found"}`, false},
+		{"text/plain", "just some text", false},
+		{"text/plain", "", false},
+		{"text/plain", "key: [unterminated", false},
+	}
+
+	for _, r := range responses {
+		resp := httpclient.HTTPResponse{Body: []byte(r.body), Headers: http.Header{}}
+		if r.contentType != "" {
+			resp.Headers.Set("Content-Type", r.contentType)
+		}
+		if err := checkYAMLResponse(resp); (err == nil) != r.valid {
+			t.Errorf("Expected %q (%s) valid == %t, got %v", r.body, r.contentType, r.valid, err)
+		}
+	}
+}
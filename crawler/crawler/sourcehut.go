@@ -0,0 +1,299 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	sourcehutReposQuery = `query($username: String!, $cursor: Cursor) {
+  user(username: $username) {
+    repositories(cursor: $cursor) {
+      cursor
+      results { id name description visibility created updated HEAD { name } owner { canonicalName } }
+    }
+  }
+}`
+	sourcehutRepoQuery = `query($username: String!, $name: String!) {
+  user(username: $username) {
+    repository(name: $name) { id name description visibility created updated HEAD { name } owner { canonicalName } }
+  }
+}`
+)
+
+// SourcehutRepo is a repository from the git.sr.ht GraphQL API.
+type SourcehutRepo struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Visibility  string    `json:"visibility"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	HEAD        *struct {
+		Name string `json:"name"`
+	} `json:"HEAD"`
+	Owner struct {
+		CanonicalName string `json:"canonicalName"`
+	} `json:"owner"`
+}
+
+// SourcehutResponse is the complete result from the git.sr.ht GraphQL API.
+type SourcehutResponse struct {
+	Data struct {
+		User *struct {
+			Repositories struct {
+				Cursor  string          `json:"cursor"`
+				Results []SourcehutRepo `json:"results"`
+			} `json:"repositories"`
+			Repository *SourcehutRepo `json:"repository"`
+		} `json:"user"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// sourcehutQuery POSTs a GraphQL query to the git.sr.ht API at queryURL.
+func sourcehutQuery(ctx context.Context, queryURL, query string, variables map[string]interface{}, headers map[string]string) (SourcehutResponse, error) {
+	var result SourcehutResponse
+
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		return result, err
+	}
+
+	resp, err := postJSON(ctx, queryURL, headers, body)
+	if err != nil {
+		return result, err
+	}
+	if resp.Status.Code != http.StatusOK {
+		log.Warnf("Request returned: %s", string(resp.Body))
+		return result, errors.New("request returned an incorrect http.Status: " + resp.Status.Text)
+	}
+
+	err = json.Unmarshal(resp.Body, &result)
+	if err != nil {
+		return result, err
+	}
+	if len(result.Errors) > 0 {
+		return result, errors.New("graphql error: " + result.Errors[0].Message)
+	}
+	if result.Data.User == nil {
+		return result, errors.New("user not found")
+	}
+
+	return result, nil
+}
+
+// RegisterSourcehutAPI register the crawler function for git.sr.ht GraphQL API.
+// It get the list of repositories of the "owner" in the "link" url.
+// If a next page is available return its url, with the "cursor" of the page.
+// Otherwise returns an empty ("") string.
+func RegisterSourcehutAPI() OrganizationHandler {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) (string, error) {
+		// Set Authorization header.
+		headers := make(map[string]string)
+		auth, err := domain.authHeader()
+		if err != nil {
+			return link, err
+		}
+		if auth != "" {
+			headers["Authorization"] = auth
+		}
+
+		// Parse url.
+		u, err := url.Parse(link)
+		if err != nil {
+			return link, err
+		}
+		// Set domain host to new host.
+		domain.Host = u.Hostname()
+
+		q := u.Query()
+		variables := map[string]interface{}{"username": strings.TrimPrefix(q.Get("owner"), "~")}
+		if cursor := q.Get("cursor"); cursor != "" {
+			variables["cursor"] = cursor
+		}
+
+		queryURL := *u
+		queryURL.RawQuery = ""
+		results, err := sourcehutQuery(ctx, queryURL.String(), sourcehutReposQuery, variables, headers)
+		if err != nil {
+			return link, err
+		}
+
+		// Add repositories to the channel that will perform the check on everyone.
+		repos := results.Data.User.Repositories
+		for _, v := range repos.Results {
+			err = addSourcehutProjectToRepositories(v, domain, pa, headers, repositories)
+			if err != nil {
+				log.Infof("addSourcehutProjectToRepositories %v", err)
+			}
+		}
+
+		// if last page for this owner, the cursor is empty.
+		if repos.Cursor == "" {
+			return "", nil
+		}
+
+		// Return next url.
+		q.Set("cursor", repos.Cursor)
+		u.RawQuery = q.Encode()
+
+		return u.String(), nil
+	}
+}
+
+// RegisterSingleSourcehutAPI register the crawler function for single repository git.sr.ht GraphQL API.
+// Return nil if the repository was successfully added to repositories channel.
+// Otherwise return the generated error.
+func RegisterSingleSourcehutAPI() SingleRepoHandler {
+	return func(ctx context.Context, domain Domain, link string, repositories chan Repository, pa PA) error {
+		// Set Authorization header.
+		headers := make(map[string]string)
+		auth, err := domain.authHeader()
+		if err != nil {
+			return err
+		}
+		if auth != "" {
+			headers["Authorization"] = auth
+		}
+
+		// Parse url.
+		u, err := url.Parse(link)
+		if err != nil {
+			return err
+		}
+
+		// Set domain host to new host.
+		domain.Host = u.Hostname()
+
+		// IN: https://git.sr.ht/~owner/repo
+		parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(parts) != 2 {
+			return errors.New("invalid sourcehut repository url: " + link)
+		}
+		variables := map[string]interface{}{
+			"username": strings.TrimPrefix(parts[0], "~"),
+			"name":     strings.TrimSuffix(parts[1], ".git"),
+		}
+
+		u.Path = "query"
+		u.RawQuery = ""
+		result, err := sourcehutQuery(ctx, u.String(), sourcehutRepoQuery, variables, headers)
+		if err != nil {
+			return err
+		}
+
+		v := result.Data.User.Repository
+		if v == nil {
+			return errors.New("repository not found: " + link)
+		}
+		if v.HEAD == nil {
+			return errors.New("repository is empty: " + link)
+		}
+
+		return addSourcehutProjectToRepositories(*v, domain, pa, headers, repositories)
+	}
+}
+
+// generateSourcehutRawURL returns the file git.sr.ht specific file raw url.
+// IN: https://git.sr.ht/~owner/repo
+// OUT: https://git.sr.ht/~owner/repo/blob/master/publiccode.yml
+func generateSourcehutRawURL(repoURL, defaultBranch string) (string, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, "blob", defaultBranch, viper.GetString("CRAWLED_FILENAME"))
+
+	return u.String(), err
+}
+
+// addSourcehutProjectToRepositories adds the project from api response to repository channel.
+func addSourcehutProjectToRepositories(v SourcehutRepo, domain Domain, pa PA, headers map[string]string, repositories chan Repository) error {
+	// If the repository was never used, there is no HEAD to look into.
+	if v.HEAD == nil || v.Visibility == "PRIVATE" {
+		return nil
+	}
+	branch := strings.TrimPrefix(v.HEAD.Name, "refs/heads/")
+	name := v.Owner.CanonicalName + "/" + v.Name
+	repoURL := "https://" + domain.Host + "/" + name
+
+	// Join file raw URL string.
+	rawURL, err := generateSourcehutRawURL(repoURL, branch)
+	if err != nil {
+		return err
+	}
+
+	// Marshal all the repository metadata.
+	metadata, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("sourcehut metadata: %v", err)
+		return err
+	}
+
+	repositories <- Repository{
+		Name:        name,
+		Hostname:    domain.Host,
+		FileRawURL:  rawURL,
+		GitCloneURL: repoURL,
+		GitBranch:   branch,
+		Domain:      domain,
+		Pa:          pa,
+		Headers:     headers,
+		RawHeaders:  domain.RawHeaders,
+		Metadata:    metadata,
+	}
+
+	return nil
+}
+
+// GenerateSourcehutAPIURL returns the api url of given git.sr.ht owner link.
+// IN: https://git.sr.ht/~italia
+// OUT:https://git.sr.ht/query?owner=~italia
+func GenerateSourcehutAPIURL() GeneratorAPIURL {
+	return func(in string) (out []string, err error) {
+		u, err := url.Parse(in)
+		if err != nil {
+			return []string{in}, err
+		}
+		owner := strings.Trim(u.Path, "/")
+		u.Path = "query"
+		u.RawQuery = url.Values{"owner": []string{owner}}.Encode()
+
+		out = append(out, u.String())
+		return
+	}
+}
+
+// IsSourcehut returns "true" if the url can use git.sr.ht API.
+func IsSourcehut(link string) bool {
+	if len(link) == 0 {
+		log.Errorf("IsSourcehut: empty link %s.", link)
+		return false
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		log.Errorf("IsSourcehut: impossible to parse %s.", link)
+		return false
+	}
+
+	if u.Hostname() == "git.sr.ht" {
+		log.Debugf("can %s use sourcehut API? Yes.", link)
+		return true
+	}
+
+	log.Debugf("can %s use sourcehut API? No.", link)
+	return false
+}
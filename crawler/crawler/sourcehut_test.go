@@ -0,0 +1,33 @@
+package crawler
+
+import (
+	"io/ioutil"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GenerateSourcehutAPIURL returns the api url of given git.sr.ht owner link.
+// IN: https://git.sr.ht/~italia
+// OUT:https://git.sr.ht/query?owner=~italia
+func TestGenerateSourcehutAPIURL(t *testing.T) {
+	// Disablle log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	links := []struct {
+		in  string
+		out string
+	}{
+		{"https://git.sr.ht/~italia", "https://git.sr.ht/query?owner=~italia"},
+		{"https://git.sr.ht/~italia/", "https://git.sr.ht/query?owner=~italia"},
+		{":unparsable", ":unparsable"},
+	}
+
+	for _, l := range links {
+		genURL := GenerateSourcehutAPIURL()
+		if out, err := genURL(l.in); out[0] != l.out {
+			t.Logf("Expected %s == %s: %v ", out[0], l.out, err)
+			t.Fail()
+		}
+	}
+}
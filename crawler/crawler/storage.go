@@ -0,0 +1,87 @@
+package crawler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	minio "github.com/minio/minio-go/v6"
+	"github.com/spf13/viper"
+)
+
+// Storage is where the crawled files are saved.
+type Storage interface {
+	// Save stores data at path, relative to the storage root.
+	Save(path string, data []byte) error
+}
+
+// errFileUnchanged is returned by Storage.Save when the stored file has the same content.
+var errFileUnchanged = errors.New("file unchanged")
+
+// fileStorage is the storage configured by NewStorage, used by SaveToFile.
+var fileStorage Storage = localStorage{}
+
+// NewStorage returns the storage configured in STORAGE: "local" (default) or "s3".
+func NewStorage() (Storage, error) {
+	switch viper.GetString("STORAGE") {
+	case "", "local":
+		return localStorage{}, nil
+	case "s3":
+		client, err := minio.New(
+			viper.GetString("S3_ENDPOINT"),
+			viper.GetString("S3_ACCESS_KEY"),
+			viper.GetString("S3_SECRET_KEY"),
+			!viper.GetBool("S3_INSECURE"))
+		if err != nil {
+			return nil, err
+		}
+		return s3Storage{client: client, bucket: viper.GetString("S3_BUCKET")}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage %s", viper.GetString("STORAGE"))
+	}
+}
+
+// localStorage saves the files in CRAWLER_DATADIR.
+// A sidecar <path>.sha256 keeps the content hash, so unchanged files are not rewritten.
+type localStorage struct{}
+
+// Save stores data in CRAWLER_DATADIR/path.
+func (localStorage) Save(path string, data []byte) error {
+	filePath := filepath.Join(viper.GetString("CRAWLER_DATADIR"), path)
+
+	// MkdirAll will create all the folder path, if not exists.
+	err := os.MkdirAll(filepath.Dir(filePath), dirMode())
+	if err != nil {
+		return err
+	}
+
+	// Skip the write if the content didn't change since the last crawl.
+	hash := fmt.Sprintf("%x", sha256.Sum256(data))
+	if fileUnchanged(filePath, hash) {
+		return errFileUnchanged
+	}
+
+	err = writeFileAtomic(filePath, data, fileMode())
+	if err != nil {
+		return err
+	}
+
+	// Store the content hash in a sidecar file, used by the next crawls.
+	return writeFileAtomic(filePath+".sha256", []byte(hash), fileMode())
+}
+
+// s3Storage saves the files in an S3 compatible bucket.
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+}
+
+// Save stores data in the bucket, using path as the object name.
+func (s s3Storage) Save(path string, data []byte) error {
+	_, err := s.client.PutObject(s.bucket, filepath.ToSlash(path), bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/x-yaml"})
+	return err
+}
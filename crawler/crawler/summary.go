@@ -0,0 +1,167 @@
+package crawler
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// summaryCounts are the outcomes of the repositories processed in a crawl.
+type summaryCounts struct {
+	Repositories int `json:"repositories"`
+	Found        int `json:"found"`
+	Saved        int `json:"saved"`
+	Valid        int `json:"valid"`
+	Invalid      int `json:"invalid"`
+	Failed       int `json:"failed"`
+}
+
+// plus returns the sum of the counts.
+func (s summaryCounts) plus(o summaryCounts) summaryCounts {
+	return summaryCounts{
+		Repositories: s.Repositories + o.Repositories,
+		Found:        s.Found + o.Found,
+		Saved:        s.Saved + o.Saved,
+		Valid:        s.Valid + o.Valid,
+		Invalid:      s.Invalid + o.Invalid,
+		Failed:       s.Failed + o.Failed,
+	}
+}
+
+// fields returns the counts as log fields.
+func (s summaryCounts) fields() log.Fields {
+	return log.Fields{
+		"repositories": s.Repositories,
+		"found":        s.Found,
+		"saved":        s.Saved,
+		"valid":        s.Valid,
+		"invalid":      s.Invalid,
+		"failed":       s.Failed,
+	}
+}
+
+// validationCount returns the update of the counts for the validation outcome errs.
+func validationCount(errs ValidationErrors) func(*summaryCounts) {
+	return func(s *summaryCounts) {
+		if len(errs) == 0 {
+			s.Valid++
+		} else {
+			s.Invalid++
+		}
+	}
+}
+
+// crawlSummary collects the outcomes of a crawl, by domain.
+type crawlSummary struct {
+	sync.Mutex
+	start   time.Time
+	domains map[string]*summaryCounts
+	// Vendors with at least a valid file, as <domain>/<vendor>.
+	vendors map[string]bool
+}
+
+// crawlSummaryFile is the summary written in DATADIR/summary.json.
+type crawlSummaryFile struct {
+	Start    time.Time                `json:"start"`
+	Duration string                   `json:"duration"`
+	Domains  int                      `json:"domains"`
+	Vendors  int                      `json:"vendors"`
+	Total    summaryCounts            `json:"total"`
+	ByDomain map[string]summaryCounts `json:"by_domain"`
+}
+
+// begin resets the summary and starts measuring the crawl duration.
+func (s *crawlSummary) begin() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.start = time.Now()
+	s.domains = make(map[string]*summaryCounts)
+	s.vendors = make(map[string]bool)
+}
+
+// validVendor records the vendor (see splitFullName) of the repository name of domain with a valid file,
+// and returns the number of distinct vendors with a valid file so far.
+func (s *crawlSummary) validVendor(domain, name string) int {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.vendors == nil {
+		s.vendors = make(map[string]bool)
+	}
+	vendor, _ := splitFullName(name)
+	s.vendors[domain+"/"+vendor] = true
+	return len(s.vendors)
+}
+
+// count updates the counts of domain with f.
+func (s *crawlSummary) count(domain string, f func(*summaryCounts)) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.domains == nil {
+		s.domains = make(map[string]*summaryCounts)
+	}
+	counts, ok := s.domains[domain]
+	if !ok {
+		counts = &summaryCounts{}
+		s.domains[domain] = counts
+	}
+	f(counts)
+}
+
+// snapshot returns the summary of the crawl so far.
+func (s *crawlSummary) snapshot() crawlSummaryFile {
+	s.Lock()
+	defer s.Unlock()
+
+	summary := crawlSummaryFile{
+		Start:    s.start,
+		Duration: time.Since(s.start).Round(time.Second).String(),
+		Domains:  len(s.domains),
+		Vendors:  len(s.vendors),
+		ByDomain: make(map[string]summaryCounts, len(s.domains)),
+	}
+	for domain, counts := range s.domains {
+		summary.Total = summary.Total.plus(*counts)
+		summary.ByDomain[domain] = *counts
+	}
+
+	return summary
+}
+
+// log logs the summary of the crawl, globally and for every domain.
+func (s *crawlSummary) log() crawlSummaryFile {
+	summary := s.snapshot()
+
+	domains := make([]string, 0, len(summary.ByDomain))
+	for domain := range summary.ByDomain {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		log.WithFields(summary.ByDomain[domain].fields()).WithField("domain", domain).Info("crawl summary")
+	}
+	log.WithFields(summary.Total.fields()).WithFields(log.Fields{
+		"domains":  summary.Domains,
+		"vendors":  summary.Vendors,
+		"duration": summary.Duration,
+	}).Info("crawl summary")
+
+	return summary
+}
+
+// save writes the summary in DATADIR/summary.json.
+func (summary crawlSummaryFile) save() error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(viper.GetString("CRAWLER_DATADIR"), "summary.json"), data, fileMode())
+}
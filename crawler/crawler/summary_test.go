@@ -0,0 +1,81 @@
+package crawler
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestCrawlSummary(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	var s crawlSummary
+	s.begin()
+
+	s.count("github.com", func(c *summaryCounts) { c.Repositories++ })
+	s.count("github.com", func(c *summaryCounts) { c.Repositories++ })
+	s.count("github.com", validationCount(nil))
+	s.count("gitlab.com", func(c *summaryCounts) { c.Repositories++ })
+	s.count("gitlab.com", validationCount(ValidationErrors{{Reason: "invalid"}}))
+	s.count("gitlab.com", func(c *summaryCounts) { c.Failed++ })
+
+	summary := s.log()
+	if summary.Domains != 2 {
+		t.Errorf("expected 2 domains, got %d", summary.Domains)
+	}
+	expected := summaryCounts{Repositories: 3, Valid: 1, Invalid: 1, Failed: 1}
+	if summary.Total != expected {
+		t.Errorf("expected total %+v, got %+v", expected, summary.Total)
+	}
+	expected = summaryCounts{Repositories: 1, Invalid: 1, Failed: 1}
+	if summary.ByDomain["gitlab.com"] != expected {
+		t.Errorf("expected gitlab.com %+v, got %+v", expected, summary.ByDomain["gitlab.com"])
+	}
+}
+
+// TestInvalidFiles checks that the invalid files of all the domains are counted, for FAIL_ON_INVALID.
+func TestInvalidFiles(t *testing.T) {
+	var c Crawler
+	c.summary.begin()
+	if n := c.InvalidFiles(); n != 0 {
+		t.Errorf("expected no invalid files, got %d", n)
+	}
+
+	invalid := ValidationErrors{{Reason: "invalid"}}
+	c.summary.count("github.com", validationCount(invalid))
+	c.summary.count("github.com", validationCount(nil))
+	c.summary.count("gitlab.com", validationCount(invalid))
+	if n := c.InvalidFiles(); n != 2 {
+		t.Errorf("expected 2 invalid files, got %d", n)
+	}
+}
+
+// TestCrawlSummaryVendors checks that the distinct vendors with a valid file are counted, from concurrent workers.
+func TestCrawlSummaryVendors(t *testing.T) {
+	var s crawlSummary
+	s.begin()
+
+	names := []string{"italia/a", "italia/b", "agid/a", "group/subgroup/project", "repo"}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, name := range names {
+			wg.Add(1)
+			go func(name string) {
+				defer wg.Done()
+				s.validVendor("github.com", name)
+			}(name)
+		}
+	}
+	wg.Wait()
+
+	// The same vendor on another domain is another organization.
+	if n := s.validVendor("gitlab.com", "italia/a"); n != 5 {
+		t.Errorf("Expected 5 vendors, got %d", n)
+	}
+	if summary := s.snapshot(); summary.Vendors != 5 {
+		t.Errorf("Expected 5 vendors in the summary, got %d", summary.Vendors)
+	}
+}
@@ -0,0 +1,68 @@
+package crawler
+
+import (
+	"context"
+
+	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the crawl. It's a no-op until setupTracing configures an exporter.
+var tracer = otel.Tracer("github.com/italia/developers-italia-backend/crawler")
+
+// tracingEnabled returns true if the spans are exported to the OTLP collector at OTLP_ENDPOINT.
+func tracingEnabled() bool {
+	return viper.GetString("OTLP_ENDPOINT") != ""
+}
+
+// setupTracing exports the spans over OTLP/HTTP to OTLP_ENDPOINT (e.g. "localhost:4318"), if set, and
+// returns the function flushing the queued spans. OTLP_INSECURE disables TLS.
+func setupTracing() (func(context.Context) error, error) {
+	if !tracingEnabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(viper.GetString("OTLP_ENDPOINT"))}
+	if viper.GetBool("OTLP_INSECURE") {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "publiccode-crawler"))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// startRepositorySpan starts the span of the processing of repository, child of the span of the page
+// where it was listed, if any.
+func startRepositorySpan(ctx context.Context, repository Repository) (context.Context, trace.Span) {
+	if repository.spanContext.IsValid() {
+		ctx = trace.ContextWithRemoteSpanContext(ctx, repository.spanContext)
+	}
+	return tracer.Start(ctx, "processRepo", trace.WithAttributes(
+		attribute.String("domain", repository.Domain.Host),
+		attribute.String("repo_name", repository.Name),
+	))
+}
+
+// endSpan ends span, recording err if it's not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,124 @@
+package crawler
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"time"
+
+	publiccode "github.com/italia/publiccode-parser-go"
+	"github.com/spf13/viper"
+)
+
+// ValidationError is a single error found validating a publiccode.yml.
+type ValidationError struct {
+	Key    string `json:"key,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// ValidationErrors is the list of errors found validating a publiccode.yml.
+type ValidationErrors []ValidationError
+
+func (es ValidationErrors) Error() string {
+	var s string
+	for i, e := range es {
+		if i > 0 {
+			s += "\n"
+		}
+		if e.Key != "" {
+			s += e.Key + ": "
+		}
+		s += e.Reason
+	}
+	return s
+}
+
+// Is makes errors.Is match ErrInvalidPublicCode.
+func (es ValidationErrors) Is(target error) bool {
+	return target == ErrInvalidPublicCode
+}
+
+// has returns true if any of the errors is about key.
+func (es ValidationErrors) has(key string) bool {
+	for _, e := range es {
+		if e.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// validationLabel returns the result label of the validation outcome errs: "valid" or "invalid".
+func validationLabel(errs ValidationErrors) string {
+	if len(errs) == 0 {
+		return "valid"
+	}
+	return "invalid"
+}
+
+// newValidationErrors converts the errors returned by the publiccode parser.
+func newValidationErrors(err error) ValidationErrors {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case publiccode.ErrorParseMulti:
+		var es ValidationErrors
+		for _, v := range e {
+			es = append(es, newValidationErrors(v)...)
+		}
+		return es
+	case publiccode.ErrorInvalidValue:
+		return ValidationErrors{{Key: e.Key, Reason: e.Reason}}
+	case publiccode.ErrorInvalidKey:
+		return ValidationErrors{{Key: e.Key, Reason: "invalid key"}}
+	default:
+		return ValidationErrors{{Reason: err.Error()}}
+	}
+}
+
+// validationResult is the validation outcome of a single repository.
+type validationResult struct {
+	Name   string           `json:"name"`
+	Domain string           `json:"domain"`
+	RawURL string           `json:"raw_url"`
+	Valid  bool             `json:"valid"`
+	Errors ValidationErrors `json:"errors,omitempty"`
+	// Problems that don't make the file invalid, e.g. deprecated keys.
+	Warnings ValidationErrors `json:"warnings,omitempty"`
+}
+
+// validationReport collects the validation outcomes of a crawl.
+type validationReport struct {
+	sync.Mutex
+	Date    time.Time          `json:"date"`
+	Results []validationResult `json:"results"`
+}
+
+// add records the validation outcome of repository.
+func (r *validationReport) add(repository Repository, errs, warnings ValidationErrors) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.Results = append(r.Results, validationResult{
+		Name:     repository.Name,
+		Domain:   repository.Domain.Host,
+		RawURL:   repository.FileRawURL,
+		Valid:    len(errs) == 0,
+		Errors:   errs,
+		Warnings: warnings,
+	})
+}
+
+// save writes the report in DATADIR/validation_report.json.
+func (r *validationReport) save() error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.Date = time.Now()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(viper.GetString("CRAWLER_DATADIR"), "validation_report.json"), data, fileMode())
+}
@@ -0,0 +1,52 @@
+package crawler
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	publiccode "github.com/italia/publiccode-parser-go"
+)
+
+// TestNewValidationErrors checks the conversion of the publiccode parser errors.
+func TestNewValidationErrors(t *testing.T) {
+	tests := []struct {
+		in  error
+		out ValidationErrors
+	}{
+		{nil, nil},
+		{errors.New("yaml: line 1"), ValidationErrors{{Reason: "yaml: line 1"}}},
+		{publiccode.ErrorInvalidKey{Key: "foo"}, ValidationErrors{{Key: "foo", Reason: "invalid key"}}},
+		{
+			publiccode.ErrorParseMulti{
+				publiccode.ErrorInvalidValue{Key: "name", Reason: "missing mandatory key"},
+				publiccode.ErrorInvalidKey{Key: "foo"},
+			},
+			ValidationErrors{{Key: "name", Reason: "missing mandatory key"}, {Key: "foo", Reason: "invalid key"}},
+		},
+	}
+
+	for _, test := range tests {
+		if out := newValidationErrors(test.in); !reflect.DeepEqual(out, test.out) {
+			t.Errorf("Expected %v for %v, got %v", test.out, test.in, out)
+		}
+	}
+}
+
+// TestValidationLabel checks the result labels of the validation outcomes.
+func TestValidationLabel(t *testing.T) {
+	tests := []struct {
+		errs  ValidationErrors
+		label string
+	}{
+		{nil, "valid"},
+		{ValidationErrors{}, "valid"},
+		{ValidationErrors{{Key: "name", Reason: "missing mandatory key"}}, "invalid"},
+	}
+
+	for _, test := range tests {
+		if label := validationLabel(test.errs); label != test.label {
+			t.Errorf("Expected %v == %s, got %s", test.errs, test.label, label)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	publiccode "github.com/italia/publiccode-parser-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// Validator validates the crawled files. remoteBaseURL is the url of the directory of the file
+// (or its local path, for a local file), used to resolve its relative urls. The validation stops
+// waiting to be retried when ctx is done.
+type Validator interface {
+	Validate(ctx context.Context, data []byte, remoteBaseURL string) (*publiccode.PublicCode, error)
+}
+
+// warningValidator is implemented by the Validators that also report the warnings of the valid files:
+// problems that are tolerated, e.g. deprecated or unknown keys.
+type warningValidator interface {
+	Warnings(data []byte, remoteBaseURL string) ValidationErrors
+}
+
+// NewValidator returns the Validator configured by name (VALIDATOR):
+// "publiccode" (the default), "strict" or "none".
+func NewValidator(name string) (Validator, error) {
+	if target := targetSpecVersion(); target != "" && !isSupportedVersion(target) {
+		return nil, fmt.Errorf("unsupported PUBLICCODE_TARGET_VERSION %q", target)
+	}
+
+	retries := viper.GetInt("VALIDATION_RETRIES")
+	switch name {
+	case "", "publiccode":
+		return publiccodeValidator{retries: retries}, nil
+	case "strict":
+		return publiccodeValidator{strict: true, retries: retries}, nil
+	case "none":
+		return noopValidator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown validator %q", name)
+	}
+}
+
+// publiccodeValidator validates the files with the publiccode.yml parser.
+type publiccodeValidator struct {
+	// Strict mode of the parser, off tolerates the deprecated and unknown keys.
+	strict bool
+	// Times the validation is repeated if a referenced url can't be reached because of a transient failure.
+	retries int
+}
+
+// Validate parses data as a publiccode.yml. If the check of a referenced url fails because of a network
+// error or a 5xx or 429 response, the validation is repeated up to retries times with exponential backoff.
+// A 404 is a broken reference and it's never retried. If ctx is done while waiting, the last result is returned.
+func (v publiccodeValidator) Validate(ctx context.Context, data []byte, remoteBaseURL string) (*publiccode.PublicCode, error) {
+	for attempt := 0; ; attempt++ {
+		parser := publiccode.NewParser()
+		parser.Strict = v.strict
+		if isRemoteURL(remoteBaseURL) {
+			parser.RemoteBaseURL = remoteBaseURL
+		} else {
+			parser.LocalBasePath = remoteBaseURL
+		}
+
+		err := parser.Parse(data)
+		if attempt >= v.retries || !hasTransientURLCheck(newValidationErrors(err)) {
+			return &parser.PublicCode, err
+		}
+
+		delay := backoffDelay(viper.GetDuration("HTTP_BASE_DELAY"), attempt)
+		log.WithField("remote_base_url", remoteBaseURL).Debugf("url check failed, validating again in %v", delay)
+		if sleepContext(ctx, delay) != nil {
+			return &parser.PublicCode, err
+		}
+	}
+}
+
+// transientURLCheck matches the reasons of the failed url checks of the parser that may succeed if retried.
+var transientURLCheck = regexp.MustCompile(`^HTTP GET (failed for |returned (429|5\d\d) for )`)
+
+// hasTransientURLCheck returns true if any of errs is a url check failed because of a transient failure.
+func hasTransientURLCheck(errs ValidationErrors) bool {
+	for _, e := range errs {
+		if transientURLCheck.MatchString(e.Reason) {
+			return true
+		}
+	}
+	return false
+}
+
+// Warnings returns the errors that the parser would report in strict mode, but not in the tolerant one.
+// The network checks are disabled, since they are the same in both modes.
+func (v publiccodeValidator) Warnings(data []byte, remoteBaseURL string) ValidationErrors {
+	if v.strict {
+		return nil
+	}
+
+	tolerant := make(map[ValidationError]bool)
+	for _, e := range newValidationErrors(v.parse(data, remoteBaseURL, false)) {
+		tolerant[e] = true
+	}
+
+	var warnings ValidationErrors
+	for _, e := range newValidationErrors(v.parse(data, remoteBaseURL, true)) {
+		if !tolerant[e] {
+			warnings = append(warnings, e)
+		}
+	}
+	return warnings
+}
+
+// parse parses data without the network checks.
+func (v publiccodeValidator) parse(data []byte, remoteBaseURL string, strict bool) error {
+	parser := publiccode.NewParser()
+	parser.Strict = strict
+	parser.DisableNetwork = true
+	if isRemoteURL(remoteBaseURL) {
+		parser.RemoteBaseURL = remoteBaseURL
+	} else {
+		parser.LocalBasePath = remoteBaseURL
+	}
+
+	return parser.Parse(data)
+}
+
+// specVersionKey is the key of the warnings of the files declaring a version different from the target one.
+const specVersionKey = "publiccodeYmlVersion"
+
+// targetSpecVersion returns the publiccode.yml version the files are expected to declare
+// (PUBLICCODE_TARGET_VERSION), empty if any supported version is accepted.
+func targetSpecVersion() string {
+	return viper.GetString("PUBLICCODE_TARGET_VERSION")
+}
+
+// isSupportedVersion returns true if the parser supports the publiccode.yml version.
+func isSupportedVersion(version string) bool {
+	for _, v := range publiccode.SupportedVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// specVersionWarning returns a warning if publicCode declares a version different from the target one.
+func specVersionWarning(publicCode *publiccode.PublicCode) ValidationErrors {
+	target := targetSpecVersion()
+	if target == "" || publicCode == nil || publicCode.PubliccodeYamlVersion == "" || publicCode.PubliccodeYamlVersion == target {
+		return nil
+	}
+	return ValidationErrors{{
+		Key:    specVersionKey,
+		Reason: "declared version " + publicCode.PubliccodeYamlVersion + " differs from the target version " + target,
+	}}
+}
+
+// isRemoteURL returns true if pathOrURL is an http(s) url.
+func isRemoteURL(pathOrURL string) bool {
+	return strings.HasPrefix(pathOrURL, "http://") || strings.HasPrefix(pathOrURL, "https://")
+}
+
+// ValidateOne validates a single publiccode.yml, at a local path or an http(s) url, with the configured
+// Validator. It returns the validation errors and warnings, or an error if the file can't be read.
+func ValidateOne(pathOrURL string) (ValidationErrors, ValidationErrors, error) {
+	validator, err := NewValidator(viper.GetString("VALIDATOR"))
+	if err != nil {
+		return nil, nil, err
+	}
+	c := Crawler{validator: validator}
+
+	if isRemoteURL(pathOrURL) {
+		u, err := url.Parse(pathOrURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := getURL(context.Background(), pathOrURL, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.Status.Code != http.StatusOK {
+			return nil, nil, errors.New("request returned an incorrect http.Status: " + resp.Status.Text)
+		}
+		validationErrs, warnings := c.validateRemoteFile(context.Background(), resp.Body, pathOrURL, path.Base(u.Path), PA{})
+		return validationErrs, warnings, nil
+	}
+
+	filePath, err := filepath.Abs(pathOrURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	validationErrs, warnings := c.validateRemoteFile(context.Background(), data, filePath, filepath.Base(filePath), PA{})
+	return validationErrs, warnings, nil
+}
+
+// noopValidator considers any file valid.
+type noopValidator struct{}
+
+// Validate returns no errors and an empty PublicCode.
+func (noopValidator) Validate(ctx context.Context, data []byte, remoteBaseURL string) (*publiccode.PublicCode, error) {
+	return &publiccode.PublicCode{}, nil
+}
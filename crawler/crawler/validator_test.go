@@ -0,0 +1,221 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	publiccode "github.com/italia/publiccode-parser-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// fakeValidator returns the configured PublicCode and error.
+type fakeValidator struct {
+	publicCode publiccode.PublicCode
+	err        error
+}
+
+func (v fakeValidator) Validate(ctx context.Context, data []byte, remoteBaseURL string) (*publiccode.PublicCode, error) {
+	return &v.publicCode, v.err
+}
+
+func TestNewValidator(t *testing.T) {
+	for _, name := range []string{"", "publiccode", "strict", "none"} {
+		if _, err := NewValidator(name); err != nil {
+			t.Errorf("Unexpected error for validator %q: %v", name, err)
+		}
+	}
+	if _, err := NewValidator("foo"); err == nil {
+		t.Errorf("Expected an error for an unknown validator")
+	}
+}
+
+func TestValidateRemoteFile(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	var valid publiccode.PublicCode
+	valid.It.Riuso.CodiceIPA = "c_h501"
+
+	tests := []struct {
+		validator Validator
+		pa        PA
+		errs      int
+	}{
+		{fakeValidator{publicCode: valid}, PA{CodiceIPA: "c_h501"}, 0},
+		{fakeValidator{publicCode: valid}, PA{CodiceIPA: "C_H501"}, 0},
+		{fakeValidator{publicCode: valid}, PA{}, 0},
+		{fakeValidator{publicCode: valid}, PA{CodiceIPA: "pcm"}, 1},
+		{fakeValidator{err: errors.New("invalid")}, PA{}, 1},
+		{noopValidator{}, PA{CodiceIPA: "pcm"}, 0},
+	}
+
+	for _, test := range tests {
+		c := Crawler{validator: test.validator}
+		errs, _ := c.validateRemoteFile(context.Background(), nil, "https://example.org/publiccode.yml", "publiccode.yml", test.pa)
+		if len(errs) != test.errs {
+			t.Errorf("Expected %d errors with %+v, got %v", test.errs, test.pa, errs)
+		}
+	}
+}
+
+// TestSpecVersionWarning checks that the files declaring a version different from PUBLICCODE_TARGET_VERSION
+// are reported with a warning, both if valid and invalid.
+func TestSpecVersionWarning(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	defer viper.Set("PUBLICCODE_TARGET_VERSION", nil)
+
+	var old, current publiccode.PublicCode
+	old.PubliccodeYamlVersion = "0.1"
+	current.PubliccodeYamlVersion = "0.2"
+
+	tests := []struct {
+		target    string
+		validator Validator
+		mismatch  bool
+	}{
+		{"", fakeValidator{publicCode: old}, false},
+		{"0.2", fakeValidator{publicCode: old}, true},
+		{"0.2", fakeValidator{publicCode: old, err: errors.New("invalid")}, true},
+		{"0.2", fakeValidator{publicCode: current}, false},
+		{"0.2", noopValidator{}, false},
+	}
+
+	for _, test := range tests {
+		viper.Set("PUBLICCODE_TARGET_VERSION", test.target)
+		c := Crawler{validator: test.validator}
+		_, warnings := c.validateRemoteFile(context.Background(), nil, "https://example.org/publiccode.yml", "publiccode.yml", PA{})
+		if warnings.has(specVersionKey) != test.mismatch {
+			t.Errorf("Expected version mismatch == %t with target %q, got %v", test.mismatch, test.target, warnings)
+		}
+	}
+
+	viper.Set("PUBLICCODE_TARGET_VERSION", "9.9")
+	if _, err := NewValidator(""); err == nil {
+		t.Errorf("Expected an error for an unsupported target version")
+	}
+}
+
+func TestValidateOne(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("VALIDATOR", "none")
+	defer viper.Set("VALIDATOR", nil)
+
+	if _, _, err := ValidateOne("does-not-exist/publiccode.yml"); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+
+	f, err := ioutil.TempFile("", "publiccode.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	if errs, _, err := ValidateOne(f.Name()); err != nil || errs != nil {
+		t.Errorf("Expected a valid file, got %v (%v)", errs, err)
+	}
+}
+
+func TestPubliccodeValidatorWarnings(t *testing.T) {
+	data := []byte("publiccodeYmlVersion: \"0.2\"\ntags:\n  - deprecated\n")
+
+	warnings := publiccodeValidator{}.Warnings(data, "https://example.org/")
+	if len(warnings) != 1 || !strings.Contains(warnings.Error(), "tags") {
+		t.Errorf("Expected a warning for the deprecated key, got %v", warnings)
+	}
+
+	if warnings := (publiccodeValidator{strict: true}).Warnings(data, "https://example.org/"); warnings != nil {
+		t.Errorf("Expected no warnings in strict mode, got %v", warnings)
+	}
+}
+
+// TestPubliccodeValidatorRetries checks that the validation is repeated if a referenced url can't be
+// reached because of a transient failure, but not if it's not found.
+func TestPubliccodeValidatorRetries(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("HTTP_BASE_DELAY", time.Nanosecond)
+	defer viper.Set("HTTP_BASE_DELAY", nil)
+
+	requests := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		if r.URL.Path == "/unavailable" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	for _, p := range []string{"/unavailable", "/missing"} {
+		data := []byte("publiccodeYmlVersion: \"0.2\"\nlandingURL: " + ts.URL + p + "\n")
+		if _, err := (publiccodeValidator{retries: 2}).Validate(context.Background(), data, ts.URL+"/"); err == nil {
+			t.Errorf("%s: expected a validation error", p)
+		}
+	}
+
+	if requests["/unavailable"] != 3 || requests["/missing"] != 1 {
+		t.Errorf("Unexpected requests %v", requests)
+	}
+}
+
+// TestPubliccodeValidatorRetriesContext checks that the validation is not repeated once the context is done.
+func TestPubliccodeValidatorRetriesContext(t *testing.T) {
+	// Disable log output for this function
+	log.SetOutput(ioutil.Discard)
+
+	viper.Set("HTTP_BASE_DELAY", time.Hour)
+	defer viper.Set("HTTP_BASE_DELAY", nil)
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	data := []byte("publiccodeYmlVersion: \"0.2\"\nlandingURL: " + ts.URL + "/unavailable\n")
+	if _, err := (publiccodeValidator{retries: 2}).Validate(ctx, data, ts.URL+"/"); err == nil {
+		t.Errorf("Expected a validation error")
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("Expected the validation not repeated, got %d requests", n)
+	}
+}
+
+func TestHasTransientURLCheck(t *testing.T) {
+	reasons := []struct {
+		in  string
+		out bool
+	}{
+		{"HTTP GET failed for https://example.org/: context deadline exceeded", true},
+		{"HTTP GET returned 503 for https://example.org/; 200 was expected", true},
+		{"HTTP GET returned 429 for https://example.org/; 200 was expected", true},
+		{"HTTP GET returned 404 for https://example.org/; 200 was expected", false},
+		{"missing URL scheme: example.org", false},
+	}
+
+	for _, r := range reasons {
+		if out := hasTransientURLCheck(ValidationErrors{{Key: "landingURL", Reason: r.in}}); out != r.out {
+			t.Errorf("Expected %t for %q", r.out, r.in)
+		}
+	}
+}
@@ -135,6 +135,18 @@ const (
         "type": "keyword",
         "index": true
       },
+      "source": {
+        "type": "keyword",
+        "index": true
+      },
+      "vendor": {
+        "type": "keyword",
+        "index": true
+      },
+      "repo": {
+        "type": "keyword",
+        "index": true
+      },
       "crawltime": {
         "type": "date",
         "index": false
@@ -1,9 +1,22 @@
 package httpclient
 
 import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -45,6 +58,391 @@ func TestGetUrl(t *testing.T) {
 	}
 }
 
+// TestGetUrlWithContextTimeout should test if a request is cancelled when the context deadline expires.
+func TestGetUrlWithContextTimeout(t *testing.T) {
+	// The handler returns only when the client cancels the request.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := GetURLWithContext(ctx, ts.URL, nil)
+	if err == nil || resp.Status.Code != -1 {
+		t.Errorf("Expected a timeout error, got: %v (status %d)", err, resp.Status.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the request to be cancelled after 100ms, took %v", elapsed)
+	}
+}
+
+// TestGetUrlWithProxy should test if the requests go through the proxy set with SetProxy, with its credentials.
+func TestGetUrlWithProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String() + " " + r.Header.Get("Proxy-Authorization")
+		fmt.Fprint(w, "{\"data\": \"example data\"}")
+	}))
+	defer proxy.Close()
+	defer func() { transport.Proxy = http.ProxyFromEnvironment }()
+
+	u, _ := url.Parse(proxy.URL)
+	u.User = url.UserPassword("user", "password")
+	if err := SetProxy(u.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := GetURL("http://example.invalid/publiccode.yml", nil)
+	if err != nil || resp.Status.Code != http.StatusOK {
+		t.Errorf("Call through the proxy failed, got: %v (status %d)", err, resp.Status.Code)
+	}
+	r := "http://example.invalid/publiccode.yml Basic dXNlcjpwYXNzd29yZA=="
+	if proxied != r {
+		t.Errorf("Proxy request was incorrect, got: %s, want: %s.", proxied, r)
+	}
+}
+
+// TestGetUrlConnectionReuse should test if the requests to the same host reuse the connection,
+// and if SetIdleConns keeps the defaults for the values not set.
+func TestGetUrlConnectionReuse(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(handlerOneRepoList))
+	var conns int32
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := GetURL(ts.URL, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("Expected the connection reused, got %d connections", n)
+	}
+
+	defer SetIdleConns(defaultMaxIdleConns, defaultMaxIdleConnsPerHost, defaultIdleConnTimeout)
+	SetIdleConns(0, 8, 0)
+	if transport.MaxIdleConns != defaultMaxIdleConns || transport.MaxIdleConnsPerHost != 8 || transport.IdleConnTimeout != defaultIdleConnTimeout {
+		t.Errorf("Unexpected idle connections %d, %d per host, for %v",
+			transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+}
+
+// TestGetUrlNotFoundConnectionReuse should test if the connection is reused after the 404 responses.
+func TestGetUrlNotFoundConnectionReuse(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(http.NotFound))
+	var conns int32
+	ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	for i := 0; i < 5; i++ {
+		if resp, err := GetURL(ts.URL, nil); err == nil || resp.Status.Code != http.StatusNotFound {
+			t.Fatalf("Expected a 404, got: %v (status %d)", err, resp.Status.Code)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("Expected the connection reused after the 404 responses, got %d connections", n)
+	}
+}
+
+// TestGetUrlWithCACertFile should test if the certificates of the bundle set with SetCACertFile are trusted,
+// and if SetInsecureSkipVerify accepts any certificate.
+func TestGetUrlWithCACertFile(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(handlerOneRepoList))
+	defer ts.Close()
+	defer func() { transport.TLSClientConfig = nil }()
+
+	if _, err := GetURL(ts.URL, nil); err == nil {
+		t.Fatal("Expected the certificate of the test server not to be trusted")
+	}
+
+	file, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	err = pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCACertFile(file.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := GetURL(ts.URL, nil); err != nil || resp.Status.Code != http.StatusOK {
+		t.Errorf("Expected the certificate trusted, got: %v (status %d)", err, resp.Status.Code)
+	}
+
+	transport.TLSClientConfig = nil
+	transport.CloseIdleConnections()
+	SetInsecureSkipVerify()
+	if resp, err := GetURL(ts.URL, nil); err != nil || resp.Status.Code != http.StatusOK {
+		t.Errorf("Expected the certificate accepted without verification, got: %v (status %d)", err, resp.Status.Code)
+	}
+
+	if err := SetCACertFile(os.DevNull); err == nil {
+		t.Error("Expected an error for a bundle without certificates")
+	}
+}
+
+// TestGetUrlWithMaxBodySize should test if the bodies larger than the maximum size are rejected,
+// with and without a Content-Length.
+func TestGetUrlWithMaxBodySize(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("chunked") != "" {
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "{\"data\": \"example data\"}")
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		url  string
+		size int64
+		err  error
+	}{
+		{ts.URL, 10, ErrBodyTooLarge},
+		{ts.URL + "?chunked=1", 10, ErrBodyTooLarge},
+		{ts.URL, 24, nil},
+		{ts.URL + "?chunked=1", 24, nil},
+	}
+
+	for _, test := range tests {
+		resp, err := GetURLWithContext(WithMaxBodySize(context.Background(), test.size), test.url, nil)
+		if err != test.err {
+			t.Errorf("%s with max size %d: expected error %v, got %v", test.url, test.size, test.err, err)
+		}
+		if err == nil && len(resp.Body) != 24 {
+			t.Errorf("%s with max size %d: unexpected body %s", test.url, test.size, resp.Body)
+		}
+	}
+}
+
+// TestGetUrlWithRedirects should test if the redirects are followed up to the maximum, returning the final url,
+// and if the loops are failures.
+func TestGetUrlWithRedirects(t *testing.T) {
+	defer SetMaxRedirects(10)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/file", http.StatusMovedPermanently)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			fmt.Fprint(w, "{\"data\": \"example data\"}")
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		url          string
+		maxRedirects int
+		code         int
+		finalURL     string
+		err          error
+	}{
+		{ts.URL + "/a", 10, http.StatusOK, ts.URL + "/file", nil},
+		{ts.URL + "/a", 2, http.StatusOK, ts.URL + "/file", nil},
+		{ts.URL + "/a", 1, -1, "", ErrTooManyRedirects},
+		{ts.URL + "/a", 0, http.StatusFound, ts.URL + "/a", nil},
+		{ts.URL + "/loop", 10, -1, "", ErrRedirectLoop},
+	}
+
+	for _, test := range tests {
+		SetMaxRedirects(test.maxRedirects)
+		resp, err := GetURL(test.url, nil)
+		if (test.err != nil && !errors.Is(err, test.err)) || resp.Status.Code != test.code || resp.URL != test.finalURL {
+			t.Errorf("%s with %d redirects: expected %d from %s (%v), got %d from %s (%v)",
+				test.url, test.maxRedirects, test.code, test.finalURL, test.err, resp.Status.Code, resp.URL, err)
+		}
+	}
+}
+
+// TestGetUrlWithUserAgent should test if the User-Agent is the default one, the one set with SetUserAgent
+// or the one of the context, in order of precedence.
+func TestGetUrlWithUserAgent(t *testing.T) {
+	defer SetUserAgent("")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.UserAgent())
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		global    string
+		ctx       string
+		userAgent string
+	}{
+		{"", "", "developers-italia-crawler/unset"},
+		{"crawler (+mailto:admin@example.org)", "", "crawler (+mailto:admin@example.org)"},
+		{"crawler (+mailto:admin@example.org)", "domain-crawler", "domain-crawler"},
+	}
+
+	for _, test := range tests {
+		SetUserAgent(test.global)
+		resp, err := GetURLWithContext(WithUserAgent(context.Background(), test.ctx), ts.URL, nil)
+		if err != nil || string(resp.Body) != test.userAgent {
+			t.Errorf("Expected User-Agent %q, got %q (%v)", test.userAgent, resp.Body, err)
+		}
+	}
+}
+
+// TestGetUrlWithCompression should test if the gzip and deflate encoded bodies are decompressed.
+func TestGetUrlWithCompression(t *testing.T) {
+	const body = "publiccodeYmlVersion: \"0.2\"\n"
+
+	tests := []struct {
+		encoding string
+		compress func(w io.Writer) io.WriteCloser
+	}{
+		{"gzip", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+		{"deflate", func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }},
+		{"deflate", func(w io.Writer) io.WriteCloser { fw, _ := flate.NewWriter(w, flate.DefaultCompression); return fw }},
+	}
+
+	for _, test := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.Contains(r.Header.Get("Accept-Encoding"), test.encoding) {
+				fmt.Fprint(w, body)
+				return
+			}
+			w.Header().Set("Content-Encoding", test.encoding)
+			cw := test.compress(w)
+			fmt.Fprint(cw, body)
+			cw.Close()
+		}))
+
+		resp, err := GetURL(ts.URL, nil)
+		if err != nil || string(resp.Body) != body || resp.Headers.Get("Content-Encoding") != "" {
+			t.Errorf("Expected %s body to be decompressed, got %q (%v)", test.encoding, resp.Body, err)
+		}
+		ts.Close()
+	}
+}
+
+// TestGetUrlWithoutRetryAfterWait should test if the 429 responses with a Retry-After are returned.
+func TestGetUrlWithoutRetryAfterWait(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	resp, err := GetURLWithContext(WithoutRetryAfterWait(context.Background()), ts.URL, nil)
+	if err == nil || resp.Status.Code != http.StatusTooManyRequests || resp.Headers.Get("Retry-After") != "3600" {
+		t.Errorf("Expected the 429 response to be returned, got %d (%v)", resp.Status.Code, err)
+	}
+}
+
+// TestGetUrlWithRateLimited should test if the rate limit responses retried are notified.
+func TestGetUrlWithRateLimited(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&requests, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.Header().Set("X-RateLimit-Reset", "0")
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+
+	var rateLimited int
+	resp, err := GetURLWithContext(WithRateLimited(context.Background(), func() { rateLimited++ }), ts.URL, nil)
+	if err != nil || resp.Status.Code != http.StatusOK {
+		t.Fatalf("Expected the request retried until 200, got %d (%v)", resp.Status.Code, err)
+	}
+	if rateLimited != 2 {
+		t.Errorf("Expected 2 rate limit responses notified, got %d", rateLimited)
+	}
+}
+
+// TestGetUrlRateLimitContext checks that the backoff of the 429 responses without a Retry-After stops
+// when the context is done.
+func TestGetUrlRateLimitContext(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := GetURLWithContext(ctx, ts.URL, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || resp.Status.Code != -1 {
+		t.Errorf("Expected the context error, got %d (%v)", resp.Status.Code, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the backoff stopped with the context, returned after %v", elapsed)
+	}
+}
+
+// TestGetUrlForbidden checks that a 403 not due to the rate limit is returned with its status and ErrForbidden,
+// without retrying it.
+func TestGetUrlForbidden(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("X-RateLimit-Reset", "0")
+		w.Header().Set("X-RateLimit-Remaining", "10")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	resp, err := GetURLWithContext(context.Background(), ts.URL, nil)
+	if !errors.Is(err, ErrForbidden) || resp.Status.Code != http.StatusForbidden || resp.Headers.Get("X-RateLimit-Remaining") != "10" {
+		t.Errorf("Expected the 403 response with ErrForbidden, got %d (%v)", resp.Status.Code, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("Expected 1 request, got %d", n)
+	}
+}
+
+// TestHeadURL checks that the HEAD responses are returned with their status and headers, whatever the status.
+func TestHeadURL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "HEAD" {
+			t.Errorf("Expected a HEAD request, got %s", r.Method)
+		}
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+	}))
+	defer ts.Close()
+
+	resp, err := HeadURLWithContext(context.Background(), ts.URL+"/publiccode.yml", nil)
+	if err != nil || resp.Status.Code != http.StatusOK || resp.Headers.Get("ETag") != `"v1"` || resp.Body != nil {
+		t.Errorf("Expected a 200 response without body, got %d %v (%v)", resp.Status.Code, resp.Body, err)
+	}
+	resp, err = HeadURLWithContext(context.Background(), ts.URL+"/missing", nil)
+	if err != nil || resp.Status.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 response, got %d (%v)", resp.Status.Code, err)
+	}
+}
+
 // TestIncorrectProtocolUrl should test if a getUrl to incorrect protocol url will fail.
 func TestIncorrectProtocolUrl(t *testing.T) {
 	resp, err := GetURL("hktp://incorrectprotocol.url", nil)
@@ -1,10 +1,18 @@
 package httpclient
 
 import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -22,15 +30,97 @@ const (
 	headerRateRemaining = "X-RateLimit-Remaining"
 )
 
-// statusOK returns an HTTPResponse with the data from response.
-func statusOK(resp *http.Response) (HTTPResponse, error) {
-	body, err := ioutil.ReadAll(resp.Body)
+// responseURL returns the url of the request of resp, after the redirects.
+func responseURL(resp *http.Response) string {
+	if resp.Request == nil {
+		return ""
+	}
+	return resp.Request.URL.String()
+}
+
+// ErrBodyTooLarge is returned when the response body exceeds the size set with WithMaxBodySize.
+var ErrBodyTooLarge = errors.New("response body too large")
+
+// ErrForbidden is returned with a 403 response that is not due to the rate limit (e.g. for a token
+// without permissions), it's not retried.
+var ErrForbidden = errors.New("forbidden resource")
+
+// ErrRateLimited is returned with the last 429 or 403 response when the rate limit is still exceeded
+// after the retries.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// decodeBody returns a reader of the decompressed body of resp, if it has a gzip or deflate Content-Encoding.
+// The Content-Encoding and Content-Length headers are then removed, since they don't match the body anymore.
+func decodeBody(resp *http.Response) (io.Reader, error) {
+	var reader io.Reader
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		r, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		reader = r
+	case "deflate":
+		// The deflate encoding should be zlib wrapped, but some servers send the raw stream.
+		buffered := bufio.NewReader(resp.Body)
+		if header, err := buffered.Peek(2); err == nil && isZlibHeader(header) {
+			r, err := zlib.NewReader(buffered)
+			if err != nil {
+				return nil, err
+			}
+			reader = r
+		} else {
+			reader = flate.NewReader(buffered)
+		}
+	default:
+		return resp.Body, nil
+	}
+
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	return reader, nil
+}
+
+// isZlibHeader returns true if header are the first two bytes of a zlib stream (RFC 1950).
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+// statusOK returns an HTTPResponse with the data from response, decompressed if it's gzip or deflate encoded.
+// If maxBodySize is greater than 0, bodies larger than maxBodySize bytes are not read
+// and ErrBodyTooLarge is returned.
+func statusOK(resp *http.Response, maxBodySize int64) (HTTPResponse, error) {
+	if maxBodySize > 0 && resp.ContentLength > maxBodySize {
+		return statusBodyTooLarge(resp)
+	}
+
+	reader, err := decodeBody(resp)
 	if err != nil {
 		log.Errorf(err.Error())
+		resp.Body.Close()
 		return HTTPResponse{
 			Body:    nil,
 			Status:  ResponseStatus{Text: resp.Status, Code: resp.StatusCode},
 			Headers: resp.Header,
+			URL:     responseURL(resp),
+		}, err
+	}
+	if maxBodySize > 0 {
+		// Read one more byte to know if the body exceeds the limit.
+		reader = io.LimitReader(reader, maxBodySize+1)
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err == nil && maxBodySize > 0 && int64(len(body)) > maxBodySize {
+		return statusBodyTooLarge(resp)
+	}
+	if err != nil {
+		log.Errorf(err.Error())
+		return HTTPResponse{
+			Body:    nil,
+			Status:  ResponseStatus{Text: resp.Status, Code: resp.StatusCode},
+			Headers: resp.Header,
+			URL:     responseURL(resp),
 		}, err
 	}
 
@@ -43,20 +133,106 @@ func statusOK(resp *http.Response) (HTTPResponse, error) {
 		Body:    body,
 		Status:  ResponseStatus{Text: resp.Status, Code: resp.StatusCode},
 		Headers: resp.Header,
+		URL:     responseURL(resp),
 	}, nil
 }
 
-// statusNotFound returns an HTTPResponse with the data from response.
+// statusBodyTooLarge returns an HTTPResponse with the status and headers from a response with a body too large.
+func statusBodyTooLarge(resp *http.Response) (HTTPResponse, error) {
+	err := resp.Body.Close()
+	if err != nil {
+		log.Errorf(err.Error())
+	}
+
+	return HTTPResponse{
+		Body:    nil,
+		Status:  ResponseStatus{Text: resp.Status, Code: resp.StatusCode},
+		Headers: resp.Header,
+		URL:     responseURL(resp),
+	}, ErrBodyTooLarge
+}
+
+// maxDrainSize is the largest body read, and discarded, before closing a response that is not returned,
+// so that its connection is reused. The connections of the larger bodies are closed.
+const maxDrainSize = 64 << 10
+
+// drainBody reads up to maxDrainSize bytes of the body of resp and closes it.
+func drainBody(resp *http.Response) error {
+	_, err := io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainSize))
+	closeErr := resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// statusNotFound returns an HTTPResponse with the data from response. Its body (e.g. an error page)
+// is discarded, so that the connection is reused for the next requests.
 func statusNotFound(resp *http.Response) (HTTPResponse, error) {
+	err := drainBody(resp)
+	if err != nil {
+		log.Errorf(err.Error())
+	}
+
 	return HTTPResponse{
 		Body:    nil,
 		Status:  ResponseStatus{Text: resp.Status, Code: resp.StatusCode},
 		Headers: resp.Header,
+		URL:     responseURL(resp),
 	}, fmt.Errorf("not found")
 }
 
-// statusTooManyRequests returns an HTTPResponse with the data from response.
-func statusTooManyRequests(resp *http.Response, expBackoffAttempts int) (int, error) {
+// statusNotModified returns an HTTPResponse with the status and headers from response.
+func statusNotModified(resp *http.Response) (HTTPResponse, error) {
+	err := resp.Body.Close()
+	if err != nil {
+		log.Errorf(err.Error())
+	}
+
+	return HTTPResponse{
+		Body:    nil,
+		Status:  ResponseStatus{Text: resp.Status, Code: resp.StatusCode},
+		Headers: resp.Header,
+		URL:     responseURL(resp),
+	}, nil
+}
+
+// statusUnhandled returns an HTTPResponse with the status and headers from a response that is not handled.
+func statusUnhandled(resp *http.Response) (HTTPResponse, error) {
+	return statusRejected(resp, fmt.Errorf("unexpected status: %s", resp.Status))
+}
+
+// statusRejected returns an HTTPResponse with the status and headers from a response rejected with err.
+func statusRejected(resp *http.Response, err error) (HTTPResponse, error) {
+	closeErr := resp.Body.Close()
+	if closeErr != nil {
+		log.Errorf(closeErr.Error())
+	}
+
+	return HTTPResponse{
+		Body:    nil,
+		Status:  ResponseStatus{Text: resp.Status, Code: resp.StatusCode},
+		Headers: resp.Header,
+		URL:     responseURL(resp),
+	}, err
+}
+
+// sleepContext waits for d, or until ctx is done returning its error.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// statusTooManyRequests waits before retrying a 429 response, as long as asked by its Retry-After or with
+// exponential backoff. It returns the context error if ctx is done while waiting.
+func statusTooManyRequests(ctx context.Context, resp *http.Response, expBackoffAttempts int) (int, error) {
 	// If Retry-after Header is set, use the header value.
 	if retryAfter := resp.Header.Get(headerRetryAfter); retryAfter != "" {
 		log.Infof("Waiting: %s seconds. (The value of %s)", retryAfter, headerRetryAfter)
@@ -64,21 +240,20 @@ func statusTooManyRequests(resp *http.Response, expBackoffAttempts int) (int, er
 		if err != nil {
 			log.Warn(err)
 		}
-		time.Sleep(time.Second * time.Duration(secondsAfterRetry))
-		return expBackoffAttempts, nil
+		return expBackoffAttempts, sleepContext(ctx, time.Second*time.Duration(secondsAfterRetry))
 	}
 	// Calculate ExpBackoff
 	expBackoffWait := expBackoffCalc(expBackoffAttempts)
 	// Perform a backoff sleep time.
 	sleep := time.Duration(expBackoffWait) * time.Second
 	log.Infof("Rate limit reached, sleep %v \n", sleep)
-	time.Sleep(sleep)
 
-	return expBackoffAttempts + 1, nil
+	return expBackoffAttempts + 1, sleepContext(ctx, sleep)
 }
 
-// statusForbidden returns an HTTPResponse with the data from response.
-func statusForbidden(resp *http.Response, expBackoffAttempts int) (int, error) {
+// statusForbidden waits before retrying a 403 response for the rate limit, until its Retry-After or X-RateLimit-Reset.
+// It returns ErrForbidden if the 403 is not for the rate limit, the context error if ctx is done while waiting.
+func statusForbidden(ctx context.Context, resp *http.Response, expBackoffAttempts int) (int, error) {
 	// If Retry-after is set, use that value.
 	if retryAfter := resp.Header.Get(headerRetryAfter); retryAfter != "" {
 		log.Infof("Waiting: %s seconds. (The value of %s)", retryAfter, headerRetryAfter)
@@ -86,21 +261,20 @@ func statusForbidden(resp *http.Response, expBackoffAttempts int) (int, error) {
 		if err != nil {
 			log.Warn(err)
 		}
-		time.Sleep(time.Second * time.Duration(secondsAfterRetry))
-		return expBackoffAttempts, nil
+		return expBackoffAttempts, sleepContext(ctx, time.Second*time.Duration(secondsAfterRetry))
 	}
 
 	// If X-rateLimit-remaining
 	if reset := resp.Header.Get(headerRateReset); reset != "" {
 		// If X-RateLimit-Remaining is set
-		if remaining := resp.Header.Get(headerRateRemaining); reset != "" {
+		if remaining := resp.Header.Get(headerRateRemaining); remaining != "" {
 			rateRemaining, err := strconv.Atoi(remaining)
 			if err != nil {
 				log.Warn(err)
 			}
 			if rateRemaining != 0 {
 				// In this case there is another StatusForbidden and i should skip.
-				return expBackoffAttempts, fmt.Errorf("forbidden resource")
+				return expBackoffAttempts, ErrForbidden
 			}
 
 			retryEpoch, err := strconv.Atoi(reset)
@@ -109,12 +283,11 @@ func statusForbidden(resp *http.Response, expBackoffAttempts int) (int, error) {
 			}
 			secondsAfterRetry := int64(retryEpoch) - time.Now().Unix()
 			log.Infof("Waiting %s seconds for %s. (The difference between header %s and time.Now())", strconv.FormatInt(secondsAfterRetry, 10), headerRateReset, reset)
-			time.Sleep(time.Second * time.Duration(secondsAfterRetry))
-			return expBackoffAttempts, nil
+			return expBackoffAttempts, sleepContext(ctx, time.Second*time.Duration(secondsAfterRetry))
 		}
 	}
 
 	// Generic forbidden.
-	return expBackoffAttempts, fmt.Errorf("forbidden resource")
+	return expBackoffAttempts, ErrForbidden
 
 }
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"github.com/italia/developers-italia-backend/crawler/crawler"
 	"github.com/italia/developers-italia-backend/crawler/cmd"
+	"github.com/italia/developers-italia-backend/crawler/httpclient"
+	"github.com/italia/developers-italia-backend/crawler/version"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -15,10 +17,58 @@ func main() {
 	// Read configurations.
 	viper.SetConfigName("config")
 	viper.AddConfigPath(".")
+	viper.SetDefault("CRAWLER_DATADIR", "./data")
 	err := viper.ReadInConfig()
 	if err != nil {
 		panic(fmt.Errorf("fatal error reding config file: %s", err))
 	}
+	// DATA_DIR is the same setting as CRAWLER_DATADIR, and it wins if both are set.
+	viper.RegisterAlias("DATA_DIR", "CRAWLER_DATADIR")
+
+	// Use JSON logs if requested (e.g. when shipping them to Elasticsearch).
+	if viper.GetBool("LOG_JSON") {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+
+	log.WithFields(log.Fields{
+		"version": version.VERSION,
+		"commit":  version.COMMIT,
+		"date":    version.DATE,
+	}).Info("Starting the crawler")
+
+	// Send the requests through an explicit proxy, rather than the one from the environment.
+	if viper.GetString("HTTP_PROXY_URL") != "" {
+		err = httpclient.SetProxy(viper.GetString("HTTP_PROXY_URL"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Trust the certificates of a private CA (e.g. of a self-hosted instance), in addition to the system ones.
+	if viper.GetString("CA_CERT_FILE") != "" {
+		err = httpclient.SetCACertFile(viper.GetString("CA_CERT_FILE"))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if viper.GetBool("INSECURE_SKIP_VERIFY") {
+		log.Warn("INSECURE_SKIP_VERIFY is set: the TLS certificates are NOT verified, this is unsafe outside of the test environments")
+		httpclient.SetInsecureSkipVerify()
+	}
+
+	// Identify the crawler to the providers.
+	if viper.GetString("USER_AGENT") != "" {
+		httpclient.SetUserAgent(viper.GetString("USER_AGENT"))
+	}
+
+	// Limit the redirects followed, 0 doesn't follow them.
+	if viper.IsSet("HTTP_MAX_REDIRECTS") {
+		httpclient.SetMaxRedirects(viper.GetInt("HTTP_MAX_REDIRECTS"))
+	}
+
+	// Keep the connections alive for reuse, by default 100 idle connections, 32 for each host, for 90s.
+	httpclient.SetIdleConns(viper.GetInt("HTTP_MAX_IDLE_CONNS"), viper.GetInt("HTTP_MAX_IDLE_CONNS_PER_HOST"),
+		viper.GetDuration("HTTP_IDLE_CONN_TIMEOUT"))
 
 	// Register client APIs.
 	crawler.RegisterClientAPIs()
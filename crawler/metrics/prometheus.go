@@ -1,8 +1,10 @@
 package metrics
 
 import (
+	"fmt"
 	"net/http"
 	"regexp"
+	"sync/atomic"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -12,6 +14,18 @@ import (
 // Map of all the registered Counters.
 var registeredCounters = make(map[string]prometheus.Counter)
 
+// Map of all the registered labeled Counters.
+var registeredCounterVecs = make(map[string]*prometheus.CounterVec)
+
+// Map of all the registered Gauges.
+var registeredGauges = make(map[string]prometheus.Gauge)
+
+// Map of all the registered labeled Gauges.
+var registeredGaugeVecs = make(map[string]*prometheus.GaugeVec)
+
+// Map of all the registered labeled Histograms.
+var registeredHistogramVecs = make(map[string]*prometheus.HistogramVec)
+
 // Valid regex for prometheus model name.
 // (Prometheus model reference: https://github.com/prometheus/common)
 const validPrometheusName = "[^a-zA-Z_][^a-zA-Z0-9_]*"
@@ -48,10 +62,172 @@ func RegisterPrometheusCounter(name, helpText, namespace string) {
 	}
 }
 
+// GetCounterVec return the prometheus labeled counter of given name.
+func GetCounterVec(name, namespace string, labels ...string) *prometheus.CounterVec {
+	// Validate and fix name (replace invalid chars with underscore "_").
+	name = validateAndFix(name)
+	if registeredCounterVecs[name] == nil {
+		log.Errorf("Error in metrics GetCounterVec: %s does not exist", name)
+		// If registeredCounterVecs[name] does not exists a new counter is created and returned.
+		RegisterPrometheusCounterVec(name, "Autogenerated counter "+name, namespace, labels...)
+		log.Warningf("Autogenerated: %s that does not exist", name)
+	}
+
+	return registeredCounterVecs[name]
+}
+
+// RegisterPrometheusCounterVec register a new Counter of given name with help text, partitioned by labels.
+func RegisterPrometheusCounterVec(name, helpText, namespace string, labels ...string) {
+	// Validate and fix name (replace invalid chars with underscore "_").
+	name = validateAndFix(name)
+
+	// Add counter in the map.
+	registeredCounterVecs[name] = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name:      name,
+		Namespace: "publiccode_crawler_" + namespace,
+		Help:      helpText,
+	}, labels)
+	// Register counter in Prometheus service.
+	err := prometheus.Register(registeredCounterVecs[name])
+	if err != nil {
+		log.Warningf("Error in metrics RegisterPrometheusCounterVec: %v", err)
+	}
+}
+
+// GetGauge return the prometheus gauge of given name.
+func GetGauge(name, namespace string) prometheus.Gauge {
+	// Validate and fix name (replace invalid chars with underscore "_").
+	name = validateAndFix(name)
+	if registeredGauges[name] == nil {
+		log.Errorf("Error in metrics GetGauge: %s does not exist", name)
+		// If registeredGauges[name] does not exists a new gauge is created and returned.
+		RegisterPrometheusGauge(name, "Autogenerated gauge "+name, namespace)
+		log.Warningf("Autogenerated: %s that does not exist", name)
+	}
+
+	return registeredGauges[name]
+}
+
+// RegisterPrometheusGauge register a new Gauge of given name with help text.
+func RegisterPrometheusGauge(name, helpText, namespace string) {
+	// Validate and fix name (replace invalid chars with underscore "_").
+	name = validateAndFix(name)
+
+	// Add gauge in the map.
+	registeredGauges[name] = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:      name,
+		Namespace: "publiccode_crawler_" + namespace,
+		Help:      helpText,
+	})
+	// Register gauge in Prometheus service.
+	err := prometheus.Register(registeredGauges[name])
+	if err != nil {
+		log.Warningf("Error in metrics RegisterPrometheusGauge: %v", err)
+	}
+}
+
+// GetGaugeVec return the prometheus labeled gauge of given name.
+func GetGaugeVec(name, namespace string, labels ...string) *prometheus.GaugeVec {
+	// Validate and fix name (replace invalid chars with underscore "_").
+	name = validateAndFix(name)
+	if registeredGaugeVecs[name] == nil {
+		log.Errorf("Error in metrics GetGaugeVec: %s does not exist", name)
+		// If registeredGaugeVecs[name] does not exists a new gauge is created and returned.
+		RegisterPrometheusGaugeVec(name, "Autogenerated gauge "+name, namespace, labels...)
+		log.Warningf("Autogenerated: %s that does not exist", name)
+	}
+
+	return registeredGaugeVecs[name]
+}
+
+// RegisterPrometheusGaugeVec register a new Gauge of given name with help text, partitioned by labels.
+func RegisterPrometheusGaugeVec(name, helpText, namespace string, labels ...string) {
+	// Validate and fix name (replace invalid chars with underscore "_").
+	name = validateAndFix(name)
+
+	// Add gauge in the map.
+	registeredGaugeVecs[name] = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:      name,
+		Namespace: "publiccode_crawler_" + namespace,
+		Help:      helpText,
+	}, labels)
+	// Register gauge in Prometheus service.
+	err := prometheus.Register(registeredGaugeVecs[name])
+	if err != nil {
+		log.Warningf("Error in metrics RegisterPrometheusGaugeVec: %v", err)
+	}
+}
+
+// GetHistogramVec return the prometheus labeled histogram of given name.
+func GetHistogramVec(name, namespace string, labels ...string) *prometheus.HistogramVec {
+	// Validate and fix name (replace invalid chars with underscore "_").
+	name = validateAndFix(name)
+	if registeredHistogramVecs[name] == nil {
+		log.Errorf("Error in metrics GetHistogramVec: %s does not exist", name)
+		// If registeredHistogramVecs[name] does not exists a new histogram is created and returned.
+		RegisterPrometheusHistogramVec(name, "Autogenerated histogram "+name, namespace, prometheus.DefBuckets, labels...)
+		log.Warningf("Autogenerated: %s that does not exist", name)
+	}
+
+	return registeredHistogramVecs[name]
+}
+
+// RegisterPrometheusHistogramVec register a new Histogram of given name with help text and buckets,
+// partitioned by labels.
+func RegisterPrometheusHistogramVec(name, helpText, namespace string, buckets []float64, labels ...string) {
+	// Validate and fix name (replace invalid chars with underscore "_").
+	name = validateAndFix(name)
+
+	// Add histogram in the map.
+	registeredHistogramVecs[name] = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:      name,
+		Namespace: "publiccode_crawler_" + namespace,
+		Help:      helpText,
+		Buckets:   buckets,
+	}, labels)
+	// Register histogram in Prometheus service.
+	err := prometheus.Register(registeredHistogramVecs[name])
+	if err != nil {
+		log.Warningf("Error in metrics RegisterPrometheusHistogramVec: %v", err)
+	}
+}
+
+// ready is set to 1 by SetReady once the dependencies of the process are reachable.
+var ready int32
+
+// SetReady sets the state returned by the "/ready" endpoint.
+func SetReady(isReady bool) {
+	var v int32
+	if isReady {
+		v = 1
+	}
+	atomic.StoreInt32(&ready, v)
+}
+
+// healthHandler always returns 200 while the process runs (liveness probe).
+func healthHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "ok")
+}
+
+// readyHandler returns 200 only once SetReady(true) was called (readiness probe).
+func readyHandler(w http.ResponseWriter, _ *http.Request) {
+	if atomic.LoadInt32(&ready) == 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "not ready")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "ready")
+}
+
 // StartPrometheusMetricsServer starts a metric server handling
-// "/metrics" on "localhost:8081" exposing the registered metrics.
+// "/metrics" on "localhost:8081" exposing the registered metrics,
+// "/health" and "/ready" for the liveness and readiness probes.
 func StartPrometheusMetricsServer() {
 	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/health", healthHandler)
+	http.HandleFunc("/ready", readyHandler)
 
 	err := http.ListenAndServe(":8081", nil)
 	if err != nil {
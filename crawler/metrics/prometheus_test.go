@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestReadyHandler checks that "/ready" returns 200 only after SetReady(true).
+func TestReadyHandler(t *testing.T) {
+	states := []struct {
+		ready bool
+		code  int
+	}{
+		{false, http.StatusServiceUnavailable},
+		{true, http.StatusOK},
+	}
+
+	for _, s := range states {
+		SetReady(s.ready)
+		w := httptest.NewRecorder()
+		readyHandler(w, httptest.NewRequest("GET", "/ready", nil))
+		if w.Code != s.code {
+			t.Errorf("Expected %d when ready is %t, got %d", s.code, s.ready, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	healthHandler(w, httptest.NewRequest("GET", "/health", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected %d from /health, got %d", http.StatusOK, w.Code)
+	}
+}
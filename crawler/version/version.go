@@ -2,3 +2,9 @@ package version
 
 // VERSION is the current application version.
 var VERSION = "unset"
+
+// COMMIT is the git commit the application was built from.
+var COMMIT = "unset"
+
+// DATE is the build date of the application.
+var DATE = "unset"